@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
@@ -13,32 +14,65 @@ import (
 	"github.com/solo-io/istio-usage-collector/internal/logging"
 	"github.com/solo-io/istio-usage-collector/internal/utils"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 type CommandFlags struct {
-	HideNames          bool
-	ContinueProcessing bool
-	KubeContext        string
-	OutputDir          string
-	OutputFormat       string
-	OutputFilePrefix   string
-	EnableDebug        bool
-	NoProgress         bool
-	MaxProcessors      int
+	HideNames             bool
+	ContinueProcessing    bool
+	KubeContext           string
+	OutputDir             string
+	OutputFormat          string
+	OutputFilePrefix      string
+	EnableDebug           bool
+	NoProgress            bool
+	MaxProcessors         int
+	IncludeNonRunningPods bool
+	IncludeWorkloads      bool
+	PrometheusURL         string
+	PrometheusLookback    time.Duration
+	PrometheusToken       string
+	PrometheusUsername    string
+	PrometheusPassword    string
+	SampleDuration        time.Duration
+	SampleInterval        time.Duration
+	EnvoyStats            bool
+	FailFast              bool
 }
 
+// Exit codes of the collector when used standalone
+const (
+	// ExitCodeComplete is returned when every namespace and node was collected
+	ExitCodeComplete = 0
+	// ExitCodeFailed is returned when the collection failed or was interrupted
+	ExitCodeFailed = 1
+	// ExitCodePartial is returned when the collection completed, but some namespaces or nodes couldn't be collected
+	ExitCodePartial = 2
+)
+
 // DefaultFlags returns a CommandFlags struct initialized with default values
 func DefaultFlags() *CommandFlags {
 	return &CommandFlags{
-		HideNames:          false,
-		ContinueProcessing: false,
-		KubeContext:        "",
-		OutputDir:          ".",
-		OutputFormat:       "json",
-		OutputFilePrefix:   "",
-		EnableDebug:        false,
-		NoProgress:         false,
-		MaxProcessors:      0,
+		HideNames:             false,
+		ContinueProcessing:    false,
+		KubeContext:           "",
+		OutputDir:             ".",
+		OutputFormat:          "json",
+		OutputFilePrefix:      "",
+		EnableDebug:           false,
+		NoProgress:            false,
+		MaxProcessors:         0,
+		IncludeNonRunningPods: false,
+		IncludeWorkloads:      false,
+		PrometheusURL:         "",
+		PrometheusLookback:    utils.DefaultPrometheusLookback,
+		PrometheusToken:       "",
+		PrometheusUsername:    "",
+		PrometheusPassword:    "",
+		SampleDuration:        0,
+		SampleInterval:        utils.DefaultSampleInterval,
+		EnvoyStats:            false,
+		FailFast:              false,
 	}
 }
 
@@ -66,6 +100,9 @@ func GetCommand(customFlags ...*CommandFlags) *cobra.Command {
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
 
+			// The checkpointer saves what has been gathered so far, so an interrupted collection can be continued
+			checkpoint := gatherer.NewCheckpointer(gatherer.DefaultCheckpointInterval)
+
 			// Setup signal handling for graceful shutdown
 			sigCh := make(chan os.Signal, 1)
 			signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -88,6 +125,11 @@ func GetCommand(customFlags ...*CommandFlags) *cobra.Command {
 				}()
 
 				cancel()
+
+				// Save what has been gathered so far, as the collection may not get to it before the shutdown times out
+				if err := checkpoint.Save(); err != nil {
+					logging.Warn("Failed to save checkpoint: %v", err)
+				}
 			}()
 
 			// defining the log level
@@ -119,6 +161,17 @@ func GetCommand(customFlags ...*CommandFlags) *cobra.Command {
 				flags.OutputFormat = "json"
 			}
 
+			if flags.PrometheusURL != "" && flags.PrometheusLookback <= 0 {
+				return fmt.Errorf("invalid Prometheus lookback: %s, it must be positive", flags.PrometheusLookback)
+			}
+
+			if flags.SampleDuration < 0 {
+				return fmt.Errorf("invalid sample duration: %s, it must not be negative", flags.SampleDuration)
+			}
+			if flags.SampleDuration > 0 && (flags.SampleInterval <= 0 || flags.SampleInterval > flags.SampleDuration) {
+				return fmt.Errorf("invalid sample interval: %s, it must be positive and at most the sample duration", flags.SampleInterval)
+			}
+
 			prefix := flags.OutputFilePrefix
 			if prefix == "" {
 				// Use the context name as the default prefix
@@ -130,18 +183,39 @@ func GetCommand(customFlags ...*CommandFlags) *cobra.Command {
 
 			// Create config
 			cfg := &utils.Config{
-				KubeContext:        flags.KubeContext,
-				ObfuscateNames:     flags.HideNames,
-				ContinueProcessing: flags.ContinueProcessing,
-				OutputDir:          flags.OutputDir,
-				OutputFormat:       flags.OutputFormat,
-				OutputFilePrefix:   prefix,
-				NoProgress:         flags.NoProgress,
-				MaxProcessors:      flags.MaxProcessors,
+				KubeContext:           flags.KubeContext,
+				ObfuscateNames:        flags.HideNames,
+				ContinueProcessing:    flags.ContinueProcessing,
+				OutputDir:             flags.OutputDir,
+				OutputFormat:          flags.OutputFormat,
+				OutputFilePrefix:      prefix,
+				NoProgress:            flags.NoProgress,
+				MaxProcessors:         flags.MaxProcessors,
+				IncludeNonRunningPods: flags.IncludeNonRunningPods,
+				IncludeWorkloads:      flags.IncludeWorkloads,
+				Prometheus: utils.PrometheusConfig{
+					URL:         flags.PrometheusURL,
+					Lookback:    flags.PrometheusLookback,
+					BearerToken: flags.PrometheusToken,
+					Username:    flags.PrometheusUsername,
+					Password:    flags.PrometheusPassword,
+				},
+				SampleDuration:    flags.SampleDuration,
+				SampleInterval:    flags.SampleInterval,
+				CollectEnvoyStats: flags.EnvoyStats,
+				FailFast:          flags.FailFast,
+				CollectorVersion:  version.Version(),
+				CollectorCommit:   version.GitCommit(),
+				Flags:             reportedFlags(cmd.Flags(), flags.HideNames),
 			}
 
 			// Gather cluster information
-			if err := gatherer.GatherClusterInfo(ctx, cfg); err != nil {
+			if err := gatherer.GatherClusterInfo(ctx, cfg, checkpoint); err != nil {
+				var partialErr *gatherer.PartialCollectionError
+				if errors.As(err, &partialErr) {
+					logging.Warn("Cluster information gathered partially: %v", err)
+					return err
+				}
 				logging.Error("Error gathering cluster information: %v", err)
 				return err
 			}
@@ -161,10 +235,55 @@ func GetCommand(customFlags ...*CommandFlags) *cobra.Command {
 	cmd.PersistentFlags().BoolVar(&flags.EnableDebug, "debug", false, "Enable debug mode.")
 	cmd.PersistentFlags().BoolVar(&flags.NoProgress, "no-progress", false, "Disable the progress bar while processing resources.")
 	cmd.PersistentFlags().IntVar(&flags.MaxProcessors, "max-processors", 0, "Maximum number of processors to use. If not set, or <= 0, it will use all available processors.")
+	cmd.PersistentFlags().BoolVar(&flags.IncludeNonRunningPods, "include-non-running-pods", false, "Include pods which aren't running (pending, completed, failed or unknown) in the resource totals.")
+	cmd.PersistentFlags().BoolVar(&flags.IncludeWorkloads, "workloads", false, "Break each namespace down by workload (Deployment, StatefulSet, DaemonSet, CronJob, Job), resolving pods to their top-level controller.")
+	cmd.PersistentFlags().StringVar(&flags.PrometheusURL, "prometheus-url", "", "URL of a Prometheus-compatible server to read the historical usage of containers from (e.g. http://prometheus.monitoring:9090). If not set, Prometheus isn't queried.")
+	cmd.PersistentFlags().DurationVar(&flags.PrometheusLookback, "prometheus-lookback", utils.DefaultPrometheusLookback, "Window of history to read from Prometheus.")
+	cmd.PersistentFlags().StringVar(&flags.PrometheusToken, "prometheus-token", "", "Bearer token to authenticate to Prometheus with.")
+	cmd.PersistentFlags().StringVar(&flags.PrometheusUsername, "prometheus-username", "", "Username to authenticate to Prometheus with through basic authentication.")
+	cmd.PersistentFlags().StringVar(&flags.PrometheusPassword, "prometheus-password", "", "Password to authenticate to Prometheus with through basic authentication.")
+	cmd.PersistentFlags().DurationVar(&flags.SampleDuration, "sample-duration", 0, "Sample the metrics API over this window and report the min, average, max and p95 usage. If not set, the usage is only read once.")
+	cmd.PersistentFlags().DurationVar(&flags.SampleInterval, "sample-interval", utils.DefaultSampleInterval, "Time between two samples of the metrics API when sampling.")
+	cmd.PersistentFlags().BoolVar(&flags.EnvoyStats, "envoy-stats", false, "Read the Envoy stats of each sidecar through the pod proxy subresource to report the request rate, active connections and configuration size of each namespace.")
+	cmd.PersistentFlags().BoolVar(&flags.FailFast, "fail-fast", false, "Fail the collection if a namespace or node can't be collected, rather than recording the error in the output and continuing.")
+
+	cmd.AddCommand(newSchemaCommand(), newValidateCommand())
 
 	return cmd
 }
 
+// secretFlags are the flags which are never reported in the output's metadata
+var secretFlags = map[string]struct{}{
+	"prometheus-token":    {},
+	"prometheus-password": {},
+}
+
+// nameFlags are the flags whose value is a name, hidden in the output's metadata along with the other names when using --hide-names
+var nameFlags = map[string]struct{}{
+	"context":       {},
+	"output-prefix": {},
+}
+
+// reportedFlags returns the flags which were set on the command line, keyed by name, to be reported in the output's metadata.
+// Secrets are left out, and credentials are removed from the Prometheus URL.
+func reportedFlags(flagSet *pflag.FlagSet, hideNames bool) map[string]string {
+	reported := make(map[string]string)
+	flagSet.Visit(func(flag *pflag.Flag) {
+		if _, secret := secretFlags[flag.Name]; secret {
+			return
+		}
+		value := flag.Value.String()
+		if _, name := nameFlags[flag.Name]; name && hideNames {
+			value = gatherer.ObfuscateName(value)
+		}
+		if flag.Name == "prometheus-url" {
+			value = utils.RedactedURL(value)
+		}
+		reported[flag.Name] = value
+	})
+	return reported
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main() when the CLI is used standalone.
 func Execute() {
@@ -174,6 +293,18 @@ func Execute() {
 	cmd.SetVersionTemplate(version.VersionTemplate())
 
 	if err := cmd.Execute(); err != nil {
-		os.Exit(1)
+		os.Exit(ExitCode(err))
+	}
+}
+
+// ExitCode returns the exit code for the error returned by the command
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitCodeComplete
+	}
+	var partialErr *gatherer.PartialCollectionError
+	if errors.As(err, &partialErr) {
+		return ExitCodePartial
 	}
+	return ExitCodeFailed
 }
@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+	"github.com/spf13/cobra"
+)
+
+// newSchemaCommand returns the command printing the JSON Schema of the report
+func newSchemaCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema of the report.",
+		Long:  "Print the JSON Schema of the report written by this version of the collector. YAML reports match it once converted to JSON.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, err := cmd.OutOrStdout().Write(models.Schema)
+			return err
+		},
+	}
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/solo-io/istio-usage-collector/internal/logging"
+	"github.com/solo-io/istio-usage-collector/internal/schema"
+	"github.com/spf13/cobra"
+)
+
+// newValidateCommand returns the command checking an existing report against the schema
+func newValidateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate <file>",
+		Short: "Validate a JSON or YAML report against the schema.",
+		Long:  "Validate a JSON or YAML report against the schema of this version of the collector, then check that its values are consistent, such as namespaces not having more Istio containers than pods.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fileName := args[0]
+			problems, err := schema.ValidateFile(fileName)
+			if err != nil {
+				logging.Error("Failed to validate %s: %v", fileName, err)
+				return err
+			}
+
+			for _, problem := range problems {
+				logging.Error("%s", problem)
+			}
+			if len(problems) > 0 {
+				return fmt.Errorf("%s has %d problems", fileName, len(problems))
+			}
+
+			logging.Success("%s is valid", fileName)
+			return nil
+		},
+	}
+}
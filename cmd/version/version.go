@@ -14,3 +14,13 @@ var (
 func VersionTemplate() string {
 	return fmt.Sprintf("Version: %s\nGit commit: %s\n", version, gitCommit)
 }
+
+// Version returns the version of the collector
+func Version() string {
+	return version
+}
+
+// GitCommit returns the git commit the collector was built from
+func GitCommit() string {
+	return gitCommit
+}
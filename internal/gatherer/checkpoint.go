@@ -0,0 +1,146 @@
+package gatherer
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/solo-io/istio-usage-collector/internal/logging"
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+)
+
+// DefaultCheckpointInterval is the default minimum time between two checkpoints
+const DefaultCheckpointInterval = 30 * time.Second
+
+// Checkpointer guards the cluster info while it's gathered, and periodically saves it to the output file marked as partial,
+// along with the state of the collection, so an interrupted or failed run can be continued with --continue.
+// The zero value guards the cluster info without saving it.
+type Checkpointer struct {
+	// interval is the minimum time between two checkpoints saved after an update
+	interval time.Duration
+
+	mu          sync.Mutex
+	clusterInfo *models.ClusterInfo
+	outputFile  string
+	state       *resumeState
+	stateFile   string
+	densities   *nodeDensities
+	lastSave    time.Time
+}
+
+// NewCheckpointer creates a checkpointer saving at most one checkpoint per interval while the cluster info is updated
+func NewCheckpointer(interval time.Duration) *Checkpointer {
+	return &Checkpointer{interval: interval}
+}
+
+// start begins checkpointing the cluster info to the output file, and the state of the collection to the state file
+// along with the node densities
+func (c *Checkpointer) start(clusterInfo *models.ClusterInfo, outputFile string, state *resumeState, stateFile string, densities *nodeDensities) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clusterInfo = clusterInfo
+	c.outputFile = outputFile
+	c.state = state
+	c.stateFile = stateFile
+	c.densities = densities
+	c.lastSave = time.Now()
+}
+
+// fail records that a namespace or node failed, in the state saved with the next checkpoint
+func (c *Checkpointer) fail(kind, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != nil {
+		c.state.recordFailure(kind, name)
+	}
+}
+
+// guard runs fn while no checkpoint is saved and no other change is applied, without saving a checkpoint after it
+func (c *Checkpointer) guard(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fn()
+}
+
+// finish saves the complete cluster info through save, after which no more checkpoints are saved so they can't replace it.
+// The state file is removed as there is nothing left to continue, unless some namespaces or nodes couldn't be collected,
+// in which case it's kept so --continue retries them.
+func (c *Checkpointer) finish(save func() error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := save(); err != nil {
+		return err
+	}
+	clusterInfo := c.clusterInfo
+	c.clusterInfo = nil
+	if c.state != nil && len(clusterInfo.CollectionErrors) > 0 {
+		c.state.snapshot(clusterInfo, c.densities)
+		if err := saveResumeState(c.state, c.stateFile); err != nil {
+			logging.Warn("Failed to save state file %s: %v", c.stateFile, err)
+		} else {
+			logging.Info("Kept state file %s, use --continue to retry the namespaces and nodes which couldn't be collected", c.stateFile)
+		}
+		return nil
+	}
+	if c.stateFile != "" {
+		if err := os.Remove(c.stateFile); err != nil && !os.IsNotExist(err) {
+			logging.Warn("Failed to remove state file %s: %v", c.stateFile, err)
+		}
+	}
+	return nil
+}
+
+// update applies a change to the cluster info, then saves a checkpoint if the interval has passed since the last one.
+// A failed checkpoint is only logged, as the next one may succeed.
+func (c *Checkpointer) update(change func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	change()
+
+	if c.clusterInfo == nil || time.Since(c.lastSave) < c.interval {
+		return
+	}
+	if err := c.saveLocked(); err != nil {
+		logging.Warn("Failed to save checkpoint: %v", err)
+	}
+}
+
+// Save saves a checkpoint of the cluster info gathered so far, such as when the collection is interrupted.
+// Nothing is saved if gathering hasn't started.
+func (c *Checkpointer) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.clusterInfo == nil {
+		return nil
+	}
+	if err := c.saveLocked(); err != nil {
+		return err
+	}
+	logging.Info("Saved partial cluster info to file: %s, use --continue to resume", c.outputFile)
+	return nil
+}
+
+// saveLocked writes the cluster info marked as partial, replacing the output file atomically so it's never left truncated
+func (c *Checkpointer) saveLocked() error {
+	snapshot := *c.clusterInfo
+	snapshot.Partial = true
+	data, err := marshalClusterInfo(&snapshot, c.outputFile)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomically(c.outputFile, data); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+
+	// The state is saved after the output, so it never records namespaces or nodes the output is missing
+	if c.state != nil {
+		c.state.snapshot(c.clusterInfo, c.densities)
+		if err := saveResumeState(c.state, c.stateFile); err != nil {
+			return fmt.Errorf("failed to write state: %w", err)
+		}
+	}
+	c.lastSave = time.Now()
+	logging.Debug("Saved checkpoint to file: %s", c.outputFile)
+	return nil
+}
@@ -0,0 +1,81 @@
+//go:build test || unit
+
+package gatherer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpointer(t *testing.T) {
+	t.Run("Checkpoints are saved as partial once the interval passes", func(t *testing.T) {
+		outputFile := filepath.Join(t.TempDir(), "cluster.json")
+		clusterInfo := models.NewClusterInfo()
+		clusterInfo.Name = "cluster"
+
+		checkpoint := NewCheckpointer(0)
+		checkpoint.start(clusterInfo, outputFile, nil, "", nil)
+		checkpoint.update(func() { clusterInfo.Namespaces["default"] = &models.NamespaceInfo{Pods: 2} })
+
+		saved, err := loadExistingData(outputFile)
+		require.NoError(t, err)
+		assert.True(t, saved.Partial)
+		assert.Equal(t, 2, saved.Namespaces["default"].Pods)
+		// the gathered cluster info itself isn't marked as partial
+		assert.False(t, clusterInfo.Partial)
+
+		// only the output file is left, the temporary file is renamed over it
+		entries, err := os.ReadDir(filepath.Dir(outputFile))
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("Checkpoints aren't saved before the interval passes", func(t *testing.T) {
+		outputFile := filepath.Join(t.TempDir(), "cluster.yaml")
+		clusterInfo := models.NewClusterInfo()
+
+		checkpoint := NewCheckpointer(time.Hour)
+		checkpoint.start(clusterInfo, outputFile, nil, "", nil)
+		checkpoint.update(func() { clusterInfo.Nodes["node-a"] = models.NodeInfo{} })
+		assert.NoFileExists(t, outputFile)
+
+		// checkpoints are saved on demand regardless of the interval
+		require.NoError(t, checkpoint.Save())
+		saved, err := loadExistingData(outputFile)
+		require.NoError(t, err)
+		assert.True(t, saved.Partial)
+		assert.Contains(t, saved.Nodes, "node-a")
+	})
+
+	t.Run("Nothing is saved before gathering starts or once it finished", func(t *testing.T) {
+		outputFile := filepath.Join(t.TempDir(), "cluster.json")
+		checkpoint := NewCheckpointer(0)
+		require.NoError(t, checkpoint.Save())
+		assert.NoFileExists(t, outputFile)
+
+		clusterInfo := models.NewClusterInfo()
+		checkpoint.start(clusterInfo, outputFile, nil, "", nil)
+		require.NoError(t, checkpoint.finish(func() error { return saveClusterInfo(clusterInfo, outputFile) }))
+
+		// an interruption after the output was saved doesn't replace it with a checkpoint
+		require.NoError(t, checkpoint.Save())
+		checkpoint.update(func() {})
+		saved, err := loadExistingData(outputFile)
+		require.NoError(t, err)
+		assert.False(t, saved.Partial)
+	})
+
+	t.Run("The zero value only guards the cluster info", func(t *testing.T) {
+		clusterInfo := models.NewClusterInfo()
+		checkpoint := &Checkpointer{}
+		checkpoint.update(func() { clusterInfo.Name = "cluster" })
+		assert.Equal(t, "cluster", clusterInfo.Name)
+		assert.NoError(t, checkpoint.Save())
+	})
+}
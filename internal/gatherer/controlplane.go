@@ -0,0 +1,149 @@
+package gatherer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/solo-io/istio-usage-collector/internal/logging"
+	"github.com/solo-io/istio-usage-collector/internal/utils"
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// processControlPlane gathers the istiod deployment of each revision, and the revision tags pointing to them.
+// It returns nil if neither istiod nor any revision tags are found.
+// It also resolves the mesh's root namespace from the istiod of the default revision (or else the first one found),
+// which is DefaultIstioNamespace if istiod isn't found.
+func processControlPlane(ctx context.Context, clientset kubernetes.Interface, metricsClient metricsv.Interface, hasMetrics bool) (*models.ControlPlaneInfo, string, error) {
+	if ctx.Err() != nil {
+		return nil, "", ctx.Err()
+	}
+
+	controlPlane := &models.ControlPlaneInfo{
+		Revisions: make(map[string]*models.RevisionInfo),
+	}
+
+	// Get the istiod deployments of every revision
+	deployments, err := clientset.AppsV1().Deployments(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: utils.IstiodLabelSelector})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list istiod deployments: %w", err)
+	}
+
+	istiodNamespaces := make(map[string]struct{})
+	var rootIstiod *appsv1.Deployment
+	for i, deployment := range deployments.Items {
+		rev := utils.IstiodRevision(deployment.Labels)
+		if rootIstiod == nil || (rev == utils.DefaultRevision && utils.IstiodRevision(rootIstiod.Labels) != utils.DefaultRevision) {
+			rootIstiod = &deployments.Items[i]
+		}
+		revision, ok := controlPlane.Revisions[rev]
+		if !ok {
+			revision = &models.RevisionInfo{}
+			controlPlane.Revisions[rev] = revision
+		}
+
+		if version := istiodVersion(deployment.Spec.Template.Spec.Containers); version != "" {
+			revision.Version = version
+		}
+		// the number of replicas defaults to 1 if it isn't set
+		replicas := 1
+		if deployment.Spec.Replicas != nil {
+			replicas = int(*deployment.Spec.Replicas)
+		}
+		revision.Replicas += replicas
+		revision.ReadyReplicas += int(deployment.Status.ReadyReplicas)
+		istiodNamespaces[deployment.Namespace] = struct{}{}
+	}
+
+	rootNamespace := utils.DefaultIstioNamespace
+	if rootIstiod != nil {
+		rootNamespace, err = utils.MeshRootNamespace(ctx, clientset, rootIstiod.Namespace, utils.IstiodRevision(rootIstiod.Labels))
+		if err != nil {
+			logging.Debug("Using istiod's namespace %s as the root namespace: %v", rootNamespace, err)
+		}
+	}
+
+	// Sum the resources of istiod's running pods for each revision
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: utils.IstiodLabelSelector})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list istiod pods: %w", err)
+	}
+
+	totals := make(map[string]*containerTotals)
+	podRevisions := make(map[string]string)
+	for _, pod := range pods.Items {
+		rev := utils.IstiodRevision(pod.Labels)
+		if _, ok := controlPlane.Revisions[rev]; !ok || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		if totals[rev] == nil {
+			totals[rev] = &containerTotals{}
+		}
+		for _, container := range pod.Spec.Containers {
+			totals[rev].addContainer(container.Resources)
+		}
+		podRevisions[pod.Namespace+"/"+pod.Name] = rev
+	}
+
+	// Add the actual usage of istiod's pods, which is only reported if it could be gathered for every namespace istiod runs in
+	hasActual := hasMetrics && metricsClient != nil
+	if hasActual {
+		for namespace := range istiodNamespaces {
+			podMetrics, err := metricsClient.MetricsV1beta1().PodMetricses(namespace).List(ctx, metav1.ListOptions{LabelSelector: utils.IstiodLabelSelector})
+			if err != nil {
+				logging.Warn("Failed to get istiod metrics for namespace %s: %v", namespace, err)
+				hasActual = false
+				break
+			}
+			for _, podMetric := range podMetrics.Items {
+				rev, ok := podRevisions[podMetric.Namespace+"/"+podMetric.Name]
+				if !ok {
+					continue
+				}
+				for _, containerMetric := range podMetric.Containers {
+					totals[rev].addUsage(containerMetric.Usage)
+				}
+			}
+		}
+	}
+
+	for rev, revision := range controlPlane.Revisions {
+		revisionTotals := totals[rev]
+		if revisionTotals == nil {
+			revisionTotals = &containerTotals{}
+		}
+		revision.Resources = *revisionTotals.toContainerResources(hasActual)
+	}
+
+	// Revision tags are defined by the istio webhooks
+	webhooks, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list mutating webhook configurations: %w", err)
+	}
+	if tags := utils.RevisionTags(utils.FilterIstioWebhooks(webhooks.Items)); len(tags) > 0 {
+		controlPlane.RevisionTags = tags
+	}
+
+	if len(controlPlane.Revisions) == 0 && len(controlPlane.RevisionTags) == 0 {
+		logging.Debug("No istiod deployments or revision tags found")
+		return nil, rootNamespace, nil
+	}
+	return controlPlane, rootNamespace, nil
+}
+
+// istiodVersion returns the version of istiod from the image tag of its container, falling back to the first container
+func istiodVersion(containers []corev1.Container) string {
+	for _, container := range containers {
+		if container.Name == utils.IstiodContainerName {
+			return utils.ImageVersion(container.Image)
+		}
+	}
+	if len(containers) > 0 {
+		return utils.ImageVersion(containers[0].Image)
+	}
+	return ""
+}
@@ -0,0 +1,140 @@
+//go:build test || unit
+
+package gatherer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/solo-io/istio-usage-collector/internal/utils"
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+	testutils "github.com/solo-io/istio-usage-collector/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	v1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metricsfake "k8s.io/metrics/pkg/client/clientset/versioned/fake"
+)
+
+func TestProcessControlPlane(t *testing.T) {
+	ctx := context.Background()
+	defaultIstioMutatingWebhooks := loadDefaultIstioWebhooks(t)
+
+	newIstiod := func(name, rev, image string, replicas, readyReplicas int32) *appsv1.Deployment {
+		labels := map[string]string{"app": "istiod"}
+		if rev != "" {
+			labels[utils.RevisionLabel] = rev
+		}
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "istio-system", Labels: labels},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "discovery", Image: image}}}},
+			},
+			Status: appsv1.DeploymentStatus{ReadyReplicas: readyReplicas},
+		}
+	}
+	newIstiodPod := func(name, rev string) *corev1.Pod {
+		labels := map[string]string{"app": "istiod"}
+		if rev != "" {
+			labels[utils.RevisionLabel] = rev
+		}
+		pod := testutils.NewPod("istio-system", name, "node-a", "500m", "2Gi", false, "", "", labels)
+		pod.Spec.Containers[0].Name = "discovery"
+		return pod
+	}
+
+	kubeObjects := []runtime.Object{
+		newIstiod("istiod", "", "docker.io/istio/pilot:1.24.3", 2, 2),
+		newIstiod("istiod-1-25", "1-25", "docker.io/istio/pilot:1.25.0-distroless", 1, 0),
+		newIstiodPod("istiod-a", ""),
+		newIstiodPod("istiod-b", ""),
+		newIstiodPod("istiod-1-25-a", "1-25"),
+		// not istiod, so it isn't counted
+		testutils.NewPod("istio-system", "istio-ingressgateway", "node-a", "100m", "128Mi", false, "", "", map[string]string{"istio": "ingressgateway"}),
+		&defaultIstioMutatingWebhooks[0],
+		&defaultIstioMutatingWebhooks[1],
+	}
+	newIstiodPodMetrics := func(name, cpuUsage, memUsage string) v1beta1.PodMetrics {
+		podMetrics := testutils.NewPodMetrics("istio-system", name, cpuUsage, memUsage, false, "", "")
+		podMetrics.Labels = map[string]string{"app": "istiod"}
+		return *podMetrics
+	}
+	podMetricsList := &v1beta1.PodMetricsList{Items: []v1beta1.PodMetrics{
+		newIstiodPodMetrics("istiod-a", "100m", "512Mi"),
+		newIstiodPodMetrics("istiod-b", "50m", "512Mi"),
+	}}
+
+	t.Run("Revisions, versions, replicas, resources and revision tags are gathered", func(t *testing.T) {
+		fakeClient := fake.NewSimpleClientset(kubeObjects...)
+		fakeMetricsClient := metricsfake.NewSimpleClientset()
+		fakeMetricsClient.PrependReactor("list", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+			return true, podMetricsList, nil
+		})
+
+		controlPlane, rootNamespace, err := processControlPlane(ctx, fakeClient, fakeMetricsClient, true)
+		require.NoError(t, err)
+		require.NotNil(t, controlPlane)
+		// without a mesh config, the root namespace is istiod's namespace
+		assert.Equal(t, "istio-system", rootNamespace)
+
+		require.Len(t, controlPlane.Revisions, 2)
+		defaultRevision := controlPlane.Revisions[utils.DefaultRevision]
+		require.NotNil(t, defaultRevision)
+		assert.Equal(t, "1.24.3", defaultRevision.Version)
+		assert.Equal(t, 2, defaultRevision.Replicas)
+		assert.Equal(t, 2, defaultRevision.ReadyReplicas)
+		assertContainerResources(t, &models.ContainerResources{
+			Containers:    2,
+			Request:       models.Resources{CPU: 1, MemoryGB: 4},
+			Actual:        &models.Resources{CPU: 0.15, MemoryGB: 1},
+			MissingLimits: 2,
+		}, &defaultRevision.Resources)
+
+		canary := controlPlane.Revisions["1-25"]
+		require.NotNil(t, canary)
+		assert.Equal(t, "1.25.0-distroless", canary.Version)
+		assert.Equal(t, 1, canary.Replicas)
+		assert.Equal(t, 0, canary.ReadyReplicas)
+		assert.Equal(t, 1, canary.Resources.Containers)
+
+		assert.Equal(t, map[string]string{"default": utils.DefaultRevision}, controlPlane.RevisionTags)
+	})
+
+	t.Run("The root namespace is read from the mesh config of the default revision", func(t *testing.T) {
+		newMeshConfig := func(name, rootNamespace string) *corev1.ConfigMap {
+			return &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "istio-system"},
+				Data:       map[string]string{"mesh": "rootNamespace: " + rootNamespace + "\n"},
+			}
+		}
+		objects := append([]runtime.Object{newMeshConfig("istio-1-25", "canary-root"), newMeshConfig("istio", "istio-config")}, kubeObjects...)
+
+		_, rootNamespace, err := processControlPlane(ctx, fake.NewSimpleClientset(objects...), metricsfake.NewSimpleClientset(), false)
+		require.NoError(t, err)
+		assert.Equal(t, "istio-config", rootNamespace)
+	})
+
+	t.Run("No control plane is reported if istio isn't installed", func(t *testing.T) {
+		controlPlane, rootNamespace, err := processControlPlane(ctx, fake.NewSimpleClientset(), metricsfake.NewSimpleClientset(), false)
+		require.NoError(t, err)
+		assert.Nil(t, controlPlane)
+		assert.Equal(t, utils.DefaultIstioNamespace, rootNamespace)
+	})
+
+	t.Run("Failing to list istiod returns an error", func(t *testing.T) {
+		fakeClient := fake.NewSimpleClientset(kubeObjects...)
+		fakeClient.PrependReactor("list", "deployments", func(action clienttesting.Action) (bool, runtime.Object, error) {
+			return true, nil, fmt.Errorf("forbidden")
+		})
+		controlPlane, _, err := processControlPlane(ctx, fakeClient, metricsfake.NewSimpleClientset(), false)
+		assert.Error(t, err)
+		assert.Nil(t, controlPlane)
+	})
+}
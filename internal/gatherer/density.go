@@ -0,0 +1,179 @@
+package gatherer
+
+import (
+	"sync"
+
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+)
+
+// nodeTotals accumulates the pods scheduled on a node and the resources of their sidecars
+type nodeTotals struct {
+	pods       int
+	meshedPods int
+	sidecars   containerTotals
+	// hasActual is true if the sidecars' actual usage was gathered for at least one namespace with pods on the node
+	hasActual bool
+}
+
+// add adds the totals of another set of pods on the same node
+func (t *nodeTotals) add(other *nodeTotals) {
+	t.pods += other.pods
+	t.meshedPods += other.meshedPods
+	t.sidecars.containers += other.sidecars.containers
+	t.sidecars.request.CPU += other.sidecars.request.CPU
+	t.sidecars.request.MemoryGB += other.sidecars.request.MemoryGB
+	t.sidecars.limit.CPU += other.sidecars.limit.CPU
+	t.sidecars.limit.MemoryGB += other.sidecars.limit.MemoryGB
+	t.sidecars.actual.CPU += other.sidecars.actual.CPU
+	t.sidecars.actual.MemoryGB += other.sidecars.actual.MemoryGB
+	t.sidecars.missingRequests += other.sidecars.missingRequests
+	t.sidecars.missingLimits += other.sidecars.missingLimits
+	t.hasActual = t.hasActual || other.hasActual
+}
+
+// savedNodeTotals is the encoding of a nodeTotals in the resume state
+type savedNodeTotals struct {
+	Pods            int              `json:"pods"`
+	MeshedPods      int              `json:"meshed_pods"`
+	Sidecars        int              `json:"sidecars"`
+	Request         models.Resources `json:"request"`
+	Limit           models.Resources `json:"limit"`
+	Actual          models.Resources `json:"actual"`
+	MissingRequests int              `json:"missing_requests,omitempty"`
+	MissingLimits   int              `json:"missing_limits,omitempty"`
+	HasActual       bool             `json:"has_actual,omitempty"`
+}
+
+// nodeDensities aggregates the pods of every namespace by the node they're scheduled on.
+// It's shared by the namespaces processed concurrently, which each set their totals once their pods are walked.
+// The totals are keyed by the output names of the namespaces and nodes, so they can be saved in the resume state.
+// A nil nodeDensities ignores the totals set on it.
+type nodeDensities struct {
+	obfuscate bool
+
+	mu sync.Mutex
+	// namespaces are the totals of each namespace's pods, keyed by namespace then node
+	namespaces map[string]map[string]*nodeTotals
+}
+
+// newNodeDensities creates an empty nodeDensities, obfuscating the names it's keyed by if names are hidden
+func newNodeDensities(obfuscate bool) *nodeDensities {
+	return &nodeDensities{obfuscate: obfuscate, namespaces: make(map[string]map[string]*nodeTotals)}
+}
+
+// outputName returns the name of a namespace or node in the output
+func (d *nodeDensities) outputName(name string) string {
+	if d.obfuscate {
+		return ObfuscateName(name)
+	}
+	return name
+}
+
+// set sets the totals of a namespace's pods, keyed by node name, replacing those of a previous attempt at the namespace
+func (d *nodeDensities) set(namespace string, nodes map[string]*nodeTotals) {
+	if d == nil {
+		return
+	}
+	totals := make(map[string]*nodeTotals, len(nodes))
+	for nodeName, nodeTotals := range nodes {
+		totals[d.outputName(nodeName)] = nodeTotals
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.namespaces[d.outputName(namespace)] = totals
+}
+
+// retain removes the totals of the namespaces which aren't in the output, such as those which failed,
+// so the densities only count the pods of the namespaces reported
+func (d *nodeDensities) retain(namespaces map[string]*models.NamespaceInfo) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for namespace := range d.namespaces {
+		if _, ok := namespaces[namespace]; !ok {
+			delete(d.namespaces, namespace)
+		}
+	}
+}
+
+// get returns the density of a node, which is empty if no pods are scheduled on it.
+// Nil is returned if the densities weren't aggregated.
+func (d *nodeDensities) get(nodeName string) *models.NodeDensity {
+	if d == nil {
+		return nil
+	}
+	outNodeName := d.outputName(nodeName)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	totals := &nodeTotals{}
+	for _, nodes := range d.namespaces {
+		if nodeTotals, ok := nodes[outNodeName]; ok {
+			totals.add(nodeTotals)
+		}
+	}
+	return &models.NodeDensity{
+		Pods:       totals.pods,
+		MeshedPods: totals.meshedPods,
+		Sidecars:   *totals.sidecars.toContainerResources(totals.hasActual),
+	}
+}
+
+// save returns the totals of the namespaces in the output, to save in the resume state.
+// Nil is returned if the densities weren't aggregated.
+func (d *nodeDensities) save(namespaces map[string]*models.NamespaceInfo) map[string]map[string]savedNodeTotals {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	saved := make(map[string]map[string]savedNodeTotals, len(d.namespaces))
+	for namespace, nodes := range d.namespaces {
+		if _, ok := namespaces[namespace]; !ok {
+			continue
+		}
+		saved[namespace] = make(map[string]savedNodeTotals, len(nodes))
+		for nodeName, totals := range nodes {
+			saved[namespace][nodeName] = savedNodeTotals{
+				Pods:            totals.pods,
+				MeshedPods:      totals.meshedPods,
+				Sidecars:        totals.sidecars.containers,
+				Request:         totals.sidecars.request,
+				Limit:           totals.sidecars.limit,
+				Actual:          totals.sidecars.actual,
+				MissingRequests: totals.sidecars.missingRequests,
+				MissingLimits:   totals.sidecars.missingLimits,
+				HasActual:       totals.hasActual,
+			}
+		}
+	}
+	return saved
+}
+
+// restore sets the totals saved by an interrupted collection, for the namespaces it processed
+func (d *nodeDensities) restore(saved map[string]map[string]savedNodeTotals) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for namespace, nodes := range saved {
+		d.namespaces[namespace] = make(map[string]*nodeTotals, len(nodes))
+		for nodeName, totals := range nodes {
+			d.namespaces[namespace][nodeName] = &nodeTotals{
+				pods:       totals.Pods,
+				meshedPods: totals.MeshedPods,
+				sidecars: containerTotals{
+					containers:      totals.Sidecars,
+					request:         totals.Request,
+					limit:           totals.Limit,
+					actual:          totals.Actual,
+					missingRequests: totals.MissingRequests,
+					missingLimits:   totals.MissingLimits,
+				},
+				hasActual: totals.HasActual,
+			}
+		}
+	}
+}
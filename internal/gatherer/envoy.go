@@ -0,0 +1,100 @@
+package gatherer
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/solo-io/istio-usage-collector/internal/logging"
+	"github.com/solo-io/istio-usage-collector/internal/utils"
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+// envoyScraper reads the Envoy stats of sidecars through the pod proxy subresource. It shares the semaphore namespaces
+// are processed with (--max-processors), so reading sidecars never exceeds the concurrency of the collection.
+type envoyScraper struct {
+	clientset kubernetes.Interface
+	semaphore chan struct{}
+	// forbidden is set once the proxy subresource is forbidden, after which no more stats are read
+	forbidden atomic.Bool
+}
+
+// newEnvoyScraper creates a scraper sharing the given semaphore, which callers of namespaceStats hold a slot of
+func newEnvoyScraper(clientset kubernetes.Interface, semaphore chan struct{}) *envoyScraper {
+	return &envoyScraper{
+		clientset: clientset,
+		semaphore: semaphore,
+	}
+}
+
+// namespaceStats reads the Envoy stats of the sidecars of the given pods and aggregates them, returning nil if none could be read
+// because the proxy subresource is forbidden. Sidecars whose stats can't be read for any other reason are counted as failed.
+// The caller holds a slot of the semaphore, which sidecars are read with when no other slot is free, so a namespace never
+// waits for a slot held by another namespace waiting in turn.
+func (s *envoyScraper) namespaceStats(ctx context.Context, namespace string, pods []string) *models.EnvoyStats {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	stats := &models.EnvoyStats{}
+
+	scrape := func(pod string) {
+		podStats, err := utils.ScrapeEnvoyStats(ctx, s.clientset, namespace, pod)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			if errors.IsForbidden(err) {
+				if s.forbidden.CompareAndSwap(false, true) {
+					logging.Warn("Reading Envoy stats through the pod proxy subresource is forbidden, Envoy stats will not be collected: %v", err)
+				}
+				return
+			}
+			logging.Debug("Failed to read the Envoy stats of %s.%s: %v", namespace, pod, err)
+			stats.Failed++
+			return
+		}
+
+		stats.Sidecars++
+		if podStats.UptimeSeconds > 0 {
+			stats.InboundRPS += podStats.InboundRequests / podStats.UptimeSeconds
+			stats.OutboundRPS += podStats.OutboundRequests / podStats.UptimeSeconds
+		}
+		stats.ActiveConnections += podStats.ActiveConnections
+		stats.MaxClusters = max(stats.MaxClusters, podStats.Clusters)
+		stats.MaxListeners = max(stats.MaxListeners, podStats.Listeners)
+		stats.MaxRoutes = max(stats.MaxRoutes, podStats.Routes)
+	}
+
+	for _, pod := range pods {
+		// checked before each sidecar, as the sidecar read before may have been forbidden
+		if ctx.Err() != nil || s.forbidden.Load() {
+			break
+		}
+
+		select {
+		case s.semaphore <- struct{}{}:
+			wg.Add(1)
+			go func(pod string) {
+				defer wg.Done()
+				defer func() { <-s.semaphore }()
+				scrape(pod)
+			}(pod)
+		default:
+			scrape(pod)
+		}
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	if s.forbidden.Load() {
+		if stats.Sidecars == 0 {
+			return nil
+		}
+		// the sidecars which were skipped once the proxy subresource was forbidden are missing as well
+		stats.Failed = len(pods) - stats.Sidecars
+	}
+	return stats
+}
@@ -0,0 +1,111 @@
+//go:build test || unit
+
+package gatherer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/solo-io/istio-usage-collector/internal/utils"
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+	testutils "github.com/solo-io/istio-usage-collector/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	restclient "k8s.io/client-go/rest"
+	clienttesting "k8s.io/client-go/testing"
+	metricsfake "k8s.io/metrics/pkg/client/clientset/versioned/fake"
+)
+
+const envoyStats = `envoy_server_uptime{} 100
+envoy_listener_downstream_cx_active{envoy_listener_address="0.0.0.0_15006"} 5
+envoy_cluster_manager_active_clusters{} 40
+envoy_listener_manager_total_listeners_active{} 10
+istio_requests_total{reporter="destination",response_code="200"} 200
+istio_requests_total{reporter="source",response_code="200"} 100
+`
+
+// heldSemaphore returns a semaphore of one slot held by the namespace being processed, as it is when processNamespace is
+// called by processNamespaces, so its sidecars are read one at a time without waiting for another slot
+func heldSemaphore() chan struct{} {
+	semaphore := make(chan struct{}, 1)
+	semaphore <- struct{}{}
+	return semaphore
+}
+
+func TestProcessNamespaceEnvoyStats(t *testing.T) {
+	ctx := context.Background()
+	kubeObjects := []runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-istio", Labels: map[string]string{"istio-injection": "enabled"}}},
+		testutils.NewPod("test-istio", "web-1", "node-a", "100m", "128Mi", true, "100m", "128Mi", map[string]string{}),
+		testutils.NewPod("test-istio", "web-2", "node-a", "100m", "128Mi", true, "100m", "128Mi", map[string]string{}),
+		testutils.NewPod("test-istio", "web-3", "node-a", "100m", "128Mi", true, "100m", "128Mi", map[string]string{}),
+		testutils.NewPod("test-istio", "no-sidecar", "node-a", "100m", "128Mi", false, "", "", map[string]string{"sidecar.istio.io/inject": "false"}),
+	}
+	webhooks := loadDefaultIstioWebhooks(t)
+
+	t.Run("The stats of each sidecar are aggregated", func(t *testing.T) {
+		fakeClient := fake.NewSimpleClientset(kubeObjects...)
+		var scraped []string
+		fakeClient.PrependProxyReactor("pods", func(action clienttesting.Action) (bool, restclient.ResponseWrapper, error) {
+			proxy := action.(clienttesting.ProxyGetAction)
+			scraped = append(scraped, proxy.GetName())
+			if proxy.GetName() == "web-3" {
+				return true, &testutils.ProxyResponse{Err: fmt.Errorf("connection refused")}, nil
+			}
+			return true, &testutils.ProxyResponse{Data: []byte(envoyStats)}, nil
+		})
+		mesh := &meshInfo{webhooks: webhooks, envoy: newEnvoyScraper(fakeClient, heldSemaphore())}
+
+		nsInfo, err := processNamespace(ctx, fakeClient, metricsfake.NewSimpleClientset(), "test-istio", false, mesh, &utils.Config{})
+		require.NoError(t, err)
+
+		assert.Equal(t, &models.EnvoyStats{
+			Sidecars:          2,
+			Failed:            1,
+			InboundRPS:        4,
+			OutboundRPS:       2,
+			ActiveConnections: 10,
+			MaxClusters:       40,
+			MaxListeners:      10,
+		}, nsInfo.EnvoyStats)
+		// pods without a sidecar aren't scraped
+		assert.NotContains(t, scraped, "no-sidecar")
+		assert.Len(t, scraped, 3)
+	})
+
+	t.Run("Envoy stats are skipped if the proxy subresource is forbidden", func(t *testing.T) {
+		fakeClient := fake.NewSimpleClientset(kubeObjects...)
+		scrapes := 0
+		fakeClient.PrependProxyReactor("pods", func(action clienttesting.Action) (bool, restclient.ResponseWrapper, error) {
+			scrapes++
+			forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "pods/proxy"}, "", fmt.Errorf("denied"))
+			return true, &testutils.ProxyResponse{Err: forbidden}, nil
+		})
+		mesh := &meshInfo{webhooks: webhooks, envoy: newEnvoyScraper(fakeClient, heldSemaphore())}
+
+		nsInfo, err := processNamespace(ctx, fakeClient, metricsfake.NewSimpleClientset(), "test-istio", false, mesh, &utils.Config{})
+		require.NoError(t, err)
+		assert.Nil(t, nsInfo.EnvoyStats)
+		assert.Equal(t, 1, scrapes)
+
+		// later namespaces aren't scraped either
+		nsInfo, err = processNamespace(ctx, fakeClient, metricsfake.NewSimpleClientset(), "test-istio", false, mesh, &utils.Config{})
+		require.NoError(t, err)
+		assert.Nil(t, nsInfo.EnvoyStats)
+		assert.Equal(t, 1, scrapes)
+	})
+
+	t.Run("Envoy stats aren't collected by default", func(t *testing.T) {
+		fakeClient := fake.NewSimpleClientset(kubeObjects...)
+		nsInfo, err := processNamespace(ctx, fakeClient, metricsfake.NewSimpleClientset(), "test-istio", false, &meshInfo{webhooks: webhooks}, &utils.Config{})
+		require.NoError(t, err)
+		assert.Nil(t, nsInfo.EnvoyStats)
+	})
+}
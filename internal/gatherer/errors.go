@@ -0,0 +1,139 @@
+package gatherer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Classes of the errors recorded for namespaces and nodes which couldn't be collected
+const (
+	errorClassTimeout      = "timeout"
+	errorClassCancelled    = "cancelled"
+	errorClassForbidden    = "forbidden"
+	errorClassUnauthorized = "unauthorized"
+	errorClassNotFound     = "not_found"
+	errorClassThrottled    = "throttled"
+	errorClassServer       = "server_error"
+	errorClassNetwork      = "network"
+	errorClassUnknown      = "unknown"
+)
+
+// maxProcessAttempts is the number of times a namespace or node is processed before it's recorded as failed
+const maxProcessAttempts = 3
+
+// processRetryDelay is the delay before the first retry of a namespace or node, doubled for each following retry
+var processRetryDelay = 500 * time.Millisecond
+
+// PartialCollectionError is returned when the collection completed, but some namespaces or nodes couldn't be collected.
+// They are recorded in the collection errors of the output.
+type PartialCollectionError struct {
+	// Failed is the number of namespaces and nodes which couldn't be collected
+	Failed int
+}
+
+func (e *PartialCollectionError) Error() string {
+	return fmt.Sprintf("%d namespaces or nodes couldn't be collected, see collection_errors in the output", e.Failed)
+}
+
+// classifyError returns the class of an error returned while processing a namespace or node
+func classifyError(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err):
+		return errorClassTimeout
+	case errors.Is(err, context.Canceled):
+		return errorClassCancelled
+	case apierrors.IsForbidden(err):
+		return errorClassForbidden
+	case apierrors.IsUnauthorized(err):
+		return errorClassUnauthorized
+	case apierrors.IsNotFound(err):
+		return errorClassNotFound
+	case apierrors.IsTooManyRequests(err):
+		return errorClassThrottled
+	case apierrors.IsServiceUnavailable(err) || apierrors.IsInternalError(err) || apierrors.IsUnexpectedServerError(err):
+		return errorClassServer
+	case errors.As(err, &netErr):
+		return errorClassNetwork
+	default:
+		return errorClassUnknown
+	}
+}
+
+// isPermanentError returns true if an error won't go away by retrying
+func isPermanentError(err error) bool {
+	return apierrors.IsNotFound(err) || apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err)
+}
+
+// withRetries calls process until it succeeds, fails with a permanent error, the context is done, or it was called
+// maxProcessAttempts times, backing off exponentially between calls. The number of retries is returned along with the result.
+func withRetries[T any](ctx context.Context, process func() (T, error)) (T, int, error) {
+	retries := 0
+	for {
+		result, err := process()
+		if err == nil || ctx.Err() != nil || isPermanentError(err) || retries == maxProcessAttempts-1 {
+			return result, retries, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return result, retries, err
+		case <-time.After(processRetryDelay * time.Duration(1<<uint(retries))):
+			retries++
+		}
+	}
+}
+
+// retriedError is the error of an API call which still failed after being retried
+type retriedError struct {
+	err     error
+	retries int
+}
+
+func (e *retriedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retriedError) Unwrap() error {
+	return e.err
+}
+
+// retriesOf returns the number of times the API calls which failed with the error were retried, 0 if they weren't
+func retriesOf(err error) int {
+	var retried *retriedError
+	if errors.As(err, &retried) {
+		return retried.retries
+	}
+	return 0
+}
+
+// newCollectionError records an error for a namespace or node, leaving its message out when hiding names
+func newCollectionError(kind, name string, err error, retries int, obfuscate bool) models.CollectionError {
+	collectionErr := models.CollectionError{
+		Kind:    kind,
+		Name:    name,
+		Class:   classifyError(err),
+		Retries: retries,
+	}
+	if !obfuscate {
+		collectionErr.Message = err.Error()
+	}
+	return collectionErr
+}
+
+// sortCollectionErrors sorts the collection errors by kind then name, as they're recorded in the order they occur
+func sortCollectionErrors(collectionErrs []models.CollectionError) {
+	sort.Slice(collectionErrs, func(i, j int) bool {
+		if collectionErrs[i].Kind != collectionErrs[j].Kind {
+			return collectionErrs[i].Kind < collectionErrs[j].Kind
+		}
+		return collectionErrs[i].Name < collectionErrs[j].Name
+	})
+}
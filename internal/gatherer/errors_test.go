@@ -0,0 +1,165 @@
+//go:build test || unit
+
+package gatherer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/solo-io/istio-usage-collector/internal/utils"
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	metricsfake "k8s.io/metrics/pkg/client/clientset/versioned/fake"
+)
+
+func TestClassifyError(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{err: fmt.Errorf("failed to list pods: %w", context.DeadlineExceeded), expected: errorClassTimeout},
+		{err: apierrors.NewTimeoutError("timed out", 1), expected: errorClassTimeout},
+		{err: apierrors.NewForbidden(pods, "", fmt.Errorf("denied")), expected: errorClassForbidden},
+		{err: apierrors.NewUnauthorized("expired token"), expected: errorClassUnauthorized},
+		{err: apierrors.NewNotFound(pods, "web"), expected: errorClassNotFound},
+		{err: apierrors.NewTooManyRequests("slow down", 1), expected: errorClassThrottled},
+		{err: apierrors.NewServiceUnavailable("unavailable"), expected: errorClassServer},
+		{err: fmt.Errorf("failed to get namespace details: %w", &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}), expected: errorClassNetwork},
+		{err: fmt.Errorf("something else"), expected: errorClassUnknown},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, classifyError(tt.err), tt.err.Error())
+	}
+}
+
+func TestWithRetries(t *testing.T) {
+	processRetryDelay = 0
+	ctx := context.Background()
+
+	t.Run("Transient errors are retried", func(t *testing.T) {
+		calls := 0
+		result, retries, err := withRetries(ctx, func() (int, error) {
+			calls++
+			if calls < 2 {
+				return 0, apierrors.NewServiceUnavailable("unavailable")
+			}
+			return calls, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 2, result)
+		assert.Equal(t, 1, retries)
+	})
+
+	t.Run("Retries stop after the maximum number of attempts", func(t *testing.T) {
+		calls := 0
+		_, retries, err := withRetries(ctx, func() (int, error) {
+			calls++
+			return 0, apierrors.NewTooManyRequests("slow down", 1)
+		})
+		assert.Error(t, err)
+		assert.Equal(t, maxProcessAttempts, calls)
+		assert.Equal(t, maxProcessAttempts-1, retries)
+	})
+
+	t.Run("Permanent errors aren't retried", func(t *testing.T) {
+		calls := 0
+		_, retries, err := withRetries(ctx, func() (int, error) {
+			calls++
+			return 0, apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", fmt.Errorf("denied"))
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, 0, retries)
+	})
+}
+
+func TestProcessNamespacesCollectionErrors(t *testing.T) {
+	processRetryDelay = 0
+	ctx := context.Background()
+	kubeObjects := []runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "broken"}},
+	}
+	newClient := func() *fake.Clientset {
+		fakeClient := fake.NewSimpleClientset(kubeObjects...)
+		fakeClient.PrependReactor("list", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+			if action.GetNamespace() == "broken" {
+				return true, nil, apierrors.NewServiceUnavailable("unavailable")
+			}
+			return false, nil, nil
+		})
+		return fakeClient
+	}
+
+	t.Run("Failed namespaces are recorded and the others are collected", func(t *testing.T) {
+		clusterInfo := models.NewClusterInfo()
+		err := processNamespaces(ctx, newClient(), metricsfake.NewSimpleClientset(), nil, &meshInfo{densities: newNodeDensities(false)}, &Checkpointer{}, clusterInfo, &utils.Config{NoProgress: true}, false)
+		require.NoError(t, err)
+
+		assert.Contains(t, clusterInfo.Namespaces, "default")
+		assert.NotContains(t, clusterInfo.Namespaces, "broken")
+		require.Len(t, clusterInfo.CollectionErrors, 1)
+		assert.Equal(t, models.CollectionError{
+			Kind:    stateKindNamespace,
+			Name:    "broken",
+			Class:   errorClassServer,
+			Retries: maxProcessAttempts - 1,
+			Message: "failed to list pods: unavailable",
+		}, clusterInfo.CollectionErrors[0])
+	})
+
+	t.Run("Only the failing API calls are retried", func(t *testing.T) {
+		fakeClient := fake.NewSimpleClientset(kubeObjects...)
+		failures := 1
+		fakeClient.PrependReactor("list", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+			if action.GetNamespace() == "broken" && failures > 0 {
+				failures--
+				return true, nil, apierrors.NewServiceUnavailable("unavailable")
+			}
+			return false, nil, nil
+		})
+		fakeMetricsClient := metricsfake.NewSimpleClientset()
+		clusterInfo := models.NewClusterInfo()
+		err := processNamespaces(ctx, fakeClient, fakeMetricsClient, nil, &meshInfo{densities: newNodeDensities(false)}, &Checkpointer{}, clusterInfo, &utils.Config{NoProgress: true}, true)
+		require.NoError(t, err)
+		assert.Contains(t, clusterInfo.Namespaces, "broken")
+		assert.Empty(t, clusterInfo.CollectionErrors)
+
+		// the namespace and its metrics are read once, and only the pods are read again
+		calls := make(map[string]int)
+		for _, action := range append(fakeClient.Actions(), fakeMetricsClient.Actions()...) {
+			if get, ok := action.(clienttesting.GetAction); (ok && get.GetName() == "broken") || action.GetNamespace() == "broken" {
+				calls[action.GetVerb()+" "+action.GetResource().GroupResource().String()]++
+			}
+		}
+		assert.Equal(t, map[string]int{"get namespaces": 1, "list pods": 2, "list pods.metrics.k8s.io": 1}, calls)
+	})
+
+	t.Run("Error messages are left out when hiding names", func(t *testing.T) {
+		clusterInfo := models.NewClusterInfo()
+		err := processNamespaces(ctx, newClient(), metricsfake.NewSimpleClientset(), nil, &meshInfo{densities: newNodeDensities(true)}, &Checkpointer{}, clusterInfo, &utils.Config{NoProgress: true, ObfuscateNames: true}, false)
+		require.NoError(t, err)
+
+		require.Len(t, clusterInfo.CollectionErrors, 1)
+		assert.Equal(t, ObfuscateName("broken"), clusterInfo.CollectionErrors[0].Name)
+		assert.Empty(t, clusterInfo.CollectionErrors[0].Message)
+	})
+
+	t.Run("The collection fails with --fail-fast", func(t *testing.T) {
+		clusterInfo := models.NewClusterInfo()
+		err := processNamespaces(ctx, newClient(), metricsfake.NewSimpleClientset(), nil, &meshInfo{densities: newNodeDensities(false)}, &Checkpointer{}, clusterInfo, &utils.Config{NoProgress: true, FailFast: true}, false)
+		assert.ErrorContains(t, err, "encountered 1 errors processing namespaces")
+		assert.Empty(t, clusterInfo.CollectionErrors)
+	})
+}
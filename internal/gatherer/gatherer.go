@@ -20,41 +20,42 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	v1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
 	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
 )
 
-// GatherClusterInfo gathers information about the Kubernetes cluster
-func GatherClusterInfo(ctx context.Context, cfg *utils.Config) error {
+// GatherClusterInfo gathers information about the Kubernetes cluster, saving checkpoints through the checkpointer while gathering.
+// If the checkpointer is nil, checkpoints are saved at the default interval. If some namespaces or nodes couldn't be collected,
+// the output is still saved with their errors, and a *PartialCollectionError is returned.
+func GatherClusterInfo(ctx context.Context, cfg *utils.Config, checkpoint *Checkpointer) error {
 	logging.Debug("Gathering cluster info for %s", cfg.KubeContext)
 
-	// Initialize the cluster info
-	clusterInfo := models.NewClusterInfo()
-
-	// Create the output file path
+	// Create the output and state file paths
 	outputFile := filepath.Join(cfg.OutputDir, fmt.Sprintf("%s.%s", cfg.OutputFilePrefix, cfg.OutputFormat))
+	stateFile := filepath.Join(cfg.OutputDir, fmt.Sprintf("%s.state", cfg.OutputFilePrefix))
+
+	// Get cluster name
+	cluster, err := getClusterName(ctx, cfg.KubeContext, cfg.ObfuscateNames)
+	if err != nil {
+		return fmt.Errorf("failed to get cluster name: %w", err)
+	}
 
-	// Check if we should load existing data
+	// Initialize the cluster info and the state of the collection, or load them if continuing an interrupted collection
+	clusterInfo := models.NewClusterInfo()
+	clusterInfo.Name = cluster
+	state := newResumeState(cluster, cfg)
+	resumed := false
 	if cfg.ContinueProcessing {
-		logging.Info("Continuing from existing data file %s", outputFile)
-		existingData, err := loadExistingData(outputFile)
+		existingData, existingState, err := resumeCollection(outputFile, stateFile, cluster, cfg)
 		if err != nil {
-			logging.Warn("Failed to load existing data: %v. Starting fresh.", err)
-		} else {
-			// verify that it is the same cluster
-			name := cfg.KubeContext
-			if cfg.ObfuscateNames {
-				name = ObfuscateName(name)
-			}
-
-			if name != existingData.Name {
-				logging.Error("Existing data is from a different cluster or name obfuscation is changed. Please delete the existing file and try again.")
-				os.Exit(1)
-			} else {
-				clusterInfo = existingData
-				logging.Info("Loaded existing data with %d namespaces", len(clusterInfo.Namespaces))
-			}
+			return err
+		}
+		if existingData != nil {
+			clusterInfo = existingData
+			state = existingState
+			resumed = true
 		}
 	}
 
@@ -70,19 +71,58 @@ func GatherClusterInfo(ctx context.Context, cfg *utils.Config) error {
 		}
 	}
 
+	// The dynamic client is used to read Istio's custom resources, which are optional
+	dynamicClient, err := utils.CreateDynamicClient(cfg.KubeContext)
+	if err != nil {
+		logging.Warn("Failed to create dynamic client, Istio resources will not be gathered: %v", err)
+	}
+
 	if !hasMetrics {
 		logging.Warn("Metrics API not available")
 	} else {
 		logging.Info("Metrics API available")
 	}
 
-	// Get cluster name
-	if clusterInfo.Name == "" {
-		cluster, err := getClusterName(ctx, cfg.KubeContext, cfg.ObfuscateNames)
-		if err != nil {
-			return fmt.Errorf("failed to get cluster name: %w", err)
+	// Describe the collection, which started when its first run did if it's continued
+	clusterInfo.Metadata = newMetadata(regularClient, cfg, state.StartedAt)
+
+	// Checkpoint the cluster info while it's gathered, and save what was gathered if the collection is interrupted or fails,
+	// so it can be continued from
+	if checkpoint == nil {
+		checkpoint = NewCheckpointer(DefaultCheckpointInterval)
+	}
+	// The pods of every namespace are aggregated by node while walking them, to be attached to each node. The totals of the
+	// namespaces completed by an interrupted collection are restored, as those namespaces are skipped.
+	densities := newNodeDensities(cfg.ObfuscateNames)
+	densities.restore(state.NodeTotals)
+	checkpoint.start(clusterInfo, outputFile, state, stateFile, densities)
+	completed := false
+	defer func() {
+		if completed {
+			return
+		}
+		if err := checkpoint.Save(); err != nil {
+			logging.Warn("Failed to save checkpoint: %v", err)
+		}
+	}()
+
+	// Sample the metrics API over the sampling window, before the timeout below starts as the window may be longer.
+	// The samples are saved after each sample, so a resumed collection can continue sampling.
+	var samples *metricsSamples
+	samplesFile := filepath.Join(cfg.OutputDir, fmt.Sprintf("%s.samples.json", cfg.OutputFilePrefix))
+	if cfg.SampleDuration > 0 {
+		if !hasMetrics {
+			logging.Warn("Metrics API not available, metrics will not be sampled")
+		} else {
+			logging.Info("Sampling metrics every %s for %s", cfg.SampleInterval, cfg.SampleDuration)
+			samples, err = sampleMetrics(ctx, metricsClient, samplesFile, state, resumed, cfg)
+			if err != nil {
+				if ctx.Err() != nil {
+					return fmt.Errorf("metrics sampling cancelled: %w", ctx.Err())
+				}
+				logging.Warn("Failed to sample metrics, getting the current usage instead: %v", err)
+			}
 		}
-		clusterInfo.Name = cluster
 	}
 
 	// Create a context with a timeout to ensure we don't get stuck forever
@@ -90,9 +130,24 @@ func GatherClusterInfo(ctx context.Context, cfg *utils.Config) error {
 	ctxWithTimeout, cancel := context.WithTimeout(ctx, 30*time.Minute)
 	defer cancel()
 
-	// Process namespaces concurrently
+	// Gather the istio control plane, keeping any previously gathered information if it fails
+	logging.Info("Gathering control plane information")
+	rootNamespace := utils.DefaultIstioNamespace
+	controlPlane, controlPlaneRoot, err := processControlPlane(ctxWithTimeout, regularClient, metricsClient, hasMetrics)
+	if err != nil {
+		if ctxWithTimeout.Err() != nil {
+			return fmt.Errorf("control plane processing cancelled: %w", ctxWithTimeout.Err())
+		}
+		logging.Warn("Failed to gather control plane information, assuming the root namespace is %s: %v", rootNamespace, err)
+	} else {
+		checkpoint.update(func() { clusterInfo.ControlPlane = controlPlane })
+		rootNamespace = controlPlaneRoot
+	}
+
+	// Process namespaces concurrently, loading the mesh-wide Istio configuration they share first
 	logging.Info("Gathering namespace information")
-	err = processNamespaces(ctxWithTimeout, regularClient, metricsClient, clusterInfo, cfg, hasMetrics)
+	mesh := &meshInfo{rootNamespace: rootNamespace, densities: densities, samples: samples}
+	err = processNamespaces(ctxWithTimeout, regularClient, metricsClient, dynamicClient, mesh, checkpoint, clusterInfo, cfg, hasMetrics)
 	if err != nil {
 		if ctxWithTimeout.Err() != nil {
 			return fmt.Errorf("namespace processing cancelled: %w", ctxWithTimeout.Err())
@@ -100,9 +155,23 @@ func GatherClusterInfo(ctx context.Context, cfg *utils.Config) error {
 		return fmt.Errorf("failed to process namespaces: %w", err)
 	}
 
+	// Evaluate which namespaces can move to ambient mode, based on the Istio resources and the gathered namespaces
+	if dynamicClient != nil {
+		logging.Info("Analyzing ambient migration readiness")
+		readiness, err := analyzeMigrationReadiness(ctxWithTimeout, dynamicClient, mesh, clusterInfo, cfg)
+		if err != nil {
+			if ctxWithTimeout.Err() != nil {
+				return fmt.Errorf("migration readiness analysis cancelled: %w", ctxWithTimeout.Err())
+			}
+			logging.Warn("Failed to analyze migration readiness: %v", err)
+		} else {
+			checkpoint.update(func() { clusterInfo.MigrationReadiness = readiness })
+		}
+	}
+
 	// Process nodes concurrently
 	logging.Info("Gathering node information")
-	err = processNodes(ctxWithTimeout, regularClient, metricsClient, clusterInfo, cfg, hasMetrics)
+	err = processNodes(ctxWithTimeout, regularClient, metricsClient, densities, samples, checkpoint, clusterInfo, cfg, hasMetrics)
 	if err != nil {
 		if ctxWithTimeout.Err() != nil {
 			return fmt.Errorf("node processing cancelled: %w", ctxWithTimeout.Err())
@@ -110,15 +179,24 @@ func GatherClusterInfo(ctx context.Context, cfg *utils.Config) error {
 		return fmt.Errorf("failed to process nodes: %w", err)
 	}
 
-	// Set metrics availability flag
-	clusterInfo.HasMetrics = hasMetrics
-
-	// Output to file
-	err = saveClusterInfo(clusterInfo, outputFile)
+	// Output to file, marked as complete along with the metrics availability flag
+	err = checkpoint.finish(func() error {
+		clusterInfo.HasMetrics = hasMetrics
+		clusterInfo.Partial = false
+		finishMetadata(clusterInfo.Metadata)
+		sortCollectionErrors(clusterInfo.CollectionErrors)
+		return saveClusterInfo(clusterInfo, outputFile)
+	})
 	if err != nil {
 		return fmt.Errorf("failed to save cluster info: %w", err)
 	}
+	completed = true
 
+	// The samples are kept along with the state file while some namespaces or nodes are left to retry, which use them as well
+	if len(clusterInfo.CollectionErrors) > 0 {
+		return &PartialCollectionError{Failed: len(clusterInfo.CollectionErrors)}
+	}
+	removeSamples(samplesFile)
 	return nil
 }
 
@@ -140,8 +218,9 @@ func loadExistingData(fileName string) (*models.ClusterInfo, error) {
 
 	fileExt := filepath.Ext(fileName)
 
-	// Initialize clusterInfo before unmarshaling
+	// Initialize clusterInfo before unmarshaling, without metadata so a file missing its schema version is detected
 	clusterInfo := models.NewClusterInfo()
+	clusterInfo.Metadata = nil
 
 	switch fileExt {
 	case ".json":
@@ -155,12 +234,15 @@ func loadExistingData(fileName string) (*models.ClusterInfo, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse file: %w", err)
 	}
+	if err := checkSchemaVersion(clusterInfo); err != nil {
+		return nil, err
+	}
 
 	return clusterInfo, nil
 }
 
 // processNodes processes all nodes in the cluster
-func processNodes(ctx context.Context, clientset kubernetes.Interface, metricsClient metricsv.Interface, clusterInfo *models.ClusterInfo, cfg *utils.Config, hasMetrics bool) error {
+func processNodes(ctx context.Context, clientset kubernetes.Interface, metricsClient metricsv.Interface, densities *nodeDensities, samples *metricsSamples, checkpoint *Checkpointer, clusterInfo *models.ClusterInfo, cfg *utils.Config, hasMetrics bool) error {
 	logging.Debug("Processing nodes for cluster %s", cfg.KubeContext)
 
 	// Check if the context is cancelled
@@ -174,6 +256,33 @@ func processNodes(ctx context.Context, clientset kubernetes.Interface, metricsCl
 		return fmt.Errorf("failed to list nodes: %w", err)
 	}
 
+	// Nodes processed by an interrupted collection which were removed since are stale, so they're removed from the output
+	if cfg.ContinueProcessing {
+		existing := make(map[string]struct{}, len(nodes.Items))
+		for _, node := range nodes.Items {
+			outNodeName := node.Name
+			if cfg.ObfuscateNames {
+				outNodeName = ObfuscateName(node.Name)
+			}
+			existing[outNodeName] = struct{}{}
+		}
+		checkpoint.guard(func() { removeStale(stateKindNode, clusterInfo.Nodes, existing) })
+	}
+
+	// The densities only count the pods of the namespaces in the output, so the pods of failed namespaces aren't counted
+	failedNamespaces := 0
+	checkpoint.guard(func() {
+		densities.retain(clusterInfo.Namespaces)
+		for _, collectionErr := range clusterInfo.CollectionErrors {
+			if collectionErr.Kind == stateKindNamespace {
+				failedNamespaces++
+			}
+		}
+	})
+	if failedNamespaces > 0 && densities != nil {
+		logging.Warn("The node densities don't count the pods of the %d namespaces which couldn't be collected", failedNamespaces)
+	}
+
 	totalNodes := len(nodes.Items)
 	if totalNodes == 0 {
 		logging.Warn("No nodes found in cluster %s", cfg.KubeContext)
@@ -187,8 +296,6 @@ func processNodes(ctx context.Context, clientset kubernetes.Interface, metricsCl
 		logging.Info("Found %d nodes to process", totalNodes)
 	}
 
-	// Use a mutex for safe map updates
-	var mu sync.Mutex
 	var wg sync.WaitGroup
 
 	// Create a context for cancellation
@@ -224,7 +331,17 @@ func processNodes(ctx context.Context, clientset kubernetes.Interface, metricsCl
 
 		// Check if we should skip this node if continuing
 		if cfg.ContinueProcessing {
-			if _, ok := clusterInfo.Nodes[outNodeName]; ok {
+			processed := false
+			checkpoint.guard(func() {
+				var nodeInfo models.NodeInfo
+				nodeInfo, processed = clusterInfo.Nodes[outNodeName]
+				// the namespaces retried since the node was processed add to its density
+				if processed && densities != nil {
+					nodeInfo.Density = densities.get(node.Name)
+					clusterInfo.Nodes[outNodeName] = nodeInfo
+				}
+			})
+			if processed {
 				logging.Debug("Node %s has already previously been processed, skipping", node.Name)
 				if progress != nil {
 					progress.Increment()
@@ -248,8 +365,10 @@ func processNodes(ctx context.Context, clientset kubernetes.Interface, metricsCl
 				return
 			}
 
-			// Process node
-			nodeInfo, err := processNode(workerCtx, metricsClient, node, hasMetrics)
+			// Process node, using the sampled usage instead of the current usage if metrics were sampled
+			nodeInfo, retries, err := withRetries(workerCtx, func() (models.NodeInfo, error) {
+				return processNode(workerCtx, metricsClient, node, hasMetrics && samples == nil, cfg)
+			})
 
 			// Update progress
 			if progress != nil {
@@ -258,14 +377,24 @@ func processNodes(ctx context.Context, clientset kubernetes.Interface, metricsCl
 
 			if err != nil {
 				logging.Warn("Failed to process node %s: %v", node.Name, err)
+				if workerCtx.Err() == nil {
+					// Record the error in the output, unless the collection fails because of it
+					checkpoint.fail(stateKindNode, outName)
+					if !cfg.FailFast {
+						collectionErr := newCollectionError(stateKindNode, outName, err, retries, cfg.ObfuscateNames)
+						checkpoint.update(func() { clusterInfo.CollectionErrors = append(clusterInfo.CollectionErrors, collectionErr) })
+					}
+				}
 				errorCh <- fmt.Errorf("node %s: %w", node.Name, err)
 				return
 			}
+			nodeInfo.Density = densities.get(node.Name)
+			if samples != nil {
+				nodeInfo.Resources.Actual, nodeInfo.Resources.Sampled = samples.nodeUsage(node.Name)
+			}
 
-			// Add node to cluster info with lock protection
-			mu.Lock()
-			clusterInfo.Nodes[outName] = nodeInfo
-			mu.Unlock()
+			// Add node to cluster info, which may save a checkpoint
+			checkpoint.update(func() { clusterInfo.Nodes[outName] = nodeInfo })
 		}(node, outNodeName)
 	}
 
@@ -288,15 +417,18 @@ func processNodes(ctx context.Context, clientset kubernetes.Interface, metricsCl
 		progress.Complete()
 	}
 
-	if len(errors) > 0 {
+	// Failed nodes are recorded in the cluster info, unless the collection should fail
+	if len(errors) > 0 && cfg.FailFast {
 		return fmt.Errorf("encountered %d errors processing nodes", len(errors))
 	}
 
 	return nil
 }
 
-// processNamespaces processes all namespaces in the cluster in parallel
-func processNamespaces(ctx context.Context, clientset kubernetes.Interface, metricsClient metricsv.Interface, clusterInfo *models.ClusterInfo, cfg *utils.Config, hasMetrics bool) error {
+// processNamespaces loads the mesh-wide Istio configuration into the mesh, then processes all namespaces in the cluster in
+// parallel, aggregating their pods by node into the mesh's densities. The mesh is left loaded for the analyses which follow.
+// Changes to the cluster info are applied through the checkpointer, which guards them against concurrent checkpoints.
+func processNamespaces(ctx context.Context, clientset kubernetes.Interface, metricsClient metricsv.Interface, dynamicClient dynamic.Interface, mesh *meshInfo, checkpoint *Checkpointer, clusterInfo *models.ClusterInfo, cfg *utils.Config, hasMetrics bool) error {
 	// Add context checking for cancellation
 	if ctx.Err() != nil {
 		return ctx.Err()
@@ -308,6 +440,19 @@ func processNamespaces(ctx context.Context, clientset kubernetes.Interface, metr
 		return fmt.Errorf("failed to list namespaces: %w", err)
 	}
 
+	// Namespaces processed by an interrupted collection which were deleted since are stale, so they're removed from the output
+	if cfg.ContinueProcessing {
+		existing := make(map[string]struct{}, len(namespaces.Items))
+		for _, ns := range namespaces.Items {
+			outNsName := ns.Name
+			if cfg.ObfuscateNames {
+				outNsName = ObfuscateName(ns.Name)
+			}
+			existing[outNsName] = struct{}{}
+		}
+		checkpoint.guard(func() { removeStale(stateKindNamespace, clusterInfo.Namespaces, existing) })
+	}
+
 	totalNamespaces := len(namespaces.Items)
 	if totalNamespaces == 0 {
 		logging.Warn("No namespaces found in cluster %s", cfg.KubeContext)
@@ -321,8 +466,6 @@ func processNamespaces(ctx context.Context, clientset kubernetes.Interface, metr
 		logging.Info("Found %d namespaces to process", totalNamespaces)
 	}
 
-	// Use a mutex for safe map updates
-	var mu sync.Mutex
 	var wg sync.WaitGroup
 
 	// Create a context that's cancellable for spawned goroutines
@@ -352,11 +495,79 @@ func processNamespaces(ctx context.Context, clientset kubernetes.Interface, metr
 		logging.Warn("No mutating webhook configurations found in cluster %s", cfg.KubeContext)
 	}
 	// filter out non-istio webhooks
-	istioWebhooks := utils.FilterIstioWebhooks(webhooks.Items)
-	if len(istioWebhooks) == 0 {
+	if cfg.CollectEnvoyStats {
+		mesh.envoy = newEnvoyScraper(clientset, semaphore)
+	}
+	if webhooks != nil {
+		mesh.webhooks = utils.FilterIstioWebhooks(webhooks.Items)
+	}
+	if len(mesh.webhooks) == 0 {
 		logging.Warn("No Istio-related mutating webhook configurations found in cluster %s", cfg.KubeContext)
 	}
 
+	// Get the sidecar injector configuration of each revision, which defines istiod's injection policy for pods matched by the webhooks
+	mesh.injectorConfigs, err = utils.LoadInjectorConfigs(ctx, clientset, mesh.webhooks)
+	if err != nil {
+		logging.Warn("Failed to load sidecar injector configuration, assuming the default injection policy: %v", err)
+	}
+
+	// Get the Sidecar resources, which scope the configuration each sidecar receives
+	if dynamicClient != nil {
+		mesh.sidecars, err = utils.ListSidecars(ctx, dynamicClient)
+		if err != nil {
+			logging.Warn("Failed to list Sidecar resources: %v", err)
+		}
+	}
+
+	// Get the PeerAuthentications, which define the mTLS mode of each namespace
+	if dynamicClient != nil {
+		mesh.peerAuthentications, err = utils.ListPeerAuthentications(ctx, dynamicClient)
+		if err != nil {
+			logging.Warn("Failed to list PeerAuthentications: %v", err)
+		}
+	}
+
+	// Count the Istio configuration objects of each namespace, along with the totals for the cluster
+	if dynamicClient != nil {
+		istioConfig, err := utils.CountIstioConfig(ctx, clientset.Discovery(), dynamicClient)
+		if err != nil {
+			logging.Warn("Failed to count Istio configuration: %v", err)
+		}
+		mesh.istioConfig = istioConfig.Namespaces
+		if len(istioConfig.Totals) > 0 {
+			checkpoint.guard(func() { clusterInfo.IstioConfig = istioConfig.Totals })
+		}
+	}
+
+	// Create the Prometheus client to read the historical usage of each namespace, if a server is configured
+	if cfg.Prometheus.URL != "" {
+		mesh.prometheus, err = utils.NewPrometheusClient(cfg.Prometheus)
+		if err != nil {
+			logging.Warn("Failed to create Prometheus client, historical usage will not be gathered: %v", err)
+		}
+	}
+
+	// Get the version of each revision, which sidecars injected today would run
+	if clusterInfo.ControlPlane != nil {
+		mesh.revisionVersions = make(map[string]string, len(clusterInfo.ControlPlane.Revisions))
+		for rev, revision := range clusterInfo.ControlPlane.Revisions {
+			mesh.revisionVersions[rev] = revision.Version
+		}
+	}
+
+	// Record the mesh-wide default sidecar resources of each revision
+	checkpoint.guard(func() {
+		for rev, injectorCfg := range mesh.injectorConfigs {
+			if clusterInfo.SidecarDefaults == nil {
+				clusterInfo.SidecarDefaults = make(map[string]models.ProxyResources)
+			}
+			clusterInfo.SidecarDefaults[rev] = models.ProxyResources{
+				Request: resourcesFromList(injectorCfg.ProxyResources.Requests),
+				Limit:   resourcesFromList(injectorCfg.ProxyResources.Limits),
+			}
+		}
+	})
+
 	for _, ns := range namespaces.Items {
 		// Check parent context for cancellation before spawning more goroutines
 		if ctx.Err() != nil {
@@ -370,7 +581,9 @@ func processNamespaces(ctx context.Context, clientset kubernetes.Interface, metr
 
 		// Check if we should skip this namespace if continuing
 		if cfg.ContinueProcessing {
-			if _, ok := clusterInfo.Namespaces[outNsName]; ok {
+			processed := false
+			checkpoint.guard(func() { _, processed = clusterInfo.Namespaces[outNsName] })
+			if processed {
 				logging.Debug("Namespace %s has already previously been processed, skipping", ns.Name)
 				if progress != nil {
 					progress.Increment()
@@ -394,20 +607,27 @@ func processNamespaces(ctx context.Context, clientset kubernetes.Interface, metr
 				return
 			}
 
-			nsInfo, err := processNamespace(workerCtx, clientset, metricsClient, namespace.Name, hasMetrics, istioWebhooks)
+			nsInfo, err := processNamespace(workerCtx, clientset, metricsClient, namespace.Name, hasMetrics, mesh, cfg)
 			if progress != nil {
 				progress.Increment()
 			}
 
 			if err != nil {
 				logging.Warn("Failed to process namespace %s: %v", namespace.Name, err)
+				if workerCtx.Err() == nil {
+					// Record the error in the output, unless the collection fails because of it
+					checkpoint.fail(stateKindNamespace, outName)
+					if !cfg.FailFast {
+						collectionErr := newCollectionError(stateKindNamespace, outName, err, retriesOf(err), cfg.ObfuscateNames)
+						checkpoint.update(func() { clusterInfo.CollectionErrors = append(clusterInfo.CollectionErrors, collectionErr) })
+					}
+				}
 				errorCh <- fmt.Errorf("namespace %s: %w", namespace.Name, err)
 				return
 			}
 
-			mu.Lock()
-			clusterInfo.Namespaces[outName] = nsInfo
-			mu.Unlock()
+			// Add namespace to cluster info, which may save a checkpoint
+			checkpoint.update(func() { clusterInfo.Namespaces[outName] = nsInfo })
 		}(ns, outNsName)
 	}
 
@@ -429,30 +649,63 @@ func processNamespaces(ctx context.Context, clientset kubernetes.Interface, metr
 		progress.Complete()
 	}
 
-	if len(errors) > 0 {
+	// Failed namespaces are recorded in the cluster info, unless the collection should fail
+	if len(errors) > 0 && cfg.FailFast {
 		return fmt.Errorf("encountered %d errors processing namespaces", len(errors))
 	}
 
 	return nil
 }
 
-// processNamespace processes an individual namespace and its pods
-// TODO: We currently don't check for Sidecar CRs -- https://istio.io/latest/docs/reference/config/networking/sidecar/
-func processNamespace(ctx context.Context, clientset kubernetes.Interface, metricsClient metricsv.Interface, namespace string, hasMetrics bool, istioWebhooks []admissionregistrationv1.MutatingWebhookConfiguration) (*models.NamespaceInfo, error) {
+// meshInfo holds the cluster-wide Istio information which is gathered once and shared when processing each namespace,
+// along with the per-node densities each namespace adds its pods to
+type meshInfo struct {
+	// rootNamespace is the Istio root namespace, whose configuration applies to the whole mesh
+	rootNamespace string
+	// webhooks are the istio mutating webhook configurations, which define automatic sidecar injection
+	webhooks []admissionregistrationv1.MutatingWebhookConfiguration
+	// injectorConfigs are the sidecar injector configurations, keyed by revision
+	injectorConfigs map[string]*utils.InjectorConfig
+	// revisionVersions are the versions of istiod, keyed by revision, used to detect sidecars from a different version
+	revisionVersions map[string]string
+	// sidecars are the Sidecar resources, keyed by namespace, nil if they couldn't be read
+	sidecars map[string][]utils.Sidecar
+	// peerAuthentications are the PeerAuthentications keyed by namespace, nil if they couldn't be read
+	peerAuthentications map[string][]utils.PeerAuthentication
+	// istioConfig are the number of Istio configuration objects of each kind, keyed by namespace then kind
+	istioConfig map[string]map[string]int
+	// densities aggregate the pods of every namespace by node, nil if they aren't aggregated
+	densities *nodeDensities
+	// prometheus reads the historical usage of each namespace, nil if Prometheus isn't queried
+	prometheus *utils.PrometheusClient
+	// samples are the samples of the metrics API taken over the sampling window, nil if metrics aren't sampled
+	samples *metricsSamples
+	// envoy reads the Envoy stats of each sidecar, nil if Envoy stats aren't collected
+	envoy *envoyScraper
+}
+
+// processNamespace processes an individual namespace and its pods.
+// The API calls the namespace can't be processed without are retried on their own, so a retry doesn't repeat the rest of the
+// processing; the error they fail with records how many times they were retried.
+func processNamespace(ctx context.Context, clientset kubernetes.Interface, metricsClient metricsv.Interface, namespace string, hasMetrics bool, mesh *meshInfo, cfg *utils.Config) (*models.NamespaceInfo, error) {
 	if ctx.Err() != nil {
 		return nil, ctx.Err()
 	}
 
 	// Check if namespace has Istio injection
-	ns, err := clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	ns, retries, err := withRetries(ctx, func() (*corev1.Namespace, error) {
+		return clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get namespace details: %w", err)
+		return nil, &retriedError{err: fmt.Errorf("failed to get namespace details: %w", err), retries: retries}
 	}
 
 	// Get pods in the namespace
-	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	pods, podRetries, err := withRetries(ctx, func() (*corev1.PodList, error) {
+		return clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to list pods: %w", err)
+		return nil, &retriedError{err: fmt.Errorf("failed to list pods: %w", err), retries: retries + podRetries}
 	}
 
 	// Check context cancellation after pods API call
@@ -461,7 +714,10 @@ func processNamespace(ctx context.Context, clientset kubernetes.Interface, metri
 	}
 
 	var metricsData *v1beta1.PodMetricsList
-	if hasMetrics && metricsClient != nil {
+	if mesh.samples != nil {
+		// the usage sampled over the sampling window is averaged, rather than getting the current usage
+		metricsData = mesh.samples.averagePodMetrics(namespace)
+	} else if hasMetrics && metricsClient != nil {
 		logging.Debug("Getting metrics for namespace %s", namespace)
 		// Get metrics in a safe way with retry logic
 		metricsData, err = getMetricsWithRetries(ctx, metricsClient, namespace)
@@ -476,35 +732,154 @@ func processNamespace(ctx context.Context, clientset kubernetes.Interface, metri
 		return nil, ctx.Err()
 	}
 
-	// Count regular and istio containers
-	regularContainers := 0
-	istioContainers := 0
+	// Resource totals of the namespace for each type of container
+	var totals resourceTotals
+
+	// The type of each container of the pods counted in the totals, keyed by pod name then container name.
+	// Metrics are joined to the pods through it, so actual usage is classified exactly the same way as requests.
+	podContainers := make(map[string]map[string]containerType)
 
-	// Resource values for both types
-	regularCpuRequest := 0.0
-	regularMemRequest := 0.0
-	istioCpuRequest := 0.0
-	istioMemRequest := 0.0
+	// The number of replicas of each ingress and egress gateway, keyed by the workload running it. Distinct gateways can
+	// share the same labels (e.g. several deployments labelled "istio: ingressgateway"), so they're told apart by workload.
+	gatewayReplicas := make(map[workloadKey]int)
 
-	// Actual usage (from metrics API)
-	regularCpuActual := 0.0
-	regularMemActual := 0.0
-	istioCpuActual := 0.0
-	istioMemActual := 0.0
+	// Resource totals of each workload, and the workload that each pod's metrics should be attributed to, keyed by pod name.
+	// Pods are only resolved to their workload if the per-workload breakdown is enabled.
+	var owners *ownerResolver
+	if cfg.IncludeWorkloads {
+		owners = newOwnerResolver(ctx, clientset, namespace)
+	}
+	workloads := make(map[workloadKey]*workloadTotals)
+	podWorkloads := make(map[string]*workloadTotals)
 
-	// Whether the namespace has at least one pod with istio injection
+	// Whether the namespace has at least one pod with istio injection, and the number of pods per injection decision reason
 	isIstioInjected := false
+	injectionReasons := make(map[string]int)
+
+	// The number of pods injected by each revision, and the number of injected pods whose sidecar runs a different version
+	// than the revision injecting them today, which need a restart to be brought up to date
+	injectedRevisions := make(map[string]int)
+	podsNeedingRestart := 0
+
+	// Whether the namespace is enrolled in ambient mode, or has at least one pod enrolled in ambient mode
+	isAmbientEnrolled := utils.IsNamespaceAmbientEnrolled(ns.Labels)
+	ambientPods := 0
+
+	// Whether each sidecar uses the default proxy resources or overrides them through annotations
+	sidecarProfiles := &models.SidecarProfiles{}
+
+	// The number of pods contributing to the totals, and the number of pods which aren't running
+	includedPods := 0
+	nonRunningPods := &models.PodPhaseCounts{}
+
+	// The pods and sidecars on each node, keyed by node name, and the node each pod's sidecar metrics should be attributed to, keyed by pod name
+	nodes := make(map[string]*nodeTotals)
+	podNodes := make(map[string]*nodeTotals)
+
+	// The pods counted in the totals which run an injected sidecar, whose Envoy stats are read if collected
+	var sidecarPods []string
 
 	// Process all pods
 	for _, pod := range pods.Items {
+		// Completed, failed (including evicted), pending and unknown pods don't consume resources the way running pods do,
+		// so they are only counted unless explicitly included
+		if pod.Status.Phase != corev1.PodRunning {
+			countPodPhase(nonRunningPods, pod.Status.Phase)
+			if !cfg.IncludeNonRunningPods {
+				logging.Debug("%s.%s is in phase %q, excluding it from the resource totals", namespace, pod.Name, pod.Status.Phase)
+				continue
+			}
+		}
+		includedPods++
+
+		// Pods which aren't scheduled yet have no node
+		var node *nodeTotals
+		if pod.Spec.NodeName != "" {
+			node = nodes[pod.Spec.NodeName]
+			if node == nil {
+				node = &nodeTotals{}
+				nodes[pod.Spec.NodeName] = node
+			}
+			node.pods++
+			podNodes[pod.Name] = node
+		}
+
+		// The pod's resources are added to the namespace totals, and to its workload's totals if workloads are resolved
+		targets := []*resourceTotals{&totals}
+		var workload *workloadTotals
+		if owners != nil {
+			key := owners.resolve(&pod)
+			workload = workloads[key]
+			if workload == nil {
+				workload = &workloadTotals{}
+				workloads[key] = workload
+			}
+			workload.replicas++
+			podWorkloads[pod.Name] = workload
+			targets = append(targets, &workload.totals)
+		}
+
+		containers := make(map[string]containerType)
+		podContainers[pod.Name] = containers
+
+		// Classic init containers run to completion before the pod starts, so they are tracked separately
+		for _, container := range pod.Spec.InitContainers {
+			if !isNativeSidecar(container) {
+				addContainer(targets, initContainer, container.Resources)
+				containers[container.Name] = initContainer
+			}
+		}
+
+		// The ambient data plane (ztunnel and waypoints) and gateways are reported separately from the workloads they serve.
+		// Their istio-proxy isn't a sidecar, and gateways remain in place after migrating to ambient mode.
+		if proxyType, ok := proxyContainerType(&pod); ok {
+			for _, container := range podLongRunningContainers(&pod) {
+				addContainer(targets, proxyType, container.Resources)
+				containers[container.Name] = proxyType
+			}
+			if utils.IsGatewayPod(pod.Labels) {
+				gatewayReplicas[podWorkload(&pod, owners)]++
+			}
+			continue
+		}
+
 		// Check if istio injection is enabled on the pod-level
-		isPodIstioInjected := utils.CheckInject(istioWebhooks, pod.Labels, ns.Labels)
+		injection := utils.CheckInject(mesh.webhooks, mesh.injectorConfigs, &pod, ns.Labels)
+		isPodIstioInjected := injection.Injected
+		injectionReasons[injection.Reason]++
+		logging.Debug("%s.%s istio injection: %t (%s)", namespace, pod.Name, injection.Injected, injection.Reason)
 
 		// If any pod within the namespace has istio injection occurring, we should count the namespace as having istio injected
 		isIstioInjected = isIstioInjected || isPodIstioInjected
 
-		// Check each container
-		for _, container := range pod.Spec.Containers {
+		if isPodIstioInjected {
+			injectedRevisions[injection.Revision]++
+			revisionVersion := mesh.revisionVersions[injection.Revision]
+			proxyVersion := sidecarVersion(&pod)
+			if revisionVersion != "" && proxyVersion != "" && !utils.SameVersion(revisionVersion, proxyVersion) {
+				logging.Debug("%s.%s runs istio-proxy %s, but revision %s would inject %s", namespace, pod.Name, proxyVersion, injection.Revision, revisionVersion)
+				podsNeedingRestart++
+			}
+		}
+		if workload != nil {
+			workload.isIstioInjected = workload.isIstioInjected || isPodIstioInjected
+		}
+
+		// Pods with a sidecar are not captured by ztunnel, so they are never counted as ambient
+		isPodAmbientEnrolled := !isPodIstioInjected && !pod.Spec.HostNetwork && utils.IsAmbientEnrolled(pod.Labels, ns.Labels)
+		if isPodAmbientEnrolled {
+			ambientPods++
+			isAmbientEnrolled = true
+			if workload != nil {
+				workload.isAmbientEnrolled = true
+			}
+		}
+		if node != nil && (isPodIstioInjected || isPodAmbientEnrolled) {
+			node.meshedPods++
+		}
+
+		// Check each long-running container, which includes init containers running as native sidecars
+		for _, container := range podLongRunningContainers(&pod) {
 			// we only count istio-proxy container as an istio sidecar if the pod has istio injection enabled
 			isIstioProxyContainer := container.Name == "istio-proxy"
 			isIstioProxy := isIstioProxyContainer && isPodIstioInjected
@@ -513,104 +888,467 @@ func processNamespace(ctx context.Context, clientset kubernetes.Interface, metri
 				logging.Debug("%s.%s does not have istio injection enabled, treating its 'istio-proxy' container as a regular container", namespace, pod.Name)
 			}
 
-			// Count container types
 			if isIstioProxy {
-				istioContainers++
-			} else {
-				regularContainers++
-			}
-
-			// Get CPU request
-			if container.Resources.Requests != nil {
-				if cpu, ok := container.Resources.Requests[corev1.ResourceCPU]; ok {
-					if isIstioProxy {
-						istioCpuRequest += cpu.AsApproximateFloat64()
-					} else {
-						regularCpuRequest += cpu.AsApproximateFloat64()
-					}
-				}
-
-				// Get memory request
-				if mem, ok := container.Resources.Requests[corev1.ResourceMemory]; ok {
-					memInGiB := float64(mem.Value()) / (1024 * 1024 * 1024)
-					if isIstioProxy {
-						istioMemRequest += memInGiB
-					} else {
-						regularMemRequest += memInGiB
-					}
+				addContainer(targets, istioContainer, container.Resources)
+				containers[container.Name] = istioContainer
+				countSidecarProfile(sidecarProfiles, pod.Annotations)
+				sidecarPods = append(sidecarPods, pod.Name)
+				if node != nil {
+					node.sidecars.addContainer(container.Resources)
 				}
+			} else {
+				addContainer(targets, regularContainer, container.Resources)
+				containers[container.Name] = regularContainer
 			}
 		}
 	}
 
-	// Process metrics data if available
+	// Process metrics data if available, only attributing the metrics of pods counted in the totals
+	unmatchedPodMetrics := 0
 	if metricsData != nil {
+		listedPods := make(map[string]struct{}, len(pods.Items))
+		for _, pod := range pods.Items {
+			listedPods[pod.Name] = struct{}{}
+		}
+
 		for _, podMetric := range metricsData.Items {
-			for _, containerMetric := range podMetric.Containers {
-				isIstioProxy := containerMetric.Name == "istio-proxy"
-
-				// CPU usage
-				cpuUsage := containerMetric.Usage.Cpu().AsApproximateFloat64()
-				if isIstioProxy {
-					istioCpuActual += cpuUsage
-				} else {
-					regularCpuActual += cpuUsage
+			containers, ok := podContainers[podMetric.Name]
+			if !ok {
+				// pods which were listed but aren't counted in the totals (such as completed pods) are skipped as well
+				if _, listed := listedPods[podMetric.Name]; !listed {
+					// the pod was deleted (or created) between listing the pods and getting the metrics
+					logging.Debug("%s.%s has metrics but wasn't listed, skipping its metrics", namespace, podMetric.Name)
+					unmatchedPodMetrics++
 				}
+				continue
+			}
 
-				// Memory usage in GB
-				memUsage := float64(containerMetric.Usage.Memory().Value()) / (1024 * 1024 * 1024)
-				if isIstioProxy {
-					istioMemActual += memUsage
-				} else {
-					regularMemActual += memUsage
+			targets := []*resourceTotals{&totals}
+			if workload, ok := podWorkloads[podMetric.Name]; ok {
+				targets = append(targets, &workload.totals)
+			}
+
+			for _, containerMetric := range podMetric.Containers {
+				// containers missing from the pod's spec have no requests either, so their usage is skipped
+				usageType, ok := containers[containerMetric.Name]
+				if !ok {
+					logging.Debug("%s.%s has metrics for container %s which isn't in its spec, skipping its metrics", namespace, podMetric.Name, containerMetric.Name)
+					continue
+				}
+				for _, target := range targets {
+					target.get(usageType).addUsage(containerMetric.Usage)
+				}
+				if node, ok := podNodes[podMetric.Name]; ok && usageType == istioContainer {
+					node.sidecars.addUsage(containerMetric.Usage)
 				}
 			}
 		}
 	}
 
-	// Create namespace info (before appending actual resource usage and istio resources)
+	// Add the namespace's pods to the densities of the nodes they run on
+	for _, node := range nodes {
+		node.hasActual = metricsData != nil
+	}
+	mesh.densities.set(namespace, nodes)
+
+	// Create namespace info, only including the actual resource usage if metrics were gathered
+	hasActual := metricsData != nil
 	nsInfo := &models.NamespaceInfo{
-		Pods: len(pods.Items),
+		Pods: includedPods,
 		// the namespace had istio injected if it was either enabled on the namespace-level OR within any of its pods
-		IsIstioInjected: isIstioInjected,
-		Resources: models.ResourceInfo{
-			Regular: models.ContainerResources{
-				Containers: regularContainers,
-				Request: models.Resources{
-					CPU:      regularCpuRequest,
-					MemoryGB: regularMemRequest,
-				},
-			},
-		},
+		IsIstioInjected:     isIstioInjected,
+		IsAmbientEnrolled:   isAmbientEnrolled,
+		AmbientPods:         ambientPods,
+		PodsNeedingRestart:  podsNeedingRestart,
+		UnmatchedPodMetrics: unmatchedPodMetrics,
+		Resources:           totals.toResourceInfo(hasActual, isIstioInjected),
 	}
 
-	if metricsData != nil {
-		nsInfo.Resources.Regular.Actual = &models.Resources{
-			CPU:      regularCpuActual,
-			MemoryGB: regularMemActual,
+	// Add the historical usage of each type of container next to its actual usage
+	if mesh.prometheus != nil {
+		history, err := historicalUsage(ctx, mesh.prometheus, namespace, podContainers)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			logging.Warn("Failed to get historical usage for namespace %s: %v", namespace, err)
+		}
+		if history != nil {
+			for usageType, usage := range history.types {
+				if resources := containerResourcesOf(&nsInfo.Resources, usageType); resources != nil {
+					resources.Historical = usage
+				}
+			}
+			nsInfo.Resources.UnattributedHistorical = history.unattributed
+		}
+	}
+
+	// Add the distribution of the sampled usage of each type of container next to its average usage
+	if mesh.samples != nil {
+		for usageType, stats := range mesh.samples.podStats(namespace, podContainers) {
+			if resources := containerResourcesOf(&nsInfo.Resources, usageType); resources != nil {
+				resources.Sampled = stats
+			}
+		}
+	}
+
+	if len(injectedRevisions) > 0 {
+		nsInfo.InjectedRevisions = injectedRevisions
+	}
+
+	if istioConfig := mesh.istioConfig[namespace]; len(istioConfig) > 0 {
+		nsInfo.IstioConfig = istioConfig
+	}
+
+	// Only evaluate the scope of the sidecars' configuration if the namespace has sidecars or Sidecar resources
+	if isIstioInjected || len(mesh.sidecars[namespace]) > 0 {
+		nsInfo.SidecarScope = sidecarScope(namespace, mesh.rootNamespace, mesh.sidecars)
+	}
+	if mesh.peerAuthentications != nil {
+		nsInfo.MTLS = mtlsMode(namespace, mesh.rootNamespace, mesh.peerAuthentications)
+	}
+	if mesh.envoy != nil && len(sidecarPods) > 0 {
+		nsInfo.EnvoyStats = mesh.envoy.namespaceStats(ctx, namespace, sidecarPods)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
 	}
 
-	// Only add the Istio resources field if the namespace contained at least one pod with istio injection
-	if nsInfo.IsIstioInjected {
-		nsInfo.Resources.Istio = &models.ContainerResources{
-			Containers: istioContainers,
-			Request: models.Resources{
-				CPU:      istioCpuRequest,
-				MemoryGB: istioMemRequest,
-			},
+	if nsInfo.IsIstioInjected && totals.istio.containers > 0 {
+		nsInfo.SidecarProfiles = sidecarProfiles
+	}
+
+	if len(injectionReasons) > 0 {
+		nsInfo.InjectionReasons = injectionReasons
+	}
+
+	if *nonRunningPods != (models.PodPhaseCounts{}) {
+		nsInfo.NonRunningPods = nonRunningPods
+	}
+
+	if len(gatewayReplicas) > 0 {
+		nsInfo.Gateways = &models.GatewayCounts{Count: len(gatewayReplicas)}
+		for _, replicas := range gatewayReplicas {
+			nsInfo.Gateways.Replicas += replicas
 		}
+	}
 
-		if metricsData != nil {
-			nsInfo.Resources.Istio.Actual = &models.Resources{
-				CPU:      istioCpuActual,
-				MemoryGB: istioMemActual,
+	if len(workloads) > 0 {
+		nsInfo.Workloads = make(map[string]*models.WorkloadInfo, len(workloads))
+		for key, workload := range workloads {
+			name := key.name
+			if cfg.ObfuscateNames {
+				name = ObfuscateName(name)
+			}
+			nsInfo.Workloads[key.kind+"/"+name] = &models.WorkloadInfo{
+				Kind:              key.kind,
+				Replicas:          workload.replicas,
+				IsIstioInjected:   workload.isIstioInjected,
+				IsAmbientEnrolled: workload.isAmbientEnrolled,
+				Resources:         workload.totals.toResourceInfo(hasActual, workload.isIstioInjected),
 			}
 		}
 	}
 	return nsInfo, nil
 }
 
+// sidecarScope evaluates the scope of the configuration received by a namespace's sidecars. The namespace's default Sidecar
+// (the first without a workload selector) applies to every workload without a Sidecar of its own, falling back to the mesh-wide
+// default Sidecar in the root namespace. Without a default Sidecar scoping egress, sidecars receive the configuration of the whole mesh.
+func sidecarScope(namespace, rootNamespace string, sidecars map[string][]utils.Sidecar) *models.SidecarScope {
+	scope := &models.SidecarScope{}
+
+	var defaultSidecar *utils.Sidecar
+	for i, sidecar := range sidecars[namespace] {
+		scope.Resources++
+		if sidecar.HasWorkloadSelector {
+			scope.WorkloadResources++
+		} else if defaultSidecar == nil {
+			defaultSidecar = &sidecars[namespace][i]
+			scope.Default = models.SidecarScopeNamespace
+		}
+	}
+	if defaultSidecar == nil {
+		for i, sidecar := range sidecars[rootNamespace] {
+			if !sidecar.HasWorkloadSelector {
+				defaultSidecar = &sidecars[rootNamespace][i]
+				scope.Default = models.SidecarScopeMesh
+				break
+			}
+		}
+	}
+
+	if defaultSidecar != nil {
+		scope.EgressScoped = defaultSidecar.ScopesEgress()
+		scope.EgressHosts = len(defaultSidecar.EgressHosts)
+	}
+	scope.FullMeshConfig = !scope.EgressScoped
+	return scope
+}
+
+// mtlsMode resolves the mTLS mode of a namespace. The oldest namespace-wide PeerAuthentication of the namespace takes precedence
+// over the mesh-wide one in the root namespace, and PERMISSIVE is used if neither sets a mode.
+// Workload-level policies and their port overrides are counted, but don't change the namespace's mode.
+func mtlsMode(namespace, rootNamespace string, policies map[string][]utils.PeerAuthentication) *models.MTLSInfo {
+	mtls := &models.MTLSInfo{}
+	for _, policy := range policies[rootNamespace] {
+		if !policy.HasSelector {
+			mtls.MeshMode = policy.Mode
+			break
+		}
+	}
+
+	namespaceWide := false
+	for _, policy := range policies[namespace] {
+		if policy.HasSelector {
+			mtls.WorkloadPolicies++
+			mtls.PortOverrides += policy.PortOverrides
+		} else if !namespaceWide {
+			namespaceWide = true
+			mtls.NamespaceMode = policy.Mode
+		}
+	}
+
+	switch {
+	case mtls.NamespaceMode != "":
+		mtls.Mode = mtls.NamespaceMode
+	case mtls.MeshMode != "":
+		mtls.Mode = mtls.MeshMode
+	default:
+		mtls.Mode = utils.MTLSModePermissive
+	}
+	return mtls
+}
+
+// countPodPhase counts a pod which isn't running by its phase, treating unrecognized phases as unknown
+func countPodPhase(counts *models.PodPhaseCounts, phase corev1.PodPhase) {
+	switch phase {
+	case corev1.PodPending:
+		counts.Pending++
+	case corev1.PodSucceeded:
+		counts.Succeeded++
+	case corev1.PodFailed:
+		counts.Failed++
+	default:
+		counts.Unknown++
+	}
+}
+
+// sidecarVersion returns the version of a pod's istio-proxy container from its image tag, or an empty string if it has none
+func sidecarVersion(pod *corev1.Pod) string {
+	for _, container := range podLongRunningContainers(pod) {
+		if container.Name == "istio-proxy" {
+			return utils.ImageVersion(container.Image)
+		}
+	}
+	return ""
+}
+
+// isNativeSidecar returns true if the init container runs for the lifetime of the pod (restartPolicy: Always)
+func isNativeSidecar(container corev1.Container) bool {
+	return container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways
+}
+
+// podLongRunningContainers returns the containers which run for the lifetime of the pod: native sidecars and regular containers
+func podLongRunningContainers(pod *corev1.Pod) []corev1.Container {
+	containers := make([]corev1.Container, 0, len(pod.Spec.InitContainers)+len(pod.Spec.Containers))
+	for _, container := range pod.Spec.InitContainers {
+		if isNativeSidecar(container) {
+			containers = append(containers, container)
+		}
+	}
+	return append(containers, pod.Spec.Containers...)
+}
+
+// resourcesFromList converts the CPU and memory of a resource list to the output model, treating missing resources as zero
+func resourcesFromList(list corev1.ResourceList) models.Resources {
+	var resources models.Resources
+	if cpu, ok := list[corev1.ResourceCPU]; ok {
+		resources.CPU = cpu.AsApproximateFloat64()
+	}
+	if mem, ok := list[corev1.ResourceMemory]; ok {
+		resources.MemoryGB = float64(mem.Value()) / (1024 * 1024 * 1024)
+	}
+	return resources
+}
+
+// countSidecarProfile counts a sidecar as using the default proxy resources, or custom resources if any of the resource annotations are set,
+// along with whether it overrides its proxy configuration
+func countSidecarProfile(profiles *models.SidecarProfiles, podAnnotations map[string]string) {
+	custom := false
+	for _, annotation := range utils.ProxyResourceAnnotations {
+		if _, ok := podAnnotations[annotation]; !ok {
+			continue
+		}
+		custom = true
+		if profiles.Overrides == nil {
+			profiles.Overrides = make(map[string]int)
+		}
+		profiles.Overrides[annotation]++
+	}
+
+	if custom {
+		profiles.Custom++
+	} else {
+		profiles.Default++
+	}
+
+	if _, ok := podAnnotations[utils.ProxyConfigAnnotation]; ok {
+		profiles.ProxyConfigOverrides++
+	}
+}
+
+// proxyContainerType returns the type of container of a pod running a standalone proxy (ztunnel, waypoint or gateway),
+// and false if the pod is a regular workload
+func proxyContainerType(pod *corev1.Pod) (containerType, bool) {
+	switch {
+	case utils.IsZtunnelPod(pod):
+		return ztunnelContainer, true
+	case utils.IsWaypointPod(pod.Labels):
+		return waypointContainer, true
+	case utils.IsGatewayPod(pod.Labels):
+		return gatewayContainer, true
+	}
+	return regularContainer, false
+}
+
+// containerType is the group a container's resources are reported under
+type containerType int
+
+const (
+	regularContainer containerType = iota
+	istioContainer
+	initContainer
+	ztunnelContainer
+	waypointContainer
+	gatewayContainer
+)
+
+// resourceTotals accumulates the resources of each type of container, for either a namespace or a workload
+type resourceTotals struct {
+	regular  containerTotals
+	istio    containerTotals
+	init     containerTotals
+	ztunnel  containerTotals
+	waypoint containerTotals
+	gateway  containerTotals
+}
+
+// get returns the totals for a type of container
+func (t *resourceTotals) get(containerType containerType) *containerTotals {
+	switch containerType {
+	case istioContainer:
+		return &t.istio
+	case initContainer:
+		return &t.init
+	case ztunnelContainer:
+		return &t.ztunnel
+	case waypointContainer:
+		return &t.waypoint
+	case gatewayContainer:
+		return &t.gateway
+	default:
+		return &t.regular
+	}
+}
+
+// addContainer adds a container's resources to the totals of its type for each of the targets
+func addContainer(targets []*resourceTotals, containerType containerType, resources corev1.ResourceRequirements) {
+	for _, target := range targets {
+		target.get(containerType).addContainer(resources)
+	}
+}
+
+// toResourceInfo converts the totals to the output model, only including the groups which apply
+func (t *resourceTotals) toResourceInfo(hasActual, isIstioInjected bool) models.ResourceInfo {
+	info := models.ResourceInfo{
+		Regular: *t.regular.toContainerResources(hasActual),
+	}
+
+	// Only add the Istio resources field if there was at least one pod with istio injection
+	if isIstioInjected {
+		info.Istio = t.istio.toContainerResources(hasActual)
+	}
+
+	// Only add the init container resources if there was at least one classic init container.
+	// Init containers have completed by the time metrics are gathered, so there is no actual usage to report.
+	if t.init.containers > 0 {
+		info.Init = t.init.toContainerResources(false)
+	}
+
+	// Only add the ambient data plane resources if there were ztunnel or waypoint pods
+	if t.ztunnel.containers > 0 {
+		info.Ztunnel = t.ztunnel.toContainerResources(hasActual)
+	}
+	if t.waypoint.containers > 0 {
+		info.Waypoint = t.waypoint.toContainerResources(hasActual)
+	}
+
+	// Only add the gateway resources if there were ingress or egress gateway pods
+	if t.gateway.containers > 0 {
+		info.Gateway = t.gateway.toContainerResources(hasActual)
+	}
+	return info
+}
+
+// containerTotals accumulates the number of containers and their resources for a group of containers
+type containerTotals struct {
+	containers      int
+	request         models.Resources
+	limit           models.Resources
+	actual          models.Resources
+	missingRequests int
+	missingLimits   int
+}
+
+// addContainer counts a container and adds its resource requests and limits to the totals
+func (t *containerTotals) addContainer(resources corev1.ResourceRequirements) {
+	t.containers++
+
+	request := resourcesFromList(resources.Requests)
+	t.request.CPU += request.CPU
+	t.request.MemoryGB += request.MemoryGB
+	if !hasCPUAndMemory(resources.Requests) {
+		t.missingRequests++
+	}
+
+	limit := resourcesFromList(resources.Limits)
+	t.limit.CPU += limit.CPU
+	t.limit.MemoryGB += limit.MemoryGB
+	if !hasCPUAndMemory(resources.Limits) {
+		t.missingLimits++
+	}
+}
+
+// hasCPUAndMemory checks if a resource list sets both CPU and memory
+func hasCPUAndMemory(list corev1.ResourceList) bool {
+	_, hasCPU := list[corev1.ResourceCPU]
+	_, hasMemory := list[corev1.ResourceMemory]
+	return hasCPU && hasMemory
+}
+
+// addUsage adds a container's actual usage (from the metrics API) to the totals
+func (t *containerTotals) addUsage(usage corev1.ResourceList) {
+	t.actual.CPU += usage.Cpu().AsApproximateFloat64()
+	t.actual.MemoryGB += float64(usage.Memory().Value()) / (1024 * 1024 * 1024)
+}
+
+// toContainerResources converts the totals to the output model, only including the actual usage if it was gathered
+func (t *containerTotals) toContainerResources(hasActual bool) *models.ContainerResources {
+	resources := &models.ContainerResources{
+		Containers:      t.containers,
+		Request:         t.request,
+		Limit:           t.limit,
+		MissingRequests: t.missingRequests,
+		MissingLimits:   t.missingLimits,
+	}
+	if hasActual {
+		actual := t.actual
+		resources.Actual = &actual
+	}
+	return resources
+}
+
 // getMetricsWithRetries gets metrics for all pods in a namespace with retry logic
 func getMetricsWithRetries(ctx context.Context, metricsClient metricsv.Interface, namespace string) (*v1beta1.PodMetricsList, error) {
 	var result *v1beta1.PodMetricsList
@@ -677,53 +1415,83 @@ func getClusterName(ctx context.Context, kubeContext string, obfuscate bool) (st
 
 // saveClusterInfo saves the cluster info to the specified format and location
 func saveClusterInfo(clusterInfo *models.ClusterInfo, outputFile string) error {
+	data, err := marshalClusterInfo(clusterInfo, outputFile)
+	if err != nil {
+		return err
+	}
+
+	// Write to file, replacing any previous file or checkpoint atomically
+	err = writeFileAtomically(outputFile, data)
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	logging.Info("Saved cluster info to file: %s", outputFile)
+	return nil
+}
+
+// marshalClusterInfo marshals the cluster info in the format of the output file's extension
+func marshalClusterInfo(clusterInfo *models.ClusterInfo, outputFile string) ([]byte, error) {
 	// Extract file extension if it exists
 	fileExt := "json"
 	if idx := strings.LastIndex(outputFile, "."); idx >= 0 {
 		fileExt = strings.ToLower(outputFile[idx+1:])
 	}
 
-	// For JSON and YAML formats
-	var data []byte
-	var err error
-
 	switch fileExt {
 	case "json":
 		// Create JSON data
-		data, err = json.MarshalIndent(clusterInfo, "", "  ")
+		data, err := json.MarshalIndent(clusterInfo, "", "  ")
 		if err != nil {
-			return fmt.Errorf("failed to marshal cluster info to JSON: %w", err)
+			return nil, fmt.Errorf("failed to marshal cluster info to JSON: %w", err)
 		}
+		return data, nil
 	case "yaml", "yml":
 		// Create YAML data
-		data, err = yaml.Marshal(clusterInfo)
+		data, err := yaml.Marshal(clusterInfo)
 		if err != nil {
-			return fmt.Errorf("failed to marshal cluster info to YAML: %w", err)
+			return nil, fmt.Errorf("failed to marshal cluster info to YAML: %w", err)
 		}
+		return data, nil
 	default:
-		return fmt.Errorf("unsupported output format: %s", fileExt)
+		return nil, fmt.Errorf("unsupported output format: %s", fileExt)
 	}
+}
 
-	// Ensure parent directories exist
-	dir := filepath.Dir(outputFile)
-	if dir != "" && dir != "." {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return fmt.Errorf("failed to create output directory %s: %w", dir, err)
-		}
+// writeFileAtomically writes data to a temporary file next to the target before renaming it over the target,
+// so the target is never left partially written if the process is interrupted
+func writeFileAtomically(fileName string, data []byte) error {
+	dir := filepath.Dir(fileName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
 	}
 
-	// Write to file
-	err = os.WriteFile(outputFile, data, 0644)
+	tmp, err := os.CreateTemp(dir, filepath.Base(fileName)+".tmp-*")
 	if err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+		return fmt.Errorf("failed to create temporary file: %w", err)
 	}
+	// the temporary file is left behind only if writing it failed
+	defer os.Remove(tmp.Name())
 
-	logging.Info("Saved cluster info to file: %s", outputFile)
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set permissions of temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), fileName); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", fileName, err)
+	}
 	return nil
 }
 
 // processNode processes an individual node
-func processNode(ctx context.Context, metricsClient metricsv.Interface, node corev1.Node, hasMetrics bool) (models.NodeInfo, error) {
+func processNode(ctx context.Context, metricsClient metricsv.Interface, node corev1.Node, hasMetrics bool, cfg *utils.Config) (models.NodeInfo, error) {
 	// Check if the context is cancelled
 	if ctx.Err() != nil {
 		return models.NodeInfo{}, ctx.Err()
@@ -766,6 +1534,34 @@ func processNode(ctx context.Context, metricsClient metricsv.Interface, node cor
 
 	// Create node info
 	nodeInfo := models.NewNodeInfo(instanceType, region, zone, cpuCapacity, memoryGB)
+	nodeInfo.Resources.Allocatable = models.NodeResourceSpec{
+		CPU:      node.Status.Allocatable.Cpu().AsApproximateFloat64(),
+		MemoryGB: float64(node.Status.Allocatable.Memory().Value()) / (1024 * 1024 * 1024),
+	}
+	nodeInfo.MaxPods = int(node.Status.Allocatable.Pods().Value())
+	nodeInfo.Architecture = node.Status.NodeInfo.Architecture
+	nodeInfo.OS = node.Status.NodeInfo.OperatingSystem
+	nodeInfo.KubeletVersion = node.Status.NodeInfo.KubeletVersion
+
+	for _, taint := range node.Spec.Taints {
+		value := taint.Value
+		if value != "" && cfg.ObfuscateNames {
+			value = ObfuscateName(value)
+		}
+		nodeInfo.Taints = append(nodeInfo.Taints, models.NodeTaint{Key: taint.Key, Value: value, Effect: string(taint.Effect)})
+	}
+
+	for _, poolLabel := range nodePoolLabels {
+		name, ok := labels[poolLabel.label]
+		if !ok || name == "" {
+			continue
+		}
+		if cfg.ObfuscateNames {
+			name = ObfuscateName(name)
+		}
+		nodeInfo.NodePool = &models.NodePool{Provider: poolLabel.provider, Name: name}
+		break
+	}
 
 	// Add metrics if available
 	if hasMetrics && metricsClient != nil {
@@ -786,6 +1582,19 @@ func processNode(ctx context.Context, metricsClient metricsv.Interface, node cor
 	return nodeInfo, nil
 }
 
+// nodePoolLabels are the labels identifying the managed node pool of a node, checked in order
+var nodePoolLabels = []struct {
+	label    string
+	provider string
+}{
+	{label: "eks.amazonaws.com/nodegroup", provider: "eks"},
+	{label: "cloud.google.com/gke-nodepool", provider: "gke"},
+	{label: "kubernetes.azure.com/agentpool", provider: "aks"},
+	{label: "agentpool", provider: "aks"},
+	{label: "karpenter.sh/nodepool", provider: "karpenter"},
+	{label: "karpenter.sh/provisioner-name", provider: "karpenter"},
+}
+
 // getNodeMetricsWithRetries gets metrics for a node with retry logic
 func getNodeMetricsWithRetries(ctx context.Context, metricsClient metricsv.Interface, nodeName string) (*v1beta1.NodeMetrics, error) {
 	var result *v1beta1.NodeMetrics
@@ -340,7 +340,7 @@ func BenchmarkProcessNamespaces(b *testing.B) {
 
 				clusterInfo := models.NewClusterInfo()
 
-				err := processNamespaces(ctx, fakeClient, fakeMetricsClient, clusterInfo, processCfg, hasMetricsInConfig)
+				err := processNamespaces(ctx, fakeClient, fakeMetricsClient, nil, &meshInfo{densities: newNodeDensities(processCfg.ObfuscateNames)}, &Checkpointer{}, clusterInfo, processCfg, hasMetricsInConfig)
 
 				cancel()
 
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/solo-io/istio-usage-collector/internal/utils"
 	"github.com/solo-io/istio-usage-collector/pkg/models"
 	"sigs.k8s.io/yaml"
 
@@ -16,7 +17,10 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
@@ -25,10 +29,10 @@ import (
 	metricsfake "k8s.io/metrics/pkg/client/clientset/versioned/fake"
 )
 
-func TestProcessNamespace(t *testing.T) {
-	ctx := context.Background()
+// loadDefaultIstioWebhooks loads the default webhooks that are created through `istioctl install`, without any additional configuration
+func loadDefaultIstioWebhooks(t *testing.T) []admissionregistrationv1.MutatingWebhookConfiguration {
+	t.Helper()
 
-	// these are the default webhooks that are created through `istioctl install`, without any additional configuration
 	var istioRevisionTagDefaultWebhook admissionregistrationv1.MutatingWebhookConfiguration
 	data, err := os.ReadFile("../../tests/data/default-istio-revision-tag-mwh.yaml")
 	require.NoError(t, err)
@@ -41,7 +45,13 @@ func TestProcessNamespace(t *testing.T) {
 	err = yaml.Unmarshal(data, &istioSidecarInjectorWebhook)
 	require.NoError(t, err)
 
-	defaultIstioMutatingWebhooks := []admissionregistrationv1.MutatingWebhookConfiguration{istioRevisionTagDefaultWebhook, istioSidecarInjectorWebhook}
+	return []admissionregistrationv1.MutatingWebhookConfiguration{istioRevisionTagDefaultWebhook, istioSidecarInjectorWebhook}
+}
+
+func TestProcessNamespace(t *testing.T) {
+	ctx := context.Background()
+
+	defaultIstioMutatingWebhooks := loadDefaultIstioWebhooks(t)
 
 	tests := []struct {
 		name           string
@@ -49,8 +59,10 @@ func TestProcessNamespace(t *testing.T) {
 		kubeObjects    []runtime.Object // Namespaces, Pods
 		metricsObjects []runtime.Object // PodMetrics
 		hasMetricsAPI  bool
-		expectedNsInfo *models.NamespaceInfo
-		expectError    bool
+		// injectorConfigs are the sidecar injector configurations keyed by revision, nil uses the default injection policy
+		injectorConfigs map[string]*utils.InjectorConfig
+		expectedNsInfo  *models.NamespaceInfo
+		expectError     bool
 	}{
 		{
 			name:      "Namespace without istio injection, no pods, metrics enabled",
@@ -88,8 +100,10 @@ func TestProcessNamespace(t *testing.T) {
 			},
 			hasMetricsAPI: true,
 			expectedNsInfo: &models.NamespaceInfo{
-				Pods:            2,
-				IsIstioInjected: true,
+				Pods:             2,
+				IsIstioInjected:  true,
+				InjectionReasons: map[string]int{"policy-enabled": 2},
+				SidecarProfiles:  &models.SidecarProfiles{Default: 2},
 				Resources: models.ResourceInfo{
 					Regular: models.ContainerResources{
 						Containers: 2,                                                              // pod-1 app + pod-2 app
@@ -116,8 +130,10 @@ func TestProcessNamespace(t *testing.T) {
 			metricsObjects: []runtime.Object{}, // No metrics objects available
 			hasMetricsAPI:  false,
 			expectedNsInfo: &models.NamespaceInfo{
-				Pods:            1,
-				IsIstioInjected: true,
+				Pods:             1,
+				IsIstioInjected:  true,
+				InjectionReasons: map[string]int{"policy-enabled": 1},
+				SidecarProfiles:  &models.SidecarProfiles{Default: 1},
 				Resources: models.ResourceInfo{
 					Regular: models.ContainerResources{
 						Containers: 1,
@@ -190,8 +206,9 @@ func TestProcessNamespace(t *testing.T) {
 			},
 			hasMetricsAPI: true,
 			expectedNsInfo: &models.NamespaceInfo{
-				Pods:            1,
-				IsIstioInjected: false,
+				Pods:             1,
+				IsIstioInjected:  false,
+				InjectionReasons: map[string]int{"no-webhook-match": 1},
 				Resources: models.ResourceInfo{
 					Regular: models.ContainerResources{
 						Containers: 1,                                                      // pod-1 app
@@ -214,8 +231,9 @@ func TestProcessNamespace(t *testing.T) {
 			},
 			hasMetricsAPI: true,
 			expectedNsInfo: &models.NamespaceInfo{
-				Pods:            1,
-				IsIstioInjected: false,
+				Pods:             1,
+				IsIstioInjected:  false,
+				InjectionReasons: map[string]int{"no-webhook-match": 1},
 				Resources: models.ResourceInfo{
 					Regular: models.ContainerResources{
 						Containers: 1,                                                      // pod-1 app
@@ -236,8 +254,10 @@ func TestProcessNamespace(t *testing.T) {
 			metricsObjects: []runtime.Object{},
 			hasMetricsAPI:  false,
 			expectedNsInfo: &models.NamespaceInfo{
-				Pods:            1,
-				IsIstioInjected: true, // should be true because while the namespace does not have istio injection enabled, a pod within the namespace has istio injection enabled
+				Pods:             1,
+				IsIstioInjected:  true, // should be true because while the namespace does not have istio injection enabled, a pod within the namespace has istio injection enabled
+				InjectionReasons: map[string]int{"inject-label-enabled": 1},
+				SidecarProfiles:  &models.SidecarProfiles{Default: 1},
 				Resources: models.ResourceInfo{
 					Regular: models.ContainerResources{
 						Containers: 1,
@@ -263,8 +283,10 @@ func TestProcessNamespace(t *testing.T) {
 			metricsObjects: []runtime.Object{},
 			hasMetricsAPI:  false,
 			expectedNsInfo: &models.NamespaceInfo{
-				Pods:            1,
-				IsIstioInjected: true, // should be true because while the namespace does not have istio injection enabled, a pod within the namespace has istio injection enabled
+				Pods:             1,
+				IsIstioInjected:  true, // should be true because while the namespace does not have istio injection enabled, a pod within the namespace has istio injection enabled
+				InjectionReasons: map[string]int{"policy-enabled": 1},
+				SidecarProfiles:  &models.SidecarProfiles{Default: 1},
 				Resources: models.ResourceInfo{
 					Regular: models.ContainerResources{
 						Containers: 1,
@@ -291,8 +313,9 @@ func TestProcessNamespace(t *testing.T) {
 			metricsObjects: []runtime.Object{},
 			hasMetricsAPI:  false,
 			expectedNsInfo: &models.NamespaceInfo{
-				Pods:            1,
-				IsIstioInjected: false, // should be false because the namespace has istio injection explicitly disabled
+				Pods:             1,
+				IsIstioInjected:  false, // should be false because the namespace has istio injection explicitly disabled
+				InjectionReasons: map[string]int{"no-webhook-match": 1},
 				Resources: models.ResourceInfo{
 					Regular: models.ContainerResources{
 						Containers: 1,
@@ -315,8 +338,9 @@ func TestProcessNamespace(t *testing.T) {
 			metricsObjects: []runtime.Object{},
 			hasMetricsAPI:  false,
 			expectedNsInfo: &models.NamespaceInfo{
-				Pods:            1,
-				IsIstioInjected: false,
+				Pods:             1,
+				IsIstioInjected:  false,
+				InjectionReasons: map[string]int{"no-webhook-match": 1},
 				Resources: models.ResourceInfo{
 					Regular: models.ContainerResources{
 						Containers: 2, // the pod has 2 'regular' containers, the app and the istio-proxy
@@ -328,6 +352,286 @@ func TestProcessNamespace(t *testing.T) {
 			},
 			expectError: false,
 		},
+		{
+			// istio-proxy runs as a native sidecar (ENABLE_NATIVE_SIDECARS), with the classic istio-init container tracked separately
+			name:      "Namespace with istio injection label, pod with native sidecar istio-proxy, metrics enabled",
+			namespace: "test-native",
+			kubeObjects: []runtime.Object{
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-native", Labels: map[string]string{"istio-injection": "enabled"}}},
+				testutils.AddInitContainer(
+					testutils.AddInitContainer(testutils.NewPod("test-native", "pod-1", "node-a", "200m", "256Mi", false, "", "", map[string]string{}), "istio-init", "100m", "128Mi", false),
+					"istio-proxy", "100m", "128Mi", true,
+				),
+			},
+			metricsObjects: []runtime.Object{
+				testutils.NewPodMetrics("test-native", "pod-1", "150m", "180Mi", true, "50m", "64Mi"),
+			},
+			hasMetricsAPI: true,
+			expectedNsInfo: &models.NamespaceInfo{
+				Pods:             1,
+				IsIstioInjected:  true,
+				InjectionReasons: map[string]int{"policy-enabled": 1},
+				SidecarProfiles:  &models.SidecarProfiles{Default: 1},
+				Resources: models.ResourceInfo{
+					Regular: models.ContainerResources{
+						Containers: 1,
+						Request:    models.Resources{CPU: 0.2, MemoryGB: 256.0 / 1024.0},
+						Actual:     &models.Resources{CPU: 0.15, MemoryGB: 180.0 / 1024.0},
+					},
+					Istio: &models.ContainerResources{
+						Containers: 1,
+						Request:    models.Resources{CPU: 0.1, MemoryGB: 128.0 / 1024.0},
+						Actual:     &models.Resources{CPU: 0.05, MemoryGB: 64.0 / 1024.0},
+					},
+					Init: &models.ContainerResources{
+						Containers:    1, // istio-init
+						MissingLimits: 1,
+						Request:       models.Resources{CPU: 0.1, MemoryGB: 128.0 / 1024.0},
+						Actual:        nil, // init containers have completed, so there is no usage reported
+					},
+				},
+			},
+			expectError: false,
+		},
+		{
+			// native sidecars which aren't istio-proxy are long-running, so they are counted as regular containers
+			name:      "Namespace without istio injection, pod with non-istio native sidecar and init container, no metrics",
+			namespace: "test-native",
+			kubeObjects: []runtime.Object{
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-native"}},
+				testutils.AddInitContainer(
+					testutils.AddInitContainer(testutils.NewPod("test-native", "pod-1", "node-a", "200m", "256Mi", false, "", "", map[string]string{}), "migrations", "500m", "512Mi", false),
+					"log-shipper", "50m", "64Mi", true,
+				),
+			},
+			metricsObjects: []runtime.Object{},
+			hasMetricsAPI:  false,
+			expectedNsInfo: &models.NamespaceInfo{
+				Pods:             1,
+				IsIstioInjected:  false,
+				InjectionReasons: map[string]int{"no-webhook-match": 1},
+				Resources: models.ResourceInfo{
+					Regular: models.ContainerResources{
+						Containers: 2, // the app and the log-shipper native sidecar
+						Request:    models.Resources{CPU: 0.25, MemoryGB: (256.0 + 64.0) / 1024.0},
+						Actual:     nil,
+					},
+					Istio: nil,
+					Init: &models.ContainerResources{
+						Containers:    1, // migrations
+						MissingLimits: 1,
+						Request:       models.Resources{CPU: 0.5, MemoryGB: 512.0 / 1024.0},
+						Actual:        nil,
+					},
+				},
+			},
+			expectError: false,
+		},
+		{
+			name:      "Namespace with ambient label, pods with and without opt-out, no metrics",
+			namespace: "test-ambient",
+			kubeObjects: []runtime.Object{
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-ambient", Labels: map[string]string{"istio.io/dataplane-mode": "ambient"}}},
+				testutils.NewPod("test-ambient", "pod-1", "node-a", "200m", "256Mi", false, "", "", map[string]string{}),
+				testutils.NewPod("test-ambient", "pod-2", "node-a", "100m", "64Mi", false, "", "", map[string]string{"istio.io/dataplane-mode": "none"}),
+			},
+			metricsObjects: []runtime.Object{},
+			hasMetricsAPI:  false,
+			expectedNsInfo: &models.NamespaceInfo{
+				Pods:              2,
+				IsIstioInjected:   false,
+				InjectionReasons:  map[string]int{"no-webhook-match": 2},
+				IsAmbientEnrolled: true,
+				AmbientPods:       1, // pod-2 opted out of ambient mode
+				Resources: models.ResourceInfo{
+					Regular: models.ContainerResources{
+						Containers: 2,
+						Request:    models.Resources{CPU: 0.3, MemoryGB: (256.0 + 64.0) / 1024.0},
+						Actual:     nil,
+					},
+					Istio: nil,
+				},
+			},
+			expectError: false,
+		},
+		{
+			name:      "Namespace with ztunnel and waypoint pods, metrics enabled",
+			namespace: "istio-system",
+			kubeObjects: []runtime.Object{
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "istio-system"}},
+				func() *corev1.Pod {
+					pod := testutils.NewPod("istio-system", "ztunnel-abcde", "node-a", "200m", "512Mi", false, "", "", map[string]string{"app": "ztunnel"})
+					testutils.SetController(pod, "apps/v1", "DaemonSet", "ztunnel")
+					return pod
+				}(),
+				testutils.NewPod("istio-system", "ztunnel-fghij", "node-b", "200m", "512Mi", false, "", "", map[string]string{"app": "ztunnel", "app.kubernetes.io/name": "ztunnel"}),
+				// an unrelated workload which happens to be labelled app=ztunnel is a regular workload
+				func() *corev1.Pod {
+					pod := testutils.NewPod("istio-system", "lookalike-12345", "node-a", "100m", "64Mi", false, "", "", map[string]string{"app": "ztunnel"})
+					testutils.SetController(pod, "apps/v1", "ReplicaSet", "lookalike-6d4f8")
+					return pod
+				}(),
+				testutils.NewPod("istio-system", "waypoint-12345", "node-a", "100m", "128Mi", false, "", "", map[string]string{"gateway.istio.io/managed": "istio.io-mesh-controller"}),
+			},
+			metricsObjects: []runtime.Object{
+				testutils.NewPodMetrics("istio-system", "ztunnel-abcde", "20m", "64Mi", false, "", ""),
+				testutils.NewPodMetrics("istio-system", "waypoint-12345", "10m", "32Mi", false, "", ""),
+			},
+			hasMetricsAPI: true,
+			expectedNsInfo: &models.NamespaceInfo{
+				Pods:             4,
+				IsIstioInjected:  false,
+				InjectionReasons: map[string]int{"no-webhook-match": 1},
+				Resources: models.ResourceInfo{
+					Regular: models.ContainerResources{
+						Containers: 1,
+						Request:    models.Resources{CPU: 0.1, MemoryGB: 64.0 / 1024.0},
+						Actual:     &models.Resources{CPU: 0, MemoryGB: 0},
+					},
+					Istio: nil,
+					Ztunnel: &models.ContainerResources{
+						Containers:    2,
+						MissingLimits: 2,
+						Request:       models.Resources{CPU: 0.4, MemoryGB: 1024.0 / 1024.0},
+						Actual:        &models.Resources{CPU: 0.02, MemoryGB: 64.0 / 1024.0},
+					},
+					Waypoint: &models.ContainerResources{
+						Containers:    1,
+						MissingLimits: 1,
+						Request:       models.Resources{CPU: 0.1, MemoryGB: 128.0 / 1024.0},
+						Actual:        &models.Resources{CPU: 0.01, MemoryGB: 32.0 / 1024.0},
+					},
+				},
+			},
+			expectError: false,
+		},
+		{
+			name:      "Namespace with istio injection label, pods opted out through annotation and host network, no metrics",
+			namespace: "test-istio",
+			kubeObjects: []runtime.Object{
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-istio", Labels: map[string]string{"istio-injection": "enabled"}}},
+				func() *corev1.Pod {
+					pod := testutils.NewPod("test-istio", "pod-1", "node-a", "200m", "256Mi", false, "", "", map[string]string{})
+					pod.Annotations = map[string]string{"sidecar.istio.io/inject": "false"}
+					return pod
+				}(),
+				func() *corev1.Pod {
+					pod := testutils.NewPod("test-istio", "pod-2", "node-a", "100m", "64Mi", false, "", "", map[string]string{})
+					pod.Spec.HostNetwork = true
+					return pod
+				}(),
+			},
+			metricsObjects: []runtime.Object{},
+			hasMetricsAPI:  false,
+			expectedNsInfo: &models.NamespaceInfo{
+				Pods:             2,
+				IsIstioInjected:  false,
+				InjectionReasons: map[string]int{"inject-annotation-disabled": 1, "host-network": 1},
+				Resources: models.ResourceInfo{
+					Regular: models.ContainerResources{
+						Containers: 2,
+						Request:    models.Resources{CPU: 0.3, MemoryGB: (256.0 + 64.0) / 1024.0},
+						Actual:     nil,
+					},
+					Istio: nil,
+				},
+			},
+			expectError: false,
+		},
+		{
+			name:      "Namespace with istio injection label, injector config with disabled policy and inject selectors, no metrics",
+			namespace: "test-istio",
+			kubeObjects: []runtime.Object{
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-istio", Labels: map[string]string{"istio-injection": "enabled"}}},
+				testutils.NewPod("test-istio", "pod-legacy", "node-a", "200m", "256Mi", false, "", "", map[string]string{"tier": "legacy"}),
+				testutils.NewPod("test-istio", "pod-mesh", "node-a", "200m", "256Mi", true, "100m", "128Mi", map[string]string{"tier": "mesh"}),
+				testutils.NewPod("test-istio", "pod-other", "node-a", "200m", "256Mi", false, "", "", map[string]string{}),
+			},
+			metricsObjects: []runtime.Object{},
+			hasMetricsAPI:  false,
+			injectorConfigs: map[string]*utils.InjectorConfig{
+				"default": {
+					Policy:               "disabled",
+					NeverInjectSelector:  []metav1.LabelSelector{{MatchLabels: map[string]string{"tier": "legacy"}}},
+					AlwaysInjectSelector: []metav1.LabelSelector{{MatchLabels: map[string]string{"tier": "mesh"}}},
+				},
+			},
+			expectedNsInfo: &models.NamespaceInfo{
+				Pods:             3,
+				IsIstioInjected:  true,
+				InjectionReasons: map[string]int{"never-inject-selector": 1, "always-inject-selector": 1, "policy-disabled": 1},
+				SidecarProfiles:  &models.SidecarProfiles{Default: 1},
+				Resources: models.ResourceInfo{
+					Regular: models.ContainerResources{
+						Containers: 3,
+						Request:    models.Resources{CPU: 0.6, MemoryGB: 3 * 256.0 / 1024.0},
+						Actual:     nil,
+					},
+					Istio: &models.ContainerResources{
+						Containers: 1,
+						Request:    models.Resources{CPU: 0.1, MemoryGB: 128.0 / 1024.0},
+						Actual:     nil,
+					},
+				},
+			},
+			expectError: false,
+		},
+		{
+			name:      "Namespace with istio injection label, sidecars with resource annotations, no metrics",
+			namespace: "test-istio",
+			kubeObjects: []runtime.Object{
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-istio", Labels: map[string]string{"istio-injection": "enabled"}}},
+				testutils.NewPod("test-istio", "pod-default", "node-a", "100m", "128Mi", true, "100m", "128Mi", map[string]string{}),
+				func() *corev1.Pod {
+					pod := testutils.NewPod("test-istio", "pod-tuned", "node-a", "100m", "128Mi", true, "500m", "512Mi", map[string]string{})
+					pod.Annotations = map[string]string{
+						"sidecar.istio.io/proxyCPU":         "500m",
+						"sidecar.istio.io/proxyMemory":      "512Mi",
+						"sidecar.istio.io/proxyMemoryLimit": "1Gi",
+					}
+					return pod
+				}(),
+				func() *corev1.Pod {
+					pod := testutils.NewPod("test-istio", "pod-cpu-limit", "node-a", "100m", "128Mi", true, "100m", "128Mi", map[string]string{})
+					pod.Annotations = map[string]string{
+						"sidecar.istio.io/proxyCPULimit": "2",
+						"proxy.istio.io/config":          "concurrency: 2",
+					}
+					return pod
+				}(),
+			},
+			metricsObjects: []runtime.Object{},
+			hasMetricsAPI:  false,
+			expectedNsInfo: &models.NamespaceInfo{
+				Pods:             3,
+				IsIstioInjected:  true,
+				InjectionReasons: map[string]int{"policy-enabled": 3},
+				SidecarProfiles: &models.SidecarProfiles{
+					Default: 1,
+					Custom:  2,
+					Overrides: map[string]int{
+						"sidecar.istio.io/proxyCPU":         1,
+						"sidecar.istio.io/proxyMemory":      1,
+						"sidecar.istio.io/proxyCPULimit":    1,
+						"sidecar.istio.io/proxyMemoryLimit": 1,
+					},
+					ProxyConfigOverrides: 1,
+				},
+				Resources: models.ResourceInfo{
+					Regular: models.ContainerResources{
+						Containers: 3,
+						Request:    models.Resources{CPU: 0.3, MemoryGB: 3 * 128.0 / 1024.0},
+						Actual:     nil,
+					},
+					Istio: &models.ContainerResources{
+						Containers: 3,
+						Request:    models.Resources{CPU: 0.7, MemoryGB: (128.0 + 512.0 + 128.0) / 1024.0},
+						Actual:     nil,
+					},
+				},
+			},
+			expectError: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -350,7 +654,8 @@ func TestProcessNamespace(t *testing.T) {
 				return true, podMetricsList, nil
 			})
 
-			nsInfo, err := processNamespace(ctx, fakeClient, fakeMetricsClient, tt.namespace, tt.hasMetricsAPI, defaultIstioMutatingWebhooks)
+			mesh := &meshInfo{webhooks: defaultIstioMutatingWebhooks, injectorConfigs: tt.injectorConfigs}
+			nsInfo, err := processNamespace(ctx, fakeClient, fakeMetricsClient, tt.namespace, tt.hasMetricsAPI, mesh, &utils.Config{})
 
 			if tt.expectError {
 				assert.Error(t, err)
@@ -362,6 +667,10 @@ func TestProcessNamespace(t *testing.T) {
 
 				assert.Equal(t, tt.expectedNsInfo.Pods, nsInfo.Pods)
 				assert.Equal(t, tt.expectedNsInfo.IsIstioInjected, nsInfo.IsIstioInjected)
+				assert.Equal(t, tt.expectedNsInfo.IsAmbientEnrolled, nsInfo.IsAmbientEnrolled)
+				assert.Equal(t, tt.expectedNsInfo.AmbientPods, nsInfo.AmbientPods)
+				assert.Equal(t, tt.expectedNsInfo.InjectionReasons, nsInfo.InjectionReasons)
+				assert.Equal(t, tt.expectedNsInfo.SidecarProfiles, nsInfo.SidecarProfiles)
 
 				// Assert Regular resources
 				assert.Equal(t, tt.expectedNsInfo.Resources.Regular.Containers, nsInfo.Resources.Regular.Containers)
@@ -391,13 +700,660 @@ func TestProcessNamespace(t *testing.T) {
 				} else {
 					assert.Nil(t, nsInfo.Resources.Istio)
 				}
+
+				// Assert init container and ambient data plane resources
+				assertContainerResources(t, tt.expectedNsInfo.Resources.Init, nsInfo.Resources.Init)
+				assertContainerResources(t, tt.expectedNsInfo.Resources.Ztunnel, nsInfo.Resources.Ztunnel)
+				assertContainerResources(t, tt.expectedNsInfo.Resources.Waypoint, nsInfo.Resources.Waypoint)
+			}
+		})
+	}
+}
+
+func TestProcessNamespaceResourceLimits(t *testing.T) {
+	ctx := context.Background()
+	defaultIstioMutatingWebhooks := loadDefaultIstioWebhooks(t)
+
+	tests := []struct {
+		name            string
+		kubeObjects     []runtime.Object
+		expectedRegular *models.ContainerResources
+		expectedIstio   *models.ContainerResources
+	}{
+		{
+			name: "Containers with and without limits",
+			kubeObjects: []runtime.Object{
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-limits", Labels: map[string]string{"istio-injection": "enabled"}}},
+				testutils.SetLimits(testutils.SetLimits(
+					testutils.NewPod("test-limits", "pod-1", "node-a", "200m", "256Mi", true, "100m", "128Mi", map[string]string{}),
+					"app", "1", "512Mi"),
+					"istio-proxy", "", "256Mi"), // no CPU limit on the proxy
+				testutils.NewPod("test-limits", "pod-2", "node-a", "", "64Mi", true, "100m", "128Mi", map[string]string{}), // no CPU request or limits on the app
+			},
+			expectedRegular: &models.ContainerResources{
+				Containers:      2,
+				Request:         models.Resources{CPU: 0.2, MemoryGB: (256.0 + 64.0) / 1024.0},
+				Limit:           models.Resources{CPU: 1, MemoryGB: 512.0 / 1024.0},
+				MissingRequests: 1,
+				MissingLimits:   1,
+			},
+			expectedIstio: &models.ContainerResources{
+				Containers:      2,
+				Request:         models.Resources{CPU: 0.2, MemoryGB: 256.0 / 1024.0},
+				Limit:           models.Resources{CPU: 0, MemoryGB: 256.0 / 1024.0},
+				MissingRequests: 0,
+				MissingLimits:   2,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClient := fake.NewSimpleClientset(tt.kubeObjects...)
+			nsInfo, err := processNamespace(ctx, fakeClient, metricsfake.NewSimpleClientset(), "test-limits", false, &meshInfo{webhooks: defaultIstioMutatingWebhooks}, &utils.Config{})
+			require.NoError(t, err)
+
+			assertContainerResources(t, tt.expectedRegular, &nsInfo.Resources.Regular)
+			assertContainerResources(t, tt.expectedIstio, nsInfo.Resources.Istio)
+		})
+	}
+}
+
+func TestProcessNamespacePodPhases(t *testing.T) {
+	ctx := context.Background()
+
+	withPhase := func(pod *corev1.Pod, phase corev1.PodPhase) *corev1.Pod {
+		pod.Status.Phase = phase
+		return pod
+	}
+	kubeObjects := []runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-phases"}},
+		testutils.NewPod("test-phases", "running", "node-a", "100m", "128Mi", false, "", "", map[string]string{}),
+		withPhase(testutils.NewPod("test-phases", "pending", "", "100m", "128Mi", false, "", "", map[string]string{}), corev1.PodPending),
+		withPhase(testutils.NewPod("test-phases", "completed-job", "node-a", "100m", "128Mi", false, "", "", map[string]string{}), corev1.PodSucceeded),
+		withPhase(testutils.NewPod("test-phases", "evicted", "node-a", "100m", "128Mi", false, "", "", map[string]string{}), corev1.PodFailed),
+		withPhase(testutils.NewPod("test-phases", "failed", "node-a", "100m", "128Mi", false, "", "", map[string]string{}), corev1.PodFailed),
+		withPhase(testutils.NewPod("test-phases", "unknown", "node-a", "100m", "128Mi", false, "", "", map[string]string{}), corev1.PodUnknown),
+	}
+	expectedNonRunningPods := &models.PodPhaseCounts{Pending: 1, Succeeded: 1, Failed: 2, Unknown: 1}
+
+	tests := []struct {
+		name                  string
+		includeNonRunningPods bool
+		expectedPods          int
+		expectedRequest       models.Resources
+	}{
+		{
+			name:                  "Only running pods contribute to the totals",
+			includeNonRunningPods: false,
+			expectedPods:          1,
+			expectedRequest:       models.Resources{CPU: 0.1, MemoryGB: 128.0 / 1024.0},
+		},
+		{
+			name:                  "All pods contribute to the totals when non-running pods are included",
+			includeNonRunningPods: true,
+			expectedPods:          6,
+			expectedRequest:       models.Resources{CPU: 0.6, MemoryGB: 6 * 128.0 / 1024.0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClient := fake.NewSimpleClientset(kubeObjects...)
+			cfg := &utils.Config{IncludeNonRunningPods: tt.includeNonRunningPods}
+			nsInfo, err := processNamespace(ctx, fakeClient, metricsfake.NewSimpleClientset(), "test-phases", false, &meshInfo{}, cfg)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expectedPods, nsInfo.Pods)
+			assert.Equal(t, expectedNonRunningPods, nsInfo.NonRunningPods)
+			assert.Equal(t, tt.expectedPods, nsInfo.Resources.Regular.Containers)
+			assert.InDelta(t, tt.expectedRequest.CPU, nsInfo.Resources.Regular.Request.CPU, 0.001)
+			assert.InDelta(t, tt.expectedRequest.MemoryGB, nsInfo.Resources.Regular.Request.MemoryGB, 0.001)
+		})
+	}
+}
+
+func TestProcessNamespaceWorkloads(t *testing.T) {
+	ctx := context.Background()
+	defaultIstioMutatingWebhooks := loadDefaultIstioWebhooks(t)
+
+	withController := func(pod *corev1.Pod, apiVersion, kind, name string) *corev1.Pod {
+		testutils.SetController(pod, apiVersion, kind, name)
+		return pod
+	}
+
+	replicaSet := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "web-7d9c5", Namespace: "test-workloads"}}
+	testutils.SetController(replicaSet, "apps/v1", "Deployment", "web")
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "report-28000000", Namespace: "test-workloads"}}
+	testutils.SetController(job, "batch/v1", "CronJob", "report")
+
+	kubeObjects := []runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-workloads", Labels: map[string]string{"istio-injection": "enabled"}}},
+		replicaSet,
+		job,
+		withController(testutils.NewPod("test-workloads", "web-7d9c5-a", "node-a", "100m", "128Mi", true, "50m", "64Mi", map[string]string{}), "apps/v1", "ReplicaSet", "web-7d9c5"),
+		withController(testutils.NewPod("test-workloads", "web-7d9c5-b", "node-b", "100m", "128Mi", true, "50m", "64Mi", map[string]string{}), "apps/v1", "ReplicaSet", "web-7d9c5"),
+		withController(testutils.NewPod("test-workloads", "db-0", "node-a", "500m", "1Gi", false, "", "", map[string]string{"sidecar.istio.io/inject": "false"}), "apps/v1", "StatefulSet", "db"),
+		withController(testutils.NewPod("test-workloads", "agent-x1", "node-a", "50m", "64Mi", true, "50m", "64Mi", map[string]string{}), "apps/v1", "DaemonSet", "agent"),
+		withController(testutils.NewPod("test-workloads", "report-28000000-z", "node-b", "200m", "256Mi", false, "", "", map[string]string{"sidecar.istio.io/inject": "false"}), "batch/v1", "Job", "report-28000000"),
+		withController(testutils.NewPod("test-workloads", "orphan-rs-abc", "node-b", "100m", "128Mi", false, "", "", map[string]string{"sidecar.istio.io/inject": "false"}), "apps/v1", "ReplicaSet", "orphan-rs"),
+		testutils.NewPod("test-workloads", "debug", "node-b", "10m", "16Mi", false, "", "", map[string]string{"sidecar.istio.io/inject": "false"}),
+	}
+	podMetricsList := &v1beta1.PodMetricsList{Items: []v1beta1.PodMetrics{
+		*testutils.NewPodMetrics("test-workloads", "web-7d9c5-a", "80m", "100Mi", true, "20m", "40Mi"),
+		*testutils.NewPodMetrics("test-workloads", "web-7d9c5-b", "60m", "100Mi", true, "10m", "40Mi"),
+		*testutils.NewPodMetrics("test-workloads", "db-0", "300m", "512Mi", false, "", ""),
+	}}
+
+	t.Run("Pods are grouped by their top-level controller", func(t *testing.T) {
+		fakeClient := fake.NewSimpleClientset(kubeObjects...)
+		fakeMetricsClient := metricsfake.NewSimpleClientset()
+		fakeMetricsClient.PrependReactor("list", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+			return true, podMetricsList, nil
+		})
+		cfg := &utils.Config{IncludeWorkloads: true}
+		nsInfo, err := processNamespace(ctx, fakeClient, fakeMetricsClient, "test-workloads", true, &meshInfo{webhooks: defaultIstioMutatingWebhooks}, cfg)
+		require.NoError(t, err)
+
+		require.Len(t, nsInfo.Workloads, 6)
+		assert.ElementsMatch(t, []string{"Deployment/web", "StatefulSet/db", "DaemonSet/agent", "CronJob/report", "ReplicaSet/orphan-rs", "Pod/debug"}, mapKeys(nsInfo.Workloads))
+
+		web := nsInfo.Workloads["Deployment/web"]
+		assert.Equal(t, "Deployment", web.Kind)
+		assert.Equal(t, 2, web.Replicas)
+		assert.True(t, web.IsIstioInjected)
+		assertContainerResources(t, &models.ContainerResources{
+			Containers: 2,
+			Request:    models.Resources{CPU: 0.2, MemoryGB: 0.25},
+			Actual:     &models.Resources{CPU: 0.14, MemoryGB: 200.0 / 1024.0},
+			// the pods set no limits
+			MissingLimits: 2,
+		}, &web.Resources.Regular)
+		assertContainerResources(t, &models.ContainerResources{
+			Containers:    2,
+			Request:       models.Resources{CPU: 0.1, MemoryGB: 0.125},
+			Actual:        &models.Resources{CPU: 0.03, MemoryGB: 80.0 / 1024.0},
+			MissingLimits: 2,
+		}, web.Resources.Istio)
+
+		db := nsInfo.Workloads["StatefulSet/db"]
+		assert.Equal(t, 1, db.Replicas)
+		assert.False(t, db.IsIstioInjected)
+		assert.Nil(t, db.Resources.Istio)
+		assert.InDelta(t, 0.3, db.Resources.Regular.Actual.CPU, 0.001)
+
+		// workloads without metrics report zero actual usage, like namespaces do
+		report := nsInfo.Workloads["CronJob/report"]
+		assert.Equal(t, "CronJob", report.Kind)
+		assert.Equal(t, 1, report.Replicas)
+		require.NotNil(t, report.Resources.Regular.Actual)
+		assert.Zero(t, report.Resources.Regular.Actual.CPU)
+
+		assert.Equal(t, 1, nsInfo.Workloads["DaemonSet/agent"].Replicas)
+		assert.True(t, nsInfo.Workloads["DaemonSet/agent"].IsIstioInjected)
+		assert.Equal(t, 1, nsInfo.Workloads["ReplicaSet/orphan-rs"].Replicas)
+		assert.Equal(t, "Pod", nsInfo.Workloads["Pod/debug"].Kind)
+
+		// the namespace totals are unaffected by the breakdown
+		assert.Equal(t, 7, nsInfo.Pods)
+		assert.Equal(t, 7, nsInfo.Resources.Regular.Containers)
+		assert.Equal(t, 3, nsInfo.Resources.Istio.Containers)
+	})
+
+	t.Run("Workload names are hidden", func(t *testing.T) {
+		fakeClient := fake.NewSimpleClientset(kubeObjects...)
+		cfg := &utils.Config{IncludeWorkloads: true, ObfuscateNames: true}
+		nsInfo, err := processNamespace(ctx, fakeClient, metricsfake.NewSimpleClientset(), "test-workloads", false, &meshInfo{webhooks: defaultIstioMutatingWebhooks}, cfg)
+		require.NoError(t, err)
+
+		require.Contains(t, nsInfo.Workloads, "Deployment/"+ObfuscateName("web"))
+		assert.NotContains(t, nsInfo.Workloads, "Deployment/web")
+		assert.Equal(t, "Deployment", nsInfo.Workloads["Deployment/"+ObfuscateName("web")].Kind)
+	})
+
+	t.Run("Pods are grouped by their direct controller if replicasets can't be listed", func(t *testing.T) {
+		fakeClient := fake.NewSimpleClientset(kubeObjects...)
+		fakeClient.PrependReactor("list", "replicasets", func(action clienttesting.Action) (bool, runtime.Object, error) {
+			return true, nil, fmt.Errorf("forbidden")
+		})
+		cfg := &utils.Config{IncludeWorkloads: true}
+		nsInfo, err := processNamespace(ctx, fakeClient, metricsfake.NewSimpleClientset(), "test-workloads", false, &meshInfo{webhooks: defaultIstioMutatingWebhooks}, cfg)
+		require.NoError(t, err)
+
+		assert.Contains(t, nsInfo.Workloads, "ReplicaSet/web-7d9c5")
+		assert.Contains(t, nsInfo.Workloads, "CronJob/report")
+	})
+
+	t.Run("Workloads are not collected by default", func(t *testing.T) {
+		fakeClient := fake.NewSimpleClientset(kubeObjects...)
+		nsInfo, err := processNamespace(ctx, fakeClient, metricsfake.NewSimpleClientset(), "test-workloads", false, &meshInfo{webhooks: defaultIstioMutatingWebhooks}, &utils.Config{})
+		require.NoError(t, err)
+		assert.Nil(t, nsInfo.Workloads)
+	})
+}
+
+func TestProcessNamespaceGateways(t *testing.T) {
+	ctx := context.Background()
+	defaultIstioMutatingWebhooks := loadDefaultIstioWebhooks(t)
+
+	// gateway pods run a single istio-proxy container, and are owned by the ReplicaSet of their gateway's Deployment
+	newGatewayPod := func(namespace, deployment, name string, labels map[string]string) *corev1.Pod {
+		labels[appsv1.DefaultDeploymentUniqueLabelKey] = "5d8f9"
+		pod := testutils.NewPod(namespace, name, "node-a", "100m", "128Mi", false, "", "", labels)
+		pod.Spec.Containers[0].Name = "istio-proxy"
+		testutils.SetController(pod, "apps/v1", "ReplicaSet", deployment+"-5d8f9")
+		return pod
+	}
+
+	kubeObjects := []runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "istio-gateways", Labels: map[string]string{"istio-injection": "enabled"}}},
+		newGatewayPod("istio-gateways", "istio-ingressgateway", "istio-ingressgateway-1", map[string]string{"istio": "ingressgateway"}),
+		newGatewayPod("istio-gateways", "istio-ingressgateway", "istio-ingressgateway-2", map[string]string{"istio": "ingressgateway"}),
+		// a second ingress gateway sharing the same "istio" label is a distinct gateway
+		newGatewayPod("istio-gateways", "internal-ingressgateway", "internal-ingressgateway-1", map[string]string{"istio": "ingressgateway"}),
+		newGatewayPod("istio-gateways", "istio-egressgateway", "istio-egressgateway-1", map[string]string{"istio": "egressgateway"}),
+		newGatewayPod("istio-gateways", "public-istio", "public-istio-1", map[string]string{utils.GatewayNameLabel: "public", utils.ManagedGatewayLabel: "istio.io-gateway-controller"}),
+		newGatewayPod("istio-gateways", "waypoint", "waypoint-1", map[string]string{utils.GatewayNameLabel: "waypoint", utils.ManagedGatewayLabel: utils.ManagedGatewayMeshController}),
+		testutils.NewPod("istio-gateways", "app", "node-a", "200m", "256Mi", true, "100m", "128Mi", map[string]string{}),
+	}
+	podMetricsList := &v1beta1.PodMetricsList{Items: []v1beta1.PodMetrics{
+		*testutils.NewPodMetrics("istio-gateways", "istio-ingressgateway-1", "0", "0", true, "50m", "64Mi"),
+		*testutils.NewPodMetrics("istio-gateways", "app", "150m", "200Mi", true, "20m", "32Mi"),
+	}}
+
+	fakeClient := fake.NewSimpleClientset(kubeObjects...)
+	fakeMetricsClient := metricsfake.NewSimpleClientset()
+	fakeMetricsClient.PrependReactor("list", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, podMetricsList, nil
+	})
+	nsInfo, err := processNamespace(ctx, fakeClient, fakeMetricsClient, "istio-gateways", true, &meshInfo{webhooks: defaultIstioMutatingWebhooks}, &utils.Config{})
+	require.NoError(t, err)
+
+	// the waypoint is reported as part of the ambient data plane, not as a gateway
+	assert.Equal(t, &models.GatewayCounts{Count: 4, Replicas: 5}, nsInfo.Gateways)
+	require.NotNil(t, nsInfo.Resources.Gateway)
+	assert.Equal(t, 5, nsInfo.Resources.Gateway.Containers)
+	assert.InDelta(t, 0.5, nsInfo.Resources.Gateway.Request.CPU, 0.001)
+	require.NotNil(t, nsInfo.Resources.Gateway.Actual)
+	assert.InDelta(t, 0.05, nsInfo.Resources.Gateway.Actual.CPU, 0.001)
+	require.NotNil(t, nsInfo.Resources.Waypoint)
+	assert.Equal(t, 1, nsInfo.Resources.Waypoint.Containers)
+
+	// only the application's sidecar is counted as an istio sidecar
+	assert.Equal(t, 1, nsInfo.Resources.Istio.Containers)
+	assert.InDelta(t, 0.02, nsInfo.Resources.Istio.Actual.CPU, 0.001)
+	assert.Equal(t, 1, nsInfo.Resources.Regular.Containers)
+	assert.InDelta(t, 0.15, nsInfo.Resources.Regular.Actual.CPU, 0.001)
+	assert.Equal(t, 7, nsInfo.Pods)
+
+	t.Run("Gateways are told apart by their workload when workloads are collected", func(t *testing.T) {
+		fakeClient := fake.NewSimpleClientset(kubeObjects...)
+		nsInfo, err := processNamespace(ctx, fakeClient, metricsfake.NewSimpleClientset(), "istio-gateways", false, &meshInfo{webhooks: defaultIstioMutatingWebhooks}, &utils.Config{IncludeWorkloads: true})
+		require.NoError(t, err)
+		assert.Equal(t, &models.GatewayCounts{Count: 4, Replicas: 5}, nsInfo.Gateways)
+	})
+}
+
+func TestProcessNamespaceRevisions(t *testing.T) {
+	ctx := context.Background()
+
+	// a canary revision, injecting pods in namespaces labelled istio.io/rev=1-25
+	canaryWebhook := admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "istio-sidecar-injector-1-25", Labels: map[string]string{utils.RevisionLabel: "1-25"}},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{{
+			Name: "rev.namespace.sidecar-injector.istio.io",
+			NamespaceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: utils.RevisionLabel, Operator: metav1.LabelSelectorOpIn, Values: []string{"1-25"}},
+			}},
+			ObjectSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: utils.SidecarInjectKey, Operator: metav1.LabelSelectorOpNotIn, Values: []string{"false"}},
+			}},
+		}},
+	}
+	webhooks := append(loadDefaultIstioWebhooks(t), canaryWebhook)
+	mesh := &meshInfo{
+		webhooks:         webhooks,
+		revisionVersions: map[string]string{utils.DefaultRevision: "1.24.3", "1-25": "1.25.0"},
+	}
+
+	withProxyImage := func(pod *corev1.Pod, image string) *corev1.Pod {
+		for i := range pod.Spec.Containers {
+			if pod.Spec.Containers[i].Name == "istio-proxy" {
+				pod.Spec.Containers[i].Image = image
+			}
+		}
+		return pod
+	}
+
+	kubeObjects := []runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-canary", Labels: map[string]string{utils.RevisionLabel: "1-25"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-default", Labels: map[string]string{"istio-injection": "enabled"}}},
+		// injected before the namespace was moved to the canary revision
+		withProxyImage(testutils.NewPod("test-canary", "pod-old", "node-a", "100m", "128Mi", true, "100m", "128Mi", map[string]string{}), "docker.io/istio/proxyv2:1.24.3"),
+		withProxyImage(testutils.NewPod("test-canary", "pod-new", "node-a", "100m", "128Mi", true, "100m", "128Mi", map[string]string{}), "docker.io/istio/proxyv2:1.25.0-distroless"),
+		// the version of sidecars without a tagged image is unknown, so they aren't reported as needing a restart
+		testutils.NewPod("test-canary", "pod-untagged", "node-a", "100m", "128Mi", true, "100m", "128Mi", map[string]string{}),
+		withProxyImage(testutils.NewPod("test-default", "pod-default", "node-a", "100m", "128Mi", true, "100m", "128Mi", map[string]string{}), "docker.io/istio/proxyv2:1.24.3"),
+		testutils.NewPod("test-default", "pod-disabled", "node-a", "100m", "128Mi", false, "", "", map[string]string{utils.SidecarInjectKey: "false"}),
+	}
+	fakeClient := fake.NewSimpleClientset(kubeObjects...)
+
+	t.Run("Pods are counted by the revision injecting them", func(t *testing.T) {
+		nsInfo, err := processNamespace(ctx, fakeClient, metricsfake.NewSimpleClientset(), "test-canary", false, mesh, &utils.Config{})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{"1-25": 3}, nsInfo.InjectedRevisions)
+		assert.Equal(t, 1, nsInfo.PodsNeedingRestart)
+		// namespaces with sidecars report the scope of their configuration
+		assert.Equal(t, &models.SidecarScope{FullMeshConfig: true}, nsInfo.SidecarScope)
+	})
+
+	t.Run("Revision tags are resolved to their revision", func(t *testing.T) {
+		nsInfo, err := processNamespace(ctx, fakeClient, metricsfake.NewSimpleClientset(), "test-default", false, mesh, &utils.Config{})
+		require.NoError(t, err)
+		// pods which aren't injected aren't counted
+		assert.Equal(t, map[string]int{utils.DefaultRevision: 1}, nsInfo.InjectedRevisions)
+		assert.Equal(t, 0, nsInfo.PodsNeedingRestart)
+
+		pod, err := fakeClient.CoreV1().Pods("test-default").Get(ctx, "pod-default", metav1.GetOptions{})
+		require.NoError(t, err)
+		injection := utils.CheckInject(webhooks, nil, pod, map[string]string{"istio-injection": "enabled"})
+		assert.True(t, injection.Injected)
+		assert.Equal(t, utils.DefaultRevision, injection.Revision)
+		assert.Equal(t, "default", injection.Tag)
+	})
+}
+
+func TestProcessNamespaceIstioConfig(t *testing.T) {
+	ctx := context.Background()
+	fakeClient := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}},
+	)
+	mesh := &meshInfo{istioConfig: map[string]map[string]int{"app": {"VirtualService": 2, "AuthorizationPolicy": 1}}}
+
+	nsInfo, err := processNamespace(ctx, fakeClient, metricsfake.NewSimpleClientset(), "app", false, mesh, &utils.Config{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"VirtualService": 2, "AuthorizationPolicy": 1}, nsInfo.IstioConfig)
+
+	// namespaces without istio config leave it out
+	nsInfo, err = processNamespace(ctx, fakeClient, metricsfake.NewSimpleClientset(), "web", false, mesh, &utils.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, nsInfo.IstioConfig)
+}
+
+func TestSidecarScope(t *testing.T) {
+	meshDefault := utils.Sidecar{Name: "default", Namespace: "istio-system", EgressHosts: []string{"./*", "istio-system/*"}}
+	tests := []struct {
+		name          string
+		rootNamespace string
+		sidecars      map[string][]utils.Sidecar
+		expected      *models.SidecarScope
+	}{
+		{
+			name:     "No Sidecar resources",
+			sidecars: map[string][]utils.Sidecar{},
+			expected: &models.SidecarScope{FullMeshConfig: true},
+		},
+		{
+			name:     "Mesh-wide default Sidecar",
+			sidecars: map[string][]utils.Sidecar{"istio-system": {meshDefault}},
+			expected: &models.SidecarScope{Default: models.SidecarScopeMesh, EgressScoped: true, EgressHosts: 2},
+		},
+		{
+			name:          "Mesh-wide default Sidecar in a custom root namespace",
+			rootNamespace: "istio-config",
+			sidecars: map[string][]utils.Sidecar{
+				"istio-config": {meshDefault},
+				"istio-system": {{Name: "default", Namespace: "istio-system", EgressHosts: []string{"*/*"}}},
+			},
+			expected: &models.SidecarScope{Default: models.SidecarScopeMesh, EgressScoped: true, EgressHosts: 2},
+		},
+		{
+			name: "Namespace default Sidecar takes precedence over the mesh-wide default",
+			sidecars: map[string][]utils.Sidecar{
+				"istio-system": {meshDefault},
+				"app":          {{Name: "default", Namespace: "app", EgressHosts: []string{"*/*"}}},
+			},
+			expected: &models.SidecarScope{Resources: 1, Default: models.SidecarScopeNamespace, EgressHosts: 1, FullMeshConfig: true},
+		},
+		{
+			name: "Workload Sidecars don't apply to the rest of the namespace",
+			sidecars: map[string][]utils.Sidecar{
+				"app": {
+					{Name: "reviews", Namespace: "app", HasWorkloadSelector: true, EgressHosts: []string{"./*"}},
+					{Name: "ratings", Namespace: "app", HasWorkloadSelector: true, EgressHosts: []string{"./*"}},
+				},
+			},
+			expected: &models.SidecarScope{Resources: 2, WorkloadResources: 2, FullMeshConfig: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rootNamespace := tt.rootNamespace
+			if rootNamespace == "" {
+				rootNamespace = utils.DefaultIstioNamespace
 			}
+			assert.Equal(t, tt.expected, sidecarScope("app", rootNamespace, tt.sidecars))
 		})
 	}
 }
 
+// mapKeys returns the keys of a map in no particular order
+func mapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// assertContainerResources asserts that the optional container resources match, using InDelta for the resource values
+func assertContainerResources(t *testing.T, expected, actual *models.ContainerResources) {
+	t.Helper()
+	if expected == nil {
+		assert.Nil(t, actual)
+		return
+	}
+	require.NotNil(t, actual)
+	assert.Equal(t, expected.Containers, actual.Containers)
+	assert.InDelta(t, expected.Request.CPU, actual.Request.CPU, 0.001)
+	assert.InDelta(t, expected.Request.MemoryGB, actual.Request.MemoryGB, 0.001)
+	assert.InDelta(t, expected.Limit.CPU, actual.Limit.CPU, 0.001)
+	assert.InDelta(t, expected.Limit.MemoryGB, actual.Limit.MemoryGB, 0.001)
+	assert.Equal(t, expected.MissingRequests, actual.MissingRequests)
+	assert.Equal(t, expected.MissingLimits, actual.MissingLimits)
+	if expected.Actual != nil {
+		require.NotNil(t, actual.Actual)
+		assert.InDelta(t, expected.Actual.CPU, actual.Actual.CPU, 0.001)
+		assert.InDelta(t, expected.Actual.MemoryGB, actual.Actual.MemoryGB, 0.001)
+	} else {
+		assert.Nil(t, actual.Actual)
+	}
+}
+
 // TODO(infocus7): Create tests like above, but where the MWH had the setup where a user enabled namespace injection by default
 
+func TestMTLSMode(t *testing.T) {
+	meshStrict := utils.PeerAuthentication{Name: "default", Namespace: "istio-system", Mode: utils.MTLSModeStrict}
+	tests := []struct {
+		name          string
+		rootNamespace string
+		policies      map[string][]utils.PeerAuthentication
+		expected      *models.MTLSInfo
+	}{
+		{
+			name:     "no policies default to permissive",
+			policies: map[string][]utils.PeerAuthentication{},
+			expected: &models.MTLSInfo{Mode: utils.MTLSModePermissive},
+		},
+		{
+			name:     "mesh-wide policy applies to the namespace",
+			policies: map[string][]utils.PeerAuthentication{"istio-system": {meshStrict}},
+			expected: &models.MTLSInfo{Mode: utils.MTLSModeStrict, MeshMode: utils.MTLSModeStrict},
+		},
+		{
+			name:          "policies outside a custom root namespace aren't mesh-wide",
+			rootNamespace: "istio-config",
+			policies:      map[string][]utils.PeerAuthentication{"istio-system": {meshStrict}},
+			expected:      &models.MTLSInfo{Mode: utils.MTLSModePermissive},
+		},
+		{
+			name: "namespace policy overrides the mesh-wide policy",
+			policies: map[string][]utils.PeerAuthentication{
+				"istio-system": {meshStrict},
+				"app":          {{Name: "default", Namespace: "app", Mode: utils.MTLSModePermissive}, {Name: "newer", Namespace: "app", Mode: utils.MTLSModeDisable}},
+			},
+			expected: &models.MTLSInfo{Mode: utils.MTLSModePermissive, MeshMode: utils.MTLSModeStrict, NamespaceMode: utils.MTLSModePermissive},
+		},
+		{
+			name: "namespace policy without a mode inherits the mesh-wide policy",
+			policies: map[string][]utils.PeerAuthentication{
+				"istio-system": {meshStrict},
+				"app":          {{Name: "default", Namespace: "app"}},
+			},
+			expected: &models.MTLSInfo{Mode: utils.MTLSModeStrict, MeshMode: utils.MTLSModeStrict},
+		},
+		{
+			name: "workload policies are counted without changing the mode",
+			policies: map[string][]utils.PeerAuthentication{
+				"istio-system": {{Name: "legacy", Namespace: "istio-system", HasSelector: true, Mode: utils.MTLSModeDisable}, meshStrict},
+				"app": {
+					{Name: "legacy", Namespace: "app", HasSelector: true, Mode: utils.MTLSModeDisable},
+					{Name: "ports", Namespace: "app", HasSelector: true, PortOverrides: 2},
+				},
+			},
+			expected: &models.MTLSInfo{Mode: utils.MTLSModeStrict, MeshMode: utils.MTLSModeStrict, WorkloadPolicies: 2, PortOverrides: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rootNamespace := tt.rootNamespace
+			if rootNamespace == "" {
+				rootNamespace = utils.DefaultIstioNamespace
+			}
+			assert.Equal(t, tt.expected, mtlsMode("app", rootNamespace, tt.policies))
+		})
+	}
+}
+
+func TestProcessNamespaceMTLS(t *testing.T) {
+	ctx := context.Background()
+	fakeClient := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app"}})
+
+	// the mode isn't reported if the PeerAuthentications couldn't be read
+	nsInfo, err := processNamespace(ctx, fakeClient, metricsfake.NewSimpleClientset(), "app", false, &meshInfo{}, &utils.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, nsInfo.MTLS)
+
+	mesh := &meshInfo{peerAuthentications: map[string][]utils.PeerAuthentication{}}
+	nsInfo, err = processNamespace(ctx, fakeClient, metricsfake.NewSimpleClientset(), "app", false, mesh, &utils.Config{})
+	require.NoError(t, err)
+	assert.Equal(t, &models.MTLSInfo{Mode: utils.MTLSModePermissive}, nsInfo.MTLS)
+}
+
+func TestProcessNamespaceNodeDensity(t *testing.T) {
+	ctx := context.Background()
+	densities := newNodeDensities(false)
+	mesh := &meshInfo{webhooks: loadDefaultIstioWebhooks(t), densities: densities}
+
+	pending := testutils.NewPod("test-istio", "pod-pending", "", "100m", "128Mi", true, "100m", "128Mi", map[string]string{})
+	pending.Status.Phase = corev1.PodPending
+	fakeClient := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-istio", Labels: map[string]string{"istio-injection": "enabled"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-ambient", Labels: map[string]string{utils.DataplaneModeLabel: utils.DataplaneModeAmbient}}},
+		testutils.NewPod("test-istio", "pod-a1", "node-a", "100m", "128Mi", true, "200m", "256Mi", map[string]string{}),
+		testutils.NewPod("test-istio", "pod-a2", "node-a", "100m", "128Mi", true, "200m", "256Mi", map[string]string{}),
+		testutils.NewPod("test-istio", "pod-b1", "node-b", "100m", "128Mi", true, "200m", "256Mi", map[string]string{}),
+		testutils.NewPod("test-istio", "pod-b2", "node-b", "100m", "128Mi", false, "", "", map[string]string{utils.SidecarInjectKey: "false"}),
+		pending,
+		testutils.NewPod("test-ambient", "pod-a3", "node-a", "100m", "128Mi", false, "", "", map[string]string{}),
+	)
+	fakeMetricsClient := metricsfake.NewSimpleClientset()
+	fakeMetricsClient.PrependReactor("list", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		podMetricsList := &v1beta1.PodMetricsList{}
+		if action.GetNamespace() == "test-istio" {
+			podMetricsList.Items = []v1beta1.PodMetrics{
+				*testutils.NewPodMetrics("test-istio", "pod-a1", "50m", "64Mi", true, "10m", "32Mi"),
+				*testutils.NewPodMetrics("test-istio", "pod-a2", "50m", "64Mi", true, "20m", "32Mi"),
+			}
+		}
+		return true, podMetricsList, nil
+	})
+
+	_, err := processNamespace(ctx, fakeClient, fakeMetricsClient, "test-istio", true, mesh, &utils.Config{})
+	require.NoError(t, err)
+	_, err = processNamespace(ctx, fakeClient, fakeMetricsClient, "test-ambient", true, mesh, &utils.Config{})
+	require.NoError(t, err)
+
+	nodeA := densities.get("node-a")
+	require.NotNil(t, nodeA)
+	assert.Equal(t, 3, nodeA.Pods)
+	assert.Equal(t, 3, nodeA.MeshedPods)
+	assert.Equal(t, 2, nodeA.Sidecars.Containers)
+	assert.InDelta(t, 0.4, nodeA.Sidecars.Request.CPU, 0.001)
+	assert.InDelta(t, 0.5, nodeA.Sidecars.Request.MemoryGB, 0.001)
+	require.NotNil(t, nodeA.Sidecars.Actual)
+	assert.InDelta(t, 0.03, nodeA.Sidecars.Actual.CPU, 0.001)
+	assert.InDelta(t, 64.0/1024.0, nodeA.Sidecars.Actual.MemoryGB, 0.001)
+
+	nodeB := densities.get("node-b")
+	assert.Equal(t, 2, nodeB.Pods)
+	assert.Equal(t, 1, nodeB.MeshedPods)
+	assert.Equal(t, 1, nodeB.Sidecars.Containers)
+
+	// nodes without pods have an empty density, and the density isn't reported if it wasn't aggregated
+	assert.Equal(t, &models.NodeDensity{}, densities.get("node-c"))
+	var noDensities *nodeDensities
+	assert.Nil(t, noDensities.get("node-a"))
+}
+
+func TestProcessNamespaceMetricsAttribution(t *testing.T) {
+	ctx := context.Background()
+
+	// a manually injected pod in a namespace with injection disabled, whose istio-proxy isn't counted as a sidecar
+	completed := testutils.NewPod("test-metrics", "pod-completed", "node-a", "100m", "128Mi", false, "", "", map[string]string{})
+	completed.Status.Phase = corev1.PodSucceeded
+	fakeClient := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-metrics", Labels: map[string]string{"istio-injection": "disabled"}}},
+		testutils.NewPod("test-metrics", "pod-manual", "node-a", "100m", "128Mi", true, "100m", "128Mi", map[string]string{}),
+		testutils.NewPod("test-metrics", "gateway", "node-a", "", "", false, "", "", map[string]string{"istio": "ingressgateway"}),
+		completed,
+	)
+	fakeMetricsClient := metricsfake.NewSimpleClientset()
+	fakeMetricsClient.PrependReactor("list", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		gatewayMetrics := testutils.NewPodMetrics("test-metrics", "gateway", "300m", "256Mi", false, "", "")
+		gatewayMetrics.Containers[0].Name = "app"
+		return true, &v1beta1.PodMetricsList{Items: []v1beta1.PodMetrics{
+			*testutils.NewPodMetrics("test-metrics", "pod-manual", "50m", "64Mi", true, "10m", "32Mi"),
+			*gatewayMetrics,
+			// completed pods aren't counted, and the deleted pod can't be joined to a pod
+			*testutils.NewPodMetrics("test-metrics", "pod-completed", "50m", "64Mi", false, "", ""),
+			*testutils.NewPodMetrics("test-metrics", "pod-deleted", "50m", "64Mi", true, "10m", "32Mi"),
+		}}, nil
+	})
+
+	mesh := &meshInfo{webhooks: loadDefaultIstioWebhooks(t)}
+	nsInfo, err := processNamespace(ctx, fakeClient, fakeMetricsClient, "test-metrics", true, mesh, &utils.Config{})
+	require.NoError(t, err)
+
+	assert.False(t, nsInfo.IsIstioInjected)
+	assert.Nil(t, nsInfo.Resources.Istio)
+	assertContainerResources(t, &models.ContainerResources{
+		Containers:    2,
+		Request:       models.Resources{CPU: 0.2, MemoryGB: 0.25},
+		Actual:        &models.Resources{CPU: 0.06, MemoryGB: 96.0 / 1024.0},
+		MissingLimits: 2,
+	}, &nsInfo.Resources.Regular)
+	assertContainerResources(t, &models.ContainerResources{
+		Containers:      1,
+		Actual:          &models.Resources{CPU: 0.3, MemoryGB: 0.25},
+		MissingRequests: 1,
+		MissingLimits:   1,
+	}, nsInfo.Resources.Gateway)
+	assert.Equal(t, 1, nsInfo.UnmatchedPodMetrics)
+}
+
 func TestProcessNode(t *testing.T) {
 	ctx := context.Background()
 
@@ -513,7 +1469,7 @@ func TestProcessNode(t *testing.T) {
 				return false, nil, fmt.Errorf("nodeMetrics not found")
 			})
 
-			nodeInfo, err := processNode(ctx, fakeMetricsClient, tt.node, tt.hasMetricsAPI)
+			nodeInfo, err := processNode(ctx, fakeMetricsClient, tt.node, tt.hasMetricsAPI, &utils.Config{})
 
 			if tt.expectError {
 				assert.Error(t, err)
@@ -536,10 +1492,80 @@ func TestProcessNode(t *testing.T) {
 	}
 }
 
+func TestProcessNodeDetails(t *testing.T) {
+	ctx := context.Background()
+
+	newNode := func(labels map[string]string) corev1.Node {
+		node := testutils.NewNode("node-1", "4", "16Gi", labels)
+		node.Status.Allocatable = corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("3920m"),
+			corev1.ResourceMemory: resource.MustParse("14Gi"),
+			corev1.ResourcePods:   resource.MustParse("58"),
+		}
+		node.Status.NodeInfo = corev1.NodeSystemInfo{Architecture: "arm64", OperatingSystem: "linux", KubeletVersion: "v1.31.2-eks-7f9249a"}
+		node.Spec.Taints = []corev1.Taint{
+			{Key: "dedicated", Value: "payments", Effect: corev1.TaintEffectNoSchedule},
+			{Key: "node.kubernetes.io/unreachable", Effect: corev1.TaintEffectNoExecute},
+		}
+		return *node
+	}
+
+	t.Run("allocatable, system info, taints and node pool are recorded", func(t *testing.T) {
+		node := newNode(map[string]string{"eks.amazonaws.com/nodegroup": "general"})
+		nodeInfo, err := processNode(ctx, metricsfake.NewSimpleClientset(), node, false, &utils.Config{})
+		require.NoError(t, err)
+
+		assert.InDelta(t, 3.92, nodeInfo.Resources.Allocatable.CPU, 0.001)
+		assert.InDelta(t, 14.0, nodeInfo.Resources.Allocatable.MemoryGB, 0.001)
+		assert.Equal(t, 58, nodeInfo.MaxPods)
+		assert.Equal(t, "arm64", nodeInfo.Architecture)
+		assert.Equal(t, "linux", nodeInfo.OS)
+		assert.Equal(t, "v1.31.2-eks-7f9249a", nodeInfo.KubeletVersion)
+		assert.Equal(t, []models.NodeTaint{
+			{Key: "dedicated", Value: "payments", Effect: "NoSchedule"},
+			{Key: "node.kubernetes.io/unreachable", Effect: "NoExecute"},
+		}, nodeInfo.Taints)
+		assert.Equal(t, &models.NodePool{Provider: "eks", Name: "general"}, nodeInfo.NodePool)
+	})
+
+	t.Run("node pools of each provider are recognized", func(t *testing.T) {
+		for label, provider := range map[string]string{
+			"cloud.google.com/gke-nodepool":  "gke",
+			"kubernetes.azure.com/agentpool": "aks",
+			"agentpool":                      "aks",
+			"karpenter.sh/nodepool":          "karpenter",
+			"karpenter.sh/provisioner-name":  "karpenter",
+		} {
+			nodeInfo, err := processNode(ctx, metricsfake.NewSimpleClientset(), newNode(map[string]string{label: "pool"}), false, &utils.Config{})
+			require.NoError(t, err)
+			assert.Equal(t, &models.NodePool{Provider: provider, Name: "pool"}, nodeInfo.NodePool, label)
+		}
+
+		nodeInfo, err := processNode(ctx, metricsfake.NewSimpleClientset(), newNode(map[string]string{}), false, &utils.Config{})
+		require.NoError(t, err)
+		assert.Nil(t, nodeInfo.NodePool)
+	})
+
+	t.Run("taint values and node pool names are hidden", func(t *testing.T) {
+		node := newNode(map[string]string{"cloud.google.com/gke-nodepool": "payments-pool"})
+		nodeInfo, err := processNode(ctx, metricsfake.NewSimpleClientset(), node, false, &utils.Config{ObfuscateNames: true})
+		require.NoError(t, err)
+
+		assert.Equal(t, []models.NodeTaint{
+			{Key: "dedicated", Value: ObfuscateName("payments"), Effect: "NoSchedule"},
+			{Key: "node.kubernetes.io/unreachable", Effect: "NoExecute"},
+		}, nodeInfo.Taints)
+		assert.Equal(t, &models.NodePool{Provider: "gke", Name: ObfuscateName("payments-pool")}, nodeInfo.NodePool)
+	})
+}
+
 func TestLoadExistingData(t *testing.T) {
 	tempDir := t.TempDir()
 
 	jsonData := `{
+  "metadata": {
+    "schema_version": 1
+  },
   "name": "test-cluster",
   "has_metrics": true,
   "namespaces": {
@@ -553,10 +1579,16 @@ func TestLoadExistingData(t *testing.T) {
             "cpu": 0.1,
             "memory_gb": 0.125
           },
+          "limit": {
+            "cpu": 0.5,
+            "memory_gb": 0.25
+          },
           "actual": {
             "cpu": 0.05,
             "memory_gb": 0.06
-          }
+          },
+          "missing_requests": 0,
+          "missing_limits": 0
         }
       }
     }
@@ -594,7 +1626,9 @@ func TestLoadExistingData(t *testing.T) {
     }
   }
 }`
-	yamlData := `name: yaml-cluster
+	yamlData := `metadata:
+  schema_version: 1
+name: yaml-cluster
 has_metrics: false
 namespaces:
   kube-system:
@@ -606,6 +1640,8 @@ namespaces:
         request:
           cpu: 0.5
           memory_gb: 1.5
+        missing_requests: 0
+        missing_limits: 5
 nodes:
   test-node:
     instance_type: m5.large
@@ -625,6 +1661,10 @@ nodes:
 	require.NoError(t, os.WriteFile(invalidFile, []byte("hello"), 0644))
 	malformedFile := filepath.Join(tempDir, "malformed.json")
 	require.NoError(t, os.WriteFile(malformedFile, []byte(`{"name":"bad"`), 0644))
+	unversionedFile := filepath.Join(tempDir, "unversioned.json")
+	require.NoError(t, os.WriteFile(unversionedFile, []byte(`{"name":"old"}`), 0644))
+	futureFile := filepath.Join(tempDir, "future.json")
+	require.NoError(t, os.WriteFile(futureFile, []byte(`{"metadata":{"schema_version":999},"name":"new"}`), 0644))
 
 	tests := []struct {
 		name        string
@@ -646,6 +1686,7 @@ nodes:
 							Regular: models.ContainerResources{
 								Containers: 1,
 								Request:    models.Resources{CPU: 0.1, MemoryGB: 0.125},
+								Limit:      models.Resources{CPU: 0.5, MemoryGB: 0.25},
 								Actual:     &models.Resources{CPU: 0.05, MemoryGB: 0.06},
 							},
 						},
@@ -686,8 +1727,9 @@ nodes:
 						IsIstioInjected: false,
 						Resources: models.ResourceInfo{
 							Regular: models.ContainerResources{
-								Containers: 5,
-								Request:    models.Resources{CPU: 0.5, MemoryGB: 1.5},
+								Containers:    5,
+								Request:       models.Resources{CPU: 0.5, MemoryGB: 1.5},
+								MissingLimits: 5,
 								// Actual is nil implicitly
 							},
 						},
@@ -724,6 +1766,18 @@ nodes:
 			expected:    nil,
 			expectError: true,
 		},
+		{
+			name:        "Missing schema version",
+			filePath:    unversionedFile,
+			expected:    nil,
+			expectError: true,
+		},
+		{
+			name:        "Unsupported schema version",
+			filePath:    futureFile,
+			expected:    nil,
+			expectError: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -749,6 +1803,8 @@ nodes:
 						// Compare nsInfo fields (using InDelta for floats)
 						assert.Equal(t, expectedNs.Pods, actualNs.Pods)
 						assert.Equal(t, expectedNs.IsIstioInjected, actualNs.IsIstioInjected)
+						assert.Equal(t, expectedNs.Resources.Regular.Limit, actualNs.Resources.Regular.Limit)
+						assert.Equal(t, expectedNs.Resources.Regular.MissingLimits, actualNs.Resources.Regular.MissingLimits)
 						// ... compare resources ...
 					}
 				}
@@ -0,0 +1,133 @@
+package gatherer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/solo-io/istio-usage-collector/internal/utils"
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+)
+
+// usageHistory is the distribution of the combined usage of a namespace's containers over the Prometheus lookback window
+type usageHistory struct {
+	// types are the usage of each type of container of the pods counted in the totals
+	types map[containerType]*models.UsagePercentiles
+	// unattributed is the usage of the containers of other pods, nil if there are none
+	unattributed *models.UsagePercentiles
+}
+
+// historicalUsage reads the usage of a namespace's containers over the Prometheus lookback window, and computes the distribution
+// of the combined usage of each type of container. Containers of the pods counted in the totals are classified exactly like their
+// requests. The containers of other pods (such as pods replaced by a rollout) can't be classified, as ztunnel, waypoint and gateway
+// pods run an istio-proxy container just like sidecars do, so their usage is reported separately as unattributed.
+// Classic init containers are left out, as they only run while their pod starts.
+func historicalUsage(ctx context.Context, prometheus *utils.PrometheusClient, namespace string, podContainers map[string]map[string]containerType) (*usageHistory, error) {
+	cpuSeries, err := prometheus.ContainerCPUUsage(ctx, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the CPU usage history: %w", err)
+	}
+	memorySeries, err := prometheus.ContainerMemoryUsage(ctx, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the memory usage history: %w", err)
+	}
+
+	cpu, unattributedCPU := sumByContainerType(cpuSeries, podContainers)
+	memory, unattributedMemory := sumByContainerType(memorySeries, podContainers)
+
+	history := &usageHistory{types: make(map[containerType]*models.UsagePercentiles)}
+	for usageType := range cpu {
+		history.types[usageType] = usagePercentiles(cpu[usageType], memory[usageType])
+	}
+	for usageType := range memory {
+		if _, ok := cpu[usageType]; !ok {
+			history.types[usageType] = usagePercentiles(nil, memory[usageType])
+		}
+	}
+	if unattributedCPU != nil || unattributedMemory != nil {
+		history.unattributed = usagePercentiles(unattributedCPU, unattributedMemory)
+	}
+	return history, nil
+}
+
+// usagePercentiles returns the distribution of the combined CPU (in cores) and memory (in bytes) usage of a group of containers
+func usagePercentiles(cpu, memory map[int64]float64) *models.UsagePercentiles {
+	cpuValues := sortedValues(cpu)
+	memoryValues := sortedValues(memory)
+	return &models.UsagePercentiles{
+		P50: models.Resources{CPU: percentile(cpuValues, 0.5), MemoryGB: percentile(memoryValues, 0.5) / (1024 * 1024 * 1024)},
+		P95: models.Resources{CPU: percentile(cpuValues, 0.95), MemoryGB: percentile(memoryValues, 0.95) / (1024 * 1024 * 1024)},
+		Max: models.Resources{CPU: percentile(cpuValues, 1), MemoryGB: percentile(memoryValues, 1) / (1024 * 1024 * 1024)},
+	}
+}
+
+// sumByContainerType sums the series of each type of container of the pods counted in the totals at each timestamp,
+// along with the series of the containers of other pods, which are nil if there are none
+func sumByContainerType(series []utils.ContainerSeries, podContainers map[string]map[string]containerType) (map[containerType]map[int64]float64, map[int64]float64) {
+	sums := make(map[containerType]map[int64]float64)
+	var unattributed map[int64]float64
+	for _, containerSeries := range series {
+		usageType, ok := podContainers[containerSeries.Pod][containerSeries.Container]
+		if !ok {
+			if unattributed == nil {
+				unattributed = make(map[int64]float64)
+			}
+			for timestamp, value := range containerSeries.Samples {
+				unattributed[timestamp] += value
+			}
+			continue
+		}
+		if usageType == initContainer {
+			continue
+		}
+
+		if sums[usageType] == nil {
+			sums[usageType] = make(map[int64]float64)
+		}
+		for timestamp, value := range containerSeries.Samples {
+			sums[usageType][timestamp] += value
+		}
+	}
+	return sums, unattributed
+}
+
+// sortedValues returns the values of the samples in ascending order
+func sortedValues(samples map[int64]float64) []float64 {
+	values := make([]float64, 0, len(samples))
+	for _, value := range samples {
+		values = append(values, value)
+	}
+	sort.Float64s(values)
+	return values
+}
+
+// percentile returns the nearest-rank percentile (between 0 and 1) of values sorted in ascending order, or 0 if there are none
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// containerResourcesOf returns the resources of a type of container, nil if the type isn't reported
+func containerResourcesOf(info *models.ResourceInfo, usageType containerType) *models.ContainerResources {
+	switch usageType {
+	case istioContainer:
+		return info.Istio
+	case initContainer:
+		return info.Init
+	case ztunnelContainer:
+		return info.Ztunnel
+	case waypointContainer:
+		return info.Waypoint
+	case gatewayContainer:
+		return info.Gateway
+	default:
+		return &info.Regular
+	}
+}
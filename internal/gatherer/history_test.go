@@ -0,0 +1,84 @@
+//go:build test || unit
+
+package gatherer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/solo-io/istio-usage-collector/internal/utils"
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+	testutils "github.com/solo-io/istio-usage-collector/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	metricsfake "k8s.io/metrics/pkg/client/clientset/versioned/fake"
+)
+
+// newPrometheusStub serves the CPU and memory range queries with the given results
+func newPrometheusStub(t *testing.T, cpuResult, memoryResult string) *utils.PrometheusClient {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := memoryResult
+		if strings.Contains(r.URL.Query().Get("query"), "container_cpu_usage_seconds_total") {
+			result = cpuResult
+		}
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[` + result + `]}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := utils.NewPrometheusClient(utils.PrometheusConfig{URL: server.URL})
+	require.NoError(t, err)
+	return client
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	assert.Equal(t, 5.0, percentile(values, 0.5))
+	assert.Equal(t, 10.0, percentile(values, 0.95))
+	assert.Equal(t, 10.0, percentile(values, 1))
+	assert.Equal(t, 1.0, percentile(values, 0))
+	assert.Equal(t, 0.0, percentile(nil, 0.5))
+}
+
+func TestProcessNamespaceHistoricalUsage(t *testing.T) {
+	ctx := context.Background()
+	fakeClient := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-istio", Labels: map[string]string{"istio-injection": "enabled"}}},
+		testutils.NewPod("test-istio", "web-1", "node-a", "100m", "128Mi", true, "100m", "128Mi", map[string]string{}),
+	)
+
+	// web-1 is still running, while web-0 was replaced: its istio-proxy can't be told apart from a gateway's or waypoint's
+	cpu := `{"metric":{"pod":"web-1","container":"app"},"values":[[100,"0.2"],[160,"0.4"],[220,"0.6"]]},
+		{"metric":{"pod":"web-1","container":"istio-proxy"},"values":[[100,"0.01"],[160,"0.02"],[220,"0.03"]]},
+		{"metric":{"pod":"web-0","container":"istio-proxy"},"values":[[100,"0.05"]]}`
+	memory := `{"metric":{"pod":"web-1","container":"app"},"values":[[100,"1073741824"],[160,"2147483648"]]}`
+	mesh := &meshInfo{webhooks: loadDefaultIstioWebhooks(t), prometheus: newPrometheusStub(t, cpu, memory)}
+
+	nsInfo, err := processNamespace(ctx, fakeClient, metricsfake.NewSimpleClientset(), "test-istio", false, mesh, &utils.Config{})
+	require.NoError(t, err)
+
+	assert.Equal(t, &models.UsagePercentiles{
+		P50: models.Resources{CPU: 0.4, MemoryGB: 1},
+		P95: models.Resources{CPU: 0.6, MemoryGB: 2},
+		Max: models.Resources{CPU: 0.6, MemoryGB: 2},
+	}, nsInfo.Resources.Regular.Historical)
+	require.NotNil(t, nsInfo.Resources.Istio)
+	require.NotNil(t, nsInfo.Resources.Istio.Historical)
+	assert.InDelta(t, 0.02, nsInfo.Resources.Istio.Historical.P50.CPU, 0.0001)
+	assert.InDelta(t, 0.03, nsInfo.Resources.Istio.Historical.Max.CPU, 0.0001)
+	assert.Zero(t, nsInfo.Resources.Istio.Historical.Max.MemoryGB)
+	// the replaced pod's usage is reported separately rather than guessed from its container's name
+	assert.Equal(t, &models.UsagePercentiles{
+		P50: models.Resources{CPU: 0.05},
+		P95: models.Resources{CPU: 0.05},
+		Max: models.Resources{CPU: 0.05},
+	}, nsInfo.Resources.UnattributedHistorical)
+	// the metrics API wasn't available, which doesn't prevent the historical usage from being reported
+	assert.Nil(t, nsInfo.Resources.Regular.Actual)
+}
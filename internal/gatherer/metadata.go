@@ -0,0 +1,49 @@
+package gatherer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/solo-io/istio-usage-collector/internal/logging"
+	"github.com/solo-io/istio-usage-collector/internal/utils"
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+	"k8s.io/client-go/kubernetes"
+)
+
+// newMetadata describes a collection started at the given time, by this build of the collector and with its flags.
+// The version of the Kubernetes API server is read through the discovery client, and left out if it can't be read.
+func newMetadata(clientset kubernetes.Interface, cfg *utils.Config, startedAt time.Time) *models.Metadata {
+	metadata := &models.Metadata{
+		SchemaVersion:    models.SchemaVersion,
+		CollectorVersion: cfg.CollectorVersion,
+		CollectorCommit:  cfg.CollectorCommit,
+		StartedAt:        startedAt,
+		Flags:            cfg.Flags,
+	}
+
+	serverVersion, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		logging.Warn("Failed to get the Kubernetes server version: %v", err)
+	} else {
+		metadata.KubernetesVersion = serverVersion.GitVersion
+	}
+	return metadata
+}
+
+// finishMetadata records that the collection finished now
+func finishMetadata(metadata *models.Metadata) {
+	finishedAt := time.Now().UTC()
+	metadata.FinishedAt = &finishedAt
+	metadata.DurationSeconds = finishedAt.Sub(metadata.StartedAt).Seconds()
+}
+
+// checkSchemaVersion returns an error if the cluster info wasn't written with the schema version of this collector
+func checkSchemaVersion(clusterInfo *models.ClusterInfo) error {
+	if clusterInfo.Metadata == nil {
+		return fmt.Errorf("the file has no schema version (expected %d), it was written by an older version of the collector", models.SchemaVersion)
+	}
+	if clusterInfo.Metadata.SchemaVersion != models.SchemaVersion {
+		return fmt.Errorf("the file has schema version %d, but this collector writes schema version %d", clusterInfo.Metadata.SchemaVersion, models.SchemaVersion)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+//go:build test || unit
+
+package gatherer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/solo-io/istio-usage-collector/internal/utils"
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMetadata(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset()
+	fakeClient.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: "v1.32.3"}
+	cfg := &utils.Config{CollectorVersion: "v0.2.0", CollectorCommit: "abc123", Flags: map[string]string{"workloads": "true"}}
+	startedAt := time.Now().UTC().Add(-time.Minute)
+
+	metadata := newMetadata(fakeClient, cfg, startedAt)
+	assert.Equal(t, &models.Metadata{
+		SchemaVersion:     models.SchemaVersion,
+		CollectorVersion:  "v0.2.0",
+		CollectorCommit:   "abc123",
+		KubernetesVersion: "v1.32.3",
+		StartedAt:         startedAt,
+		Flags:             map[string]string{"workloads": "true"},
+	}, metadata)
+
+	finishMetadata(metadata)
+	require.NotNil(t, metadata.FinishedAt)
+	assert.False(t, metadata.FinishedAt.Before(startedAt))
+	assert.GreaterOrEqual(t, metadata.DurationSeconds, 60.0)
+}
@@ -0,0 +1,195 @@
+package gatherer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/solo-io/istio-usage-collector/internal/utils"
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+	"k8s.io/client-go/dynamic"
+)
+
+// Messages of the migration readiness findings
+const (
+	envoyFilterMessage          = "EnvoyFilters patch the sidecar's Envoy configuration and are not supported in ambient mode"
+	workloadSidecarMessage      = "Workload-specific Sidecar resources are ignored in ambient mode, their settings must be moved elsewhere"
+	namespaceSidecarMessage     = "Sidecar resources are ignored in ambient mode, where configuration no longer needs to be scoped"
+	proxyConfigMessage          = "ProxyConfig resources only apply to sidecars and are ignored in ambient mode"
+	proxyConfigOverridesMessage = "Pods override their proxy configuration through the " + utils.ProxyConfigAnnotation + " annotation, which is ignored in ambient mode"
+	l7PolicyMessage             = "AuthorizationPolicies using L7 rules or the CUSTOM action are only enforced by a waypoint in ambient mode"
+)
+
+// meshResources are the Istio resources the readiness of each namespace depends on, keyed by namespace
+type meshResources struct {
+	envoyFilters map[string]int
+	proxyConfigs map[string]int
+	sidecars     map[string][]utils.Sidecar
+	policies     map[string][]utils.AuthorizationPolicy
+}
+
+// analyzeMigrationReadiness evaluates whether each gathered namespace can move to ambient mode today, based on the
+// Istio resources which only apply to sidecars, and the L7 policies which would require a waypoint.
+// Resources in the Istio root namespace apply to the whole mesh, so they're reported once and affect every namespace.
+// The Sidecar resources are those loaded into the mesh to process the namespaces.
+func analyzeMigrationReadiness(ctx context.Context, dynamicClient dynamic.Interface, mesh *meshInfo, clusterInfo *models.ClusterInfo, cfg *utils.Config) (*models.MigrationReadiness, error) {
+	if mesh.sidecars == nil {
+		return nil, fmt.Errorf("the Sidecar resources couldn't be listed")
+	}
+	resources := meshResources{sidecars: mesh.sidecars}
+	var err error
+	if resources.envoyFilters, err = utils.CountEnvoyFilters(ctx, dynamicClient); err != nil {
+		return nil, fmt.Errorf("failed to count EnvoyFilters: %w", err)
+	}
+	if resources.proxyConfigs, err = utils.CountProxyConfigs(ctx, dynamicClient); err != nil {
+		return nil, fmt.Errorf("failed to count ProxyConfigs: %w", err)
+	}
+	if resources.policies, err = utils.ListAuthorizationPolicies(ctx, dynamicClient); err != nil {
+		return nil, fmt.Errorf("failed to list AuthorizationPolicies: %w", err)
+	}
+
+	// findings are keyed by the namespace's output name, to match the gathered namespaces
+	findings := make(map[string][]models.ReadinessFinding)
+	var meshWide []models.ReadinessFinding
+	for _, namespace := range resources.namespaces() {
+		if namespace == mesh.rootNamespace {
+			meshWide = resources.findings(namespace)
+			continue
+		}
+		outName := namespace
+		if cfg.ObfuscateNames {
+			outName = ObfuscateName(namespace)
+		}
+		findings[outName] = resources.findings(namespace)
+	}
+
+	readiness := &models.MigrationReadiness{
+		MeshWide:   meshWide,
+		Namespaces: make(map[string]*models.NamespaceReadiness, len(clusterInfo.Namespaces)),
+	}
+	for outName, nsInfo := range clusterInfo.Namespaces {
+		nsFindings := findings[outName]
+		// the pods overriding their proxy configuration were counted while gathering the namespace
+		if nsInfo.SidecarProfiles != nil && nsInfo.SidecarProfiles.ProxyConfigOverrides > 0 {
+			nsFindings = append(nsFindings, models.ReadinessFinding{
+				Kind:     "Pod",
+				Category: models.FindingBlocker,
+				Severity: models.SeverityMedium,
+				Count:    nsInfo.SidecarProfiles.ProxyConfigOverrides,
+				Message:  proxyConfigOverridesMessage,
+			})
+		}
+
+		readiness.Namespaces[outName] = &models.NamespaceReadiness{
+			Ready:         !hasFinding(models.FindingBlocker, meshWide, nsFindings),
+			NeedsWaypoint: hasFinding(models.FindingNeedsWaypoint, meshWide, nsFindings),
+			Findings:      nsFindings,
+		}
+	}
+	return readiness, nil
+}
+
+// hasFinding checks if any of the findings is of the given category
+func hasFinding(category string, findings ...[]models.ReadinessFinding) bool {
+	for _, list := range findings {
+		for _, finding := range list {
+			if finding.Category == category {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// namespaces returns every namespace with at least one of the resources
+func (r *meshResources) namespaces() []string {
+	seen := make(map[string]struct{})
+	var namespaces []string
+	add := func(namespace string) {
+		if _, ok := seen[namespace]; !ok {
+			seen[namespace] = struct{}{}
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	for namespace := range r.envoyFilters {
+		add(namespace)
+	}
+	for namespace := range r.proxyConfigs {
+		add(namespace)
+	}
+	for namespace := range r.sidecars {
+		add(namespace)
+	}
+	for namespace := range r.policies {
+		add(namespace)
+	}
+	return namespaces
+}
+
+// findings returns the findings for the resources of a namespace, one per kind and category
+func (r *meshResources) findings(namespace string) []models.ReadinessFinding {
+	var findings []models.ReadinessFinding
+	if count := r.envoyFilters[namespace]; count > 0 {
+		findings = append(findings, models.ReadinessFinding{
+			Kind:     "EnvoyFilter",
+			Category: models.FindingBlocker,
+			Severity: models.SeverityHigh,
+			Count:    count,
+			Message:  envoyFilterMessage,
+		})
+	}
+
+	workloadSidecars, namespaceSidecars := 0, 0
+	for _, sidecar := range r.sidecars[namespace] {
+		if sidecar.HasWorkloadSelector {
+			workloadSidecars++
+		} else {
+			namespaceSidecars++
+		}
+	}
+	if workloadSidecars > 0 {
+		findings = append(findings, models.ReadinessFinding{
+			Kind:     "Sidecar",
+			Category: models.FindingBlocker,
+			Severity: models.SeverityMedium,
+			Count:    workloadSidecars,
+			Message:  workloadSidecarMessage,
+		})
+	}
+	if count := r.proxyConfigs[namespace]; count > 0 {
+		findings = append(findings, models.ReadinessFinding{
+			Kind:     "ProxyConfig",
+			Category: models.FindingBlocker,
+			Severity: models.SeverityMedium,
+			Count:    count,
+			Message:  proxyConfigMessage,
+		})
+	}
+
+	l7Policies := 0
+	for _, policy := range r.policies[namespace] {
+		// policies attached to a gateway or waypoint through a targetRef are already enforced by an L7 proxy
+		if policy.RequiresL7 && !policy.HasTargetRef {
+			l7Policies++
+		}
+	}
+	if l7Policies > 0 {
+		findings = append(findings, models.ReadinessFinding{
+			Kind:     "AuthorizationPolicy",
+			Category: models.FindingNeedsWaypoint,
+			Severity: models.SeverityMedium,
+			Count:    l7Policies,
+			Message:  l7PolicyMessage,
+		})
+	}
+
+	if namespaceSidecars > 0 {
+		findings = append(findings, models.ReadinessFinding{
+			Kind:     "Sidecar",
+			Category: models.FindingWarning,
+			Severity: models.SeverityLow,
+			Count:    namespaceSidecars,
+			Message:  namespaceSidecarMessage,
+		})
+	}
+	return findings
+}
@@ -0,0 +1,158 @@
+//go:build test || unit
+
+package gatherer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/solo-io/istio-usage-collector/internal/utils"
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+	testutils "github.com/solo-io/istio-usage-collector/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestAnalyzeMigrationReadiness(t *testing.T) {
+	ctx := context.Background()
+
+	// loadMesh lists the Sidecar resources into the mesh, as processNamespaces does before the readiness is analyzed
+	loadMesh := func(t *testing.T, client *dynamicfake.FakeDynamicClient) *meshInfo {
+		sidecars, err := utils.ListSidecars(ctx, client)
+		require.NoError(t, err)
+		client.ClearActions()
+		return &meshInfo{rootNamespace: utils.DefaultIstioNamespace, sidecars: sidecars}
+	}
+	l7Rules := map[string]interface{}{
+		"rules": []interface{}{map[string]interface{}{
+			"to": []interface{}{map[string]interface{}{"operation": map[string]interface{}{"paths": []interface{}{"/admin"}}}},
+		}},
+	}
+	workloadSelector := map[string]interface{}{"workloadSelector": map[string]interface{}{"labels": map[string]interface{}{"app": "reviews"}}}
+
+	t.Run("namespace findings", func(t *testing.T) {
+		client := testutils.NewIstioDynamicClient(
+			testutils.NewIstioObject("networking.istio.io/v1alpha3", "EnvoyFilter", "filters", "lua", map[string]interface{}{}),
+			testutils.NewIstioObject("networking.istio.io/v1alpha3", "EnvoyFilter", "filters", "ratelimit", map[string]interface{}{}),
+			testutils.NewIstioObject("networking.istio.io/v1", "Sidecar", "scoped", "default", map[string]interface{}{}),
+			testutils.NewIstioObject("networking.istio.io/v1", "Sidecar", "selected", "reviews", workloadSelector),
+			testutils.NewIstioObject("networking.istio.io/v1beta1", "ProxyConfig", "tuned", "concurrency", map[string]interface{}{}),
+			testutils.NewIstioObject("security.istio.io/v1", "AuthorizationPolicy", "l7", "admin", l7Rules),
+			testutils.NewIstioObject("security.istio.io/v1", "AuthorizationPolicy", "l4", "allow-nothing", map[string]interface{}{}),
+		)
+		clusterInfo := &models.ClusterInfo{Namespaces: map[string]*models.NamespaceInfo{
+			"filters":   {},
+			"scoped":    {},
+			"selected":  {},
+			"tuned":     {},
+			"l7":        {},
+			"l4":        {},
+			"annotated": {SidecarProfiles: &models.SidecarProfiles{Default: 3, ProxyConfigOverrides: 2}},
+			"plain":     {},
+		}}
+
+		readiness, err := analyzeMigrationReadiness(ctx, client, loadMesh(t, client), clusterInfo, &utils.Config{})
+		require.NoError(t, err)
+		assert.Empty(t, readiness.MeshWide)
+		require.Len(t, readiness.Namespaces, 8)
+		// the Sidecar resources of the mesh aren't listed again
+		for _, action := range client.Actions() {
+			assert.NotEqual(t, "sidecars", action.GetResource().Resource)
+		}
+
+		expected := map[string]struct {
+			ready, needsWaypoint bool
+			kind, category       string
+			severity             string
+			count                int
+		}{
+			"filters":   {false, false, "EnvoyFilter", models.FindingBlocker, models.SeverityHigh, 2},
+			"scoped":    {true, false, "Sidecar", models.FindingWarning, models.SeverityLow, 1},
+			"selected":  {false, false, "Sidecar", models.FindingBlocker, models.SeverityMedium, 1},
+			"tuned":     {false, false, "ProxyConfig", models.FindingBlocker, models.SeverityMedium, 1},
+			"l7":        {true, true, "AuthorizationPolicy", models.FindingNeedsWaypoint, models.SeverityMedium, 1},
+			"annotated": {false, false, "Pod", models.FindingBlocker, models.SeverityMedium, 2},
+		}
+		for namespace, want := range expected {
+			nsReadiness := readiness.Namespaces[namespace]
+			require.NotNil(t, nsReadiness, namespace)
+			assert.Equal(t, want.ready, nsReadiness.Ready, namespace)
+			assert.Equal(t, want.needsWaypoint, nsReadiness.NeedsWaypoint, namespace)
+			require.Len(t, nsReadiness.Findings, 1, namespace)
+			finding := nsReadiness.Findings[0]
+			assert.Equal(t, want.kind, finding.Kind, namespace)
+			assert.Equal(t, want.category, finding.Category, namespace)
+			assert.Equal(t, want.severity, finding.Severity, namespace)
+			assert.Equal(t, want.count, finding.Count, namespace)
+			assert.NotEmpty(t, finding.Message, namespace)
+		}
+
+		// namespaces without findings, or only with L4 policies, can move today
+		for _, namespace := range []string{"plain", "l4"} {
+			assert.Equal(t, &models.NamespaceReadiness{Ready: true}, readiness.Namespaces[namespace], namespace)
+		}
+	})
+
+	t.Run("root namespace findings apply to every namespace", func(t *testing.T) {
+		client := testutils.NewIstioDynamicClient(
+			testutils.NewIstioObject("networking.istio.io/v1alpha3", "EnvoyFilter", "istio-system", "stats", map[string]interface{}{}),
+			testutils.NewIstioObject("security.istio.io/v1", "AuthorizationPolicy", "istio-system", "admin", l7Rules),
+		)
+		clusterInfo := &models.ClusterInfo{Namespaces: map[string]*models.NamespaceInfo{
+			"istio-system": {},
+			"app":          {},
+		}}
+
+		readiness, err := analyzeMigrationReadiness(ctx, client, loadMesh(t, client), clusterInfo, &utils.Config{})
+		require.NoError(t, err)
+		require.Len(t, readiness.MeshWide, 2)
+		assert.Equal(t, "EnvoyFilter", readiness.MeshWide[0].Kind)
+		assert.Equal(t, "AuthorizationPolicy", readiness.MeshWide[1].Kind)
+		for _, namespace := range []string{"istio-system", "app"} {
+			assert.Equal(t, &models.NamespaceReadiness{Ready: false, NeedsWaypoint: true}, readiness.Namespaces[namespace], namespace)
+		}
+	})
+
+	t.Run("the root namespace is the one resolved for the mesh", func(t *testing.T) {
+		client := testutils.NewIstioDynamicClient(
+			testutils.NewIstioObject("networking.istio.io/v1alpha3", "EnvoyFilter", "istio-config", "stats", map[string]interface{}{}),
+			testutils.NewIstioObject("networking.istio.io/v1alpha3", "EnvoyFilter", "istio-system", "lua", map[string]interface{}{}),
+		)
+		clusterInfo := &models.ClusterInfo{Namespaces: map[string]*models.NamespaceInfo{
+			"istio-system": {},
+			"app":          {},
+		}}
+		mesh := loadMesh(t, client)
+		mesh.rootNamespace = "istio-config"
+
+		readiness, err := analyzeMigrationReadiness(ctx, client, mesh, clusterInfo, &utils.Config{})
+		require.NoError(t, err)
+		require.Len(t, readiness.MeshWide, 1)
+		assert.Equal(t, 1, readiness.MeshWide[0].Count)
+		// istio-system is an ordinary namespace when it isn't the root namespace
+		require.Len(t, readiness.Namespaces["istio-system"].Findings, 1)
+		assert.Empty(t, readiness.Namespaces["app"].Findings)
+		assert.False(t, readiness.Namespaces["app"].Ready)
+	})
+
+	t.Run("namespace names are hidden", func(t *testing.T) {
+		client := testutils.NewIstioDynamicClient(
+			testutils.NewIstioObject("networking.istio.io/v1alpha3", "EnvoyFilter", "app", "lua", map[string]interface{}{}),
+		)
+		hidden := ObfuscateName("app")
+		clusterInfo := &models.ClusterInfo{Namespaces: map[string]*models.NamespaceInfo{hidden: {}}}
+
+		readiness, err := analyzeMigrationReadiness(ctx, client, loadMesh(t, client), clusterInfo, &utils.Config{ObfuscateNames: true})
+		require.NoError(t, err)
+		require.Contains(t, readiness.Namespaces, hidden)
+		assert.False(t, readiness.Namespaces[hidden].Ready)
+		assert.Len(t, readiness.Namespaces[hidden].Findings, 1)
+	})
+
+	t.Run("the readiness isn't analyzed if the Sidecar resources couldn't be listed", func(t *testing.T) {
+		clusterInfo := &models.ClusterInfo{Namespaces: map[string]*models.NamespaceInfo{"app": {}}}
+		_, err := analyzeMigrationReadiness(ctx, testutils.NewIstioDynamicClient(), &meshInfo{}, clusterInfo, &utils.Config{})
+		assert.ErrorContains(t, err, "Sidecar resources")
+	})
+}
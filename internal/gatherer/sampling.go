@@ -0,0 +1,316 @@
+package gatherer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/solo-io/istio-usage-collector/internal/logging"
+	"github.com/solo-io/istio-usage-collector/internal/utils"
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// metricsSample is the usage of every container and node reported by the metrics API at one point in time
+type metricsSample struct {
+	Time time.Time `json:"time"`
+	// Pods is the usage of each container, keyed by namespace, then pod name, then container name
+	Pods map[string]map[string]map[string]models.Resources `json:"pods"`
+	// Nodes is the usage of each node, keyed by node name
+	Nodes map[string]models.Resources `json:"nodes"`
+}
+
+// metricsSamples are the samples of the metrics API taken over the sampling window. They are saved after each sample
+// (with the real names of the resources, as they are joined to the listed resources) so an interrupted run can continue sampling.
+type metricsSamples struct {
+	// Cluster, Fingerprint and StartedAt identify the collection the samples were taken for, as recorded in its resume state
+	Cluster     string    `json:"cluster"`
+	Fingerprint string    `json:"fingerprint"`
+	StartedAt   time.Time `json:"started_at"`
+	// Interval and Duration are the time between two samples and the sampling window
+	Interval time.Duration   `json:"interval"`
+	Duration time.Duration   `json:"duration"`
+	Samples  []metricsSample `json:"samples"`
+}
+
+// newMetricsSamples creates the samples of a collection, before any sample is taken
+func newMetricsSamples(state *resumeState, cfg *utils.Config) *metricsSamples {
+	return &metricsSamples{
+		Cluster:     state.Cluster,
+		Fingerprint: state.Fingerprint,
+		StartedAt:   state.StartedAt,
+		Interval:    cfg.SampleInterval,
+		Duration:    cfg.SampleDuration,
+	}
+}
+
+// sameCollection returns true if the samples were taken for the same collection and sampling window as the other samples
+func (s *metricsSamples) sameCollection(other *metricsSamples) bool {
+	return s.Cluster == other.Cluster && s.Fingerprint == other.Fingerprint && s.StartedAt.Equal(other.StartedAt) &&
+		s.Interval == other.Interval && s.Duration == other.Duration
+}
+
+// sampleMetrics samples the metrics API every interval over the sampling window for the collection whose state is given.
+// If the collection was resumed, sampling continues from the samples saved in samplesFile if they were taken for it;
+// otherwise they are removed, so samples of another collection are never mixed into this one.
+func sampleMetrics(ctx context.Context, metricsClient metricsv.Interface, samplesFile string, state *resumeState, resumed bool, cfg *utils.Config) (*metricsSamples, error) {
+	total := int(cfg.SampleDuration/cfg.SampleInterval) + 1
+	samples := newMetricsSamples(state, cfg)
+
+	existing, err := loadSamples(samplesFile)
+	switch {
+	case os.IsNotExist(err):
+		logging.Debug("No samples to continue from")
+	case err != nil:
+		logging.Warn("Failed to load existing metrics samples, sampling again: %v", err)
+		removeSamples(samplesFile)
+	case !resumed:
+		logging.Debug("Removing the metrics samples of a previous collection")
+		removeSamples(samplesFile)
+	case !existing.sameCollection(samples):
+		logging.Warn("Existing metrics samples were taken for another collection or sampling window, sampling again")
+		removeSamples(samplesFile)
+	default:
+		samples.Samples = existing.Samples
+		logging.Info("Loaded %d existing metrics samples", len(samples.Samples))
+	}
+
+	var progress *logging.Progress
+	if !cfg.NoProgress {
+		progress = logging.NewProgress("Sampling metrics", total)
+		logging.Info("Taking %d metrics samples every %s", total, cfg.SampleInterval)
+		for range samples.Samples {
+			progress.Increment()
+		}
+	}
+
+	for taken := len(samples.Samples); taken < total; taken++ {
+		// Wait for the interval to pass since the last sample, which may already be the case after an interruption
+		if len(samples.Samples) > 0 {
+			wait := time.Until(samples.Samples[len(samples.Samples)-1].Time.Add(cfg.SampleInterval))
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+
+		sample, err := takeMetricsSample(ctx, metricsClient)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// a failed sample is skipped, the next one is still taken after the interval
+			logging.Warn("Failed to sample metrics (sample %d/%d): %v", taken+1, total, err)
+		} else {
+			samples.Samples = append(samples.Samples, *sample)
+			if err := saveSamples(samples, samplesFile); err != nil {
+				logging.Warn("Failed to save metrics samples, they can't be continued from if interrupted: %v", err)
+			}
+		}
+
+		if progress != nil {
+			progress.Increment()
+		}
+	}
+
+	if progress != nil {
+		progress.Complete()
+	}
+
+	if len(samples.Samples) == 0 {
+		return nil, fmt.Errorf("none of the %d metrics samples could be taken", total)
+	}
+	return samples, nil
+}
+
+// takeMetricsSample gets the usage of the containers of every namespace and of every node
+func takeMetricsSample(ctx context.Context, metricsClient metricsv.Interface) (*metricsSample, error) {
+	podMetrics, err := getMetricsWithRetries(ctx, metricsClient, metav1.NamespaceAll)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod metrics: %w", err)
+	}
+
+	sample := &metricsSample{
+		Time:  time.Now(),
+		Pods:  make(map[string]map[string]map[string]models.Resources),
+		Nodes: make(map[string]models.Resources),
+	}
+	for _, podMetric := range podMetrics.Items {
+		pods := sample.Pods[podMetric.Namespace]
+		if pods == nil {
+			pods = make(map[string]map[string]models.Resources)
+			sample.Pods[podMetric.Namespace] = pods
+		}
+		containers := make(map[string]models.Resources, len(podMetric.Containers))
+		for _, containerMetric := range podMetric.Containers {
+			containers[containerMetric.Name] = usageResources(containerMetric.Usage)
+		}
+		pods[podMetric.Name] = containers
+	}
+
+	// Node metrics are optional, the sample is kept without them if they can't be listed
+	nodeMetrics, err := metricsClient.MetricsV1beta1().NodeMetricses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		logging.Warn("Failed to sample node metrics: %v", err)
+	} else {
+		for _, nodeMetric := range nodeMetrics.Items {
+			sample.Nodes[nodeMetric.Name] = usageResources(nodeMetric.Usage)
+		}
+	}
+
+	return sample, nil
+}
+
+// averagePodMetrics returns the usage of each container of a namespace averaged over all samples, in the shape returned by the metrics API.
+// Containers are counted as not using any resources in the samples they're missing from, so the namespace totals average out the same way.
+func (s *metricsSamples) averagePodMetrics(namespace string) *v1beta1.PodMetricsList {
+	sums := make(map[string]map[string]models.Resources)
+	for _, sample := range s.Samples {
+		for podName, containers := range sample.Pods[namespace] {
+			if sums[podName] == nil {
+				sums[podName] = make(map[string]models.Resources)
+			}
+			for containerName, usage := range containers {
+				sum := sums[podName][containerName]
+				sum.CPU += usage.CPU
+				sum.MemoryGB += usage.MemoryGB
+				sums[podName][containerName] = sum
+			}
+		}
+	}
+
+	count := float64(len(s.Samples))
+	list := &v1beta1.PodMetricsList{}
+	for podName, containers := range sums {
+		podMetric := v1beta1.PodMetrics{ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: namespace}}
+		for containerName, sum := range containers {
+			podMetric.Containers = append(podMetric.Containers, v1beta1.ContainerMetrics{
+				Name: containerName,
+				Usage: corev1.ResourceList{
+					corev1.ResourceCPU:    *resource.NewMilliQuantity(int64(sum.CPU/count*1000), resource.DecimalSI),
+					corev1.ResourceMemory: *resource.NewQuantity(int64(sum.MemoryGB/count*1024*1024*1024), resource.BinarySI),
+				},
+			})
+		}
+		list.Items = append(list.Items, podMetric)
+	}
+	return list
+}
+
+// podStats computes the distribution across samples of the combined usage of each type of container of a namespace.
+// Only the containers of the pods counted in the totals are included, classified exactly like their requests.
+func (s *metricsSamples) podStats(namespace string, podContainers map[string]map[string]containerType) map[containerType]*models.UsageStats {
+	// every type of container counted in the totals has a value in each sample, which is zero if none of its containers were reported
+	usages := make(map[containerType][]models.Resources)
+	for _, containers := range podContainers {
+		for _, usageType := range containers {
+			if usages[usageType] == nil {
+				usages[usageType] = make([]models.Resources, len(s.Samples))
+			}
+		}
+	}
+
+	for i, sample := range s.Samples {
+		for podName, containers := range sample.Pods[namespace] {
+			for containerName, usage := range containers {
+				usageType, ok := podContainers[podName][containerName]
+				if !ok {
+					continue
+				}
+				usages[usageType][i].CPU += usage.CPU
+				usages[usageType][i].MemoryGB += usage.MemoryGB
+			}
+		}
+	}
+
+	stats := make(map[containerType]*models.UsageStats, len(usages))
+	for usageType, values := range usages {
+		stats[usageType] = usageStats(values)
+	}
+	return stats
+}
+
+// nodeUsage returns the average usage of a node and its distribution across the samples it was reported in, nil if it never was
+func (s *metricsSamples) nodeUsage(nodeName string) (*models.NodeResourceSpec, *models.UsageStats) {
+	var values []models.Resources
+	for _, sample := range s.Samples {
+		if usage, ok := sample.Nodes[nodeName]; ok {
+			values = append(values, usage)
+		}
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	stats := usageStats(values)
+	return &models.NodeResourceSpec{CPU: stats.Avg.CPU, MemoryGB: stats.Avg.MemoryGB}, stats
+}
+
+// usageStats computes the minimum, average, maximum and 95th percentile of the usage values
+func usageStats(values []models.Resources) *models.UsageStats {
+	sortedCPU := make([]float64, 0, len(values))
+	sortedMemory := make([]float64, 0, len(values))
+	stats := &models.UsageStats{Samples: len(values)}
+	for _, value := range values {
+		sortedCPU = append(sortedCPU, value.CPU)
+		sortedMemory = append(sortedMemory, value.MemoryGB)
+		stats.Avg.CPU += value.CPU / float64(len(values))
+		stats.Avg.MemoryGB += value.MemoryGB / float64(len(values))
+	}
+
+	sort.Float64s(sortedCPU)
+	sort.Float64s(sortedMemory)
+	stats.Min = models.Resources{CPU: percentile(sortedCPU, 0), MemoryGB: percentile(sortedMemory, 0)}
+	stats.Max = models.Resources{CPU: percentile(sortedCPU, 1), MemoryGB: percentile(sortedMemory, 1)}
+	stats.P95 = models.Resources{CPU: percentile(sortedCPU, 0.95), MemoryGB: percentile(sortedMemory, 0.95)}
+	return stats
+}
+
+// usageResources converts the usage reported by the metrics API to cores and gigabytes
+func usageResources(usage corev1.ResourceList) models.Resources {
+	return models.Resources{
+		CPU:      usage.Cpu().AsApproximateFloat64(),
+		MemoryGB: float64(usage.Memory().Value()) / (1024 * 1024 * 1024),
+	}
+}
+
+// loadSamples loads the samples saved by an interrupted run. The error satisfies os.IsNotExist if there are none.
+func loadSamples(samplesFile string) (*metricsSamples, error) {
+	data, err := os.ReadFile(samplesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := &metricsSamples{}
+	if err := json.Unmarshal(data, samples); err != nil {
+		return nil, fmt.Errorf("failed to parse samples file: %w", err)
+	}
+	return samples, nil
+}
+
+// removeSamples removes the samples file, if there is one
+func removeSamples(samplesFile string) {
+	if err := os.Remove(samplesFile); err != nil && !os.IsNotExist(err) {
+		logging.Warn("Failed to remove metrics samples file %s: %v", samplesFile, err)
+	}
+}
+
+// saveSamples saves the samples taken so far, replacing the previous samples file atomically so an interruption never leaves it truncated
+func saveSamples(samples *metricsSamples, samplesFile string) error {
+	data, err := json.Marshal(samples)
+	if err != nil {
+		return fmt.Errorf("failed to marshal samples: %w", err)
+	}
+	return writeFileAtomically(samplesFile, data)
+}
@@ -0,0 +1,186 @@
+//go:build test || unit
+
+package gatherer
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/solo-io/istio-usage-collector/internal/utils"
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+	testutils "github.com/solo-io/istio-usage-collector/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	v1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metricsfake "k8s.io/metrics/pkg/client/clientset/versioned/fake"
+)
+
+// newSamplingMetricsClient returns a metrics client whose usage grows with each sample, counting the samples taken
+func newSamplingMetricsClient(listed *int) *metricsfake.Clientset {
+	client := metricsfake.NewSimpleClientset()
+	client.PrependReactor("list", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		*listed++
+		return true, &v1beta1.PodMetricsList{Items: []v1beta1.PodMetrics{{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "test-istio"},
+			Containers: []v1beta1.ContainerMetrics{{
+				Name:  "app",
+				Usage: corev1.ResourceList{corev1.ResourceCPU: *resource.NewMilliQuantity(int64(100**listed), resource.DecimalSI)},
+			}},
+		}}}, nil
+	})
+	client.PrependReactor("list", "nodes", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, &v1beta1.NodeMetricsList{Items: []v1beta1.NodeMetrics{{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+			Usage:      corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+		}}}, nil
+	})
+	return client
+}
+
+func TestSampleMetrics(t *testing.T) {
+	ctx := context.Background()
+	samplesFile := filepath.Join(t.TempDir(), "cluster.samples.json")
+	cfg := &utils.Config{SampleDuration: 2 * time.Millisecond, SampleInterval: time.Millisecond, NoProgress: true}
+	state := newResumeState("cluster", cfg)
+
+	listed := 0
+	samples, err := sampleMetrics(ctx, newSamplingMetricsClient(&listed), samplesFile, state, false, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, 3, listed)
+	require.Len(t, samples.Samples, 3)
+	assert.Equal(t, 0.3, samples.Samples[2].Pods["test-istio"]["web-1"]["app"].CPU)
+	assert.Equal(t, 1.0, samples.Samples[2].Nodes["node-a"].CPU)
+
+	// the samples are saved after each sample, along with the collection they were taken for
+	saved, err := loadSamples(samplesFile)
+	require.NoError(t, err)
+	assert.Len(t, saved.Samples, 3)
+	assert.Equal(t, state.Fingerprint, saved.Fingerprint)
+	assert.True(t, saved.StartedAt.Equal(state.StartedAt))
+
+	// resumeSampling saves the first of the samples, then samples again for the collection
+	resumeSampling := func(t *testing.T, existing *metricsSamples, state *resumeState, resumed bool, cfg *utils.Config) int {
+		t.Helper()
+		existing.Samples = saved.Samples[:1]
+		require.NoError(t, saveSamples(existing, samplesFile))
+
+		listed := 0
+		samples, err := sampleMetrics(ctx, newSamplingMetricsClient(&listed), samplesFile, state, resumed, cfg)
+		require.NoError(t, err)
+		assert.Len(t, samples.Samples, int(cfg.SampleDuration/cfg.SampleInterval)+1)
+		return listed
+	}
+
+	t.Run("Sampling continues from the samples of the resumed collection", func(t *testing.T) {
+		existing := *saved
+		assert.Equal(t, 2, resumeSampling(t, &existing, state, true, cfg))
+	})
+
+	t.Run("Samples are discarded if the collection wasn't resumed", func(t *testing.T) {
+		existing := *saved
+		assert.Equal(t, 3, resumeSampling(t, &existing, newResumeState("cluster", cfg), false, cfg))
+	})
+
+	t.Run("Samples of another collection are discarded", func(t *testing.T) {
+		existing := *saved
+		existing.StartedAt = existing.StartedAt.Add(-time.Hour)
+		assert.Equal(t, 3, resumeSampling(t, &existing, state, true, cfg))
+	})
+
+	t.Run("Samples taken with other flags are discarded", func(t *testing.T) {
+		existing := *saved
+		existing.Fingerprint = "other"
+		assert.Equal(t, 3, resumeSampling(t, &existing, state, true, cfg))
+	})
+
+	t.Run("Samples taken at another interval or over another window are discarded", func(t *testing.T) {
+		existing := *saved
+		existing.Interval = time.Hour
+		assert.Equal(t, 3, resumeSampling(t, &existing, state, true, cfg))
+
+		existing = *saved
+		longerCfg := *cfg
+		longerCfg.SampleDuration = 3 * time.Millisecond
+		assert.Equal(t, 4, resumeSampling(t, &existing, state, true, &longerCfg))
+	})
+
+	t.Run("Sampling stops when cancelled", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		listed := 0
+		longCfg := &utils.Config{SampleDuration: time.Hour, SampleInterval: time.Minute, NoProgress: true}
+		_, err := sampleMetrics(cancelled, newSamplingMetricsClient(&listed), filepath.Join(t.TempDir(), "cancelled.samples.json"), newResumeState("cluster", longCfg), false, longCfg)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestProcessNamespaceSampledUsage(t *testing.T) {
+	ctx := context.Background()
+	fakeClient := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-istio", Labels: map[string]string{"istio-injection": "enabled"}}},
+		testutils.NewPod("test-istio", "web-1", "node-a", "100m", "128Mi", true, "100m", "128Mi", map[string]string{}),
+	)
+
+	// web-1's istio-proxy is missing from the first sample, and web-0 was deleted before the pods were listed
+	samples := &metricsSamples{Interval: time.Minute, Samples: []metricsSample{
+		{Pods: map[string]map[string]map[string]models.Resources{"test-istio": {
+			"web-1": {"app": {CPU: 0.2, MemoryGB: 1}},
+			"web-0": {"app": {CPU: 5, MemoryGB: 5}},
+		}}},
+		{Pods: map[string]map[string]map[string]models.Resources{"test-istio": {
+			"web-1": {"app": {CPU: 0.4, MemoryGB: 1}, "istio-proxy": {CPU: 0.02, MemoryGB: 0.5}},
+		}}},
+	}}
+	mesh := &meshInfo{webhooks: loadDefaultIstioWebhooks(t), samples: samples}
+
+	// the sampled usage is used even though the metrics API isn't read again
+	nsInfo, err := processNamespace(ctx, fakeClient, metricsfake.NewSimpleClientset(), "test-istio", false, mesh, &utils.Config{})
+	require.NoError(t, err)
+
+	require.NotNil(t, nsInfo.Resources.Regular.Actual)
+	assert.InDelta(t, 0.3, nsInfo.Resources.Regular.Actual.CPU, 0.001)
+	assert.InDelta(t, 1, nsInfo.Resources.Regular.Actual.MemoryGB, 0.001)
+	assert.Equal(t, 1, nsInfo.UnmatchedPodMetrics)
+
+	sampled := nsInfo.Resources.Regular.Sampled
+	require.NotNil(t, sampled)
+	assert.Equal(t, 2, sampled.Samples)
+	assert.Equal(t, models.Resources{CPU: 0.2, MemoryGB: 1}, sampled.Min)
+	assert.InDelta(t, 0.3, sampled.Avg.CPU, 0.0001)
+	assert.Equal(t, models.Resources{CPU: 0.4, MemoryGB: 1}, sampled.Max)
+	assert.Equal(t, models.Resources{CPU: 0.4, MemoryGB: 1}, sampled.P95)
+
+	require.NotNil(t, nsInfo.Resources.Istio)
+	require.NotNil(t, nsInfo.Resources.Istio.Sampled)
+	assert.Zero(t, nsInfo.Resources.Istio.Sampled.Min.CPU)
+	assert.InDelta(t, 0.01, nsInfo.Resources.Istio.Sampled.Avg.CPU, 0.0001)
+	assert.InDelta(t, 0.02, nsInfo.Resources.Istio.Sampled.Max.CPU, 0.0001)
+	assert.InDelta(t, 0.01, nsInfo.Resources.Istio.Actual.CPU, 0.001)
+}
+
+func TestSampledNodeUsage(t *testing.T) {
+	samples := &metricsSamples{Samples: []metricsSample{
+		{Nodes: map[string]models.Resources{"node-a": {CPU: 1, MemoryGB: 4}}},
+		{Nodes: map[string]models.Resources{}},
+		{Nodes: map[string]models.Resources{"node-a": {CPU: 3, MemoryGB: 6}}},
+	}}
+
+	// samples missing the node are left out rather than counted as idle
+	actual, stats := samples.nodeUsage("node-a")
+	assert.Equal(t, &models.NodeResourceSpec{CPU: 2, MemoryGB: 5}, actual)
+	assert.Equal(t, 2, stats.Samples)
+	assert.Equal(t, models.Resources{CPU: 1, MemoryGB: 4}, stats.Min)
+	assert.Equal(t, models.Resources{CPU: 3, MemoryGB: 6}, stats.Max)
+
+	actual, stats = samples.nodeUsage("node-b")
+	assert.Nil(t, actual)
+	assert.Nil(t, stats)
+}
@@ -0,0 +1,256 @@
+package gatherer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/solo-io/istio-usage-collector/internal/logging"
+	"github.com/solo-io/istio-usage-collector/internal/utils"
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+)
+
+// resumeState is the progress of a collection, saved next to the output file along with each checkpoint so an interrupted
+// collection can be continued. Namespaces and nodes are recorded by their output names.
+type resumeState struct {
+	// Cluster is the output name of the cluster
+	Cluster string `json:"cluster"`
+	// Fingerprint identifies the flags affecting the output, which must be the same to continue
+	Fingerprint string `json:"fingerprint"`
+	// Flags are the fingerprinted flags, kept to explain which ones changed
+	Flags map[string]string `json:"flags"`
+
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	CompletedNamespaces []string `json:"completed_namespaces"`
+	FailedNamespaces    []string `json:"failed_namespaces,omitempty"`
+	CompletedNodes      []string `json:"completed_nodes"`
+	FailedNodes         []string `json:"failed_nodes,omitempty"`
+
+	// NodeTotals are the pods of each completed namespace aggregated by node, keyed by namespace then node, from which the
+	// densities of the nodes are restored when the completed namespaces are skipped
+	NodeTotals map[string]map[string]savedNodeTotals `json:"node_totals,omitempty"`
+}
+
+// Kinds of the resources whose progress is recorded
+const (
+	stateKindNamespace = "namespace"
+	stateKindNode      = "node"
+)
+
+// newResumeState creates the state of a collection starting now
+func newResumeState(cluster string, cfg *utils.Config) *resumeState {
+	flags := stateFlags(cfg)
+	return &resumeState{
+		Cluster:     cluster,
+		Fingerprint: fingerprint(flags),
+		Flags:       flags,
+		StartedAt:   time.Now().UTC(),
+	}
+}
+
+// stateFlags returns the flags affecting the output, along with the version of the collector, keyed by flag name.
+// Credentials are left out, and removed from the Prometheus URL.
+func stateFlags(cfg *utils.Config) map[string]string {
+	prometheusURL := utils.RedactedURL(cfg.Prometheus.URL)
+	flags := map[string]string{
+		"format":                   cfg.OutputFormat,
+		"hide-names":               strconv.FormatBool(cfg.ObfuscateNames),
+		"include-non-running-pods": strconv.FormatBool(cfg.IncludeNonRunningPods),
+		"workloads":                strconv.FormatBool(cfg.IncludeWorkloads),
+		"prometheus-url":           prometheusURL,
+		"sample-duration":          cfg.SampleDuration.String(),
+		"sample-interval":          cfg.SampleInterval.String(),
+		"envoy-stats":              strconv.FormatBool(cfg.CollectEnvoyStats),
+		"version":                  cfg.CollectorVersion,
+	}
+	if prometheusURL != "" {
+		flags["prometheus-lookback"] = cfg.Prometheus.Lookback.String()
+	}
+	return flags
+}
+
+// fingerprint hashes the flags in a stable order
+func fingerprint(flags map[string]string) string {
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(hash, "%s=%s\n", name, flags[name])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// check returns an error if the state can't be continued with the cluster and flags of this collection
+func (s *resumeState) check(cluster string, cfg *utils.Config) error {
+	if s.Cluster != cluster {
+		return fmt.Errorf("the interrupted collection is from a different cluster, or --hide-names was changed")
+	}
+
+	flags := stateFlags(cfg)
+	if s.Fingerprint == fingerprint(flags) {
+		return nil
+	}
+	var changed []string
+	for name, value := range flags {
+		if s.Flags[name] != value {
+			changed = append(changed, fmt.Sprintf("%s (%q, was %q)", name, value, s.Flags[name]))
+		}
+	}
+	for name := range s.Flags {
+		if _, ok := flags[name]; !ok {
+			changed = append(changed, fmt.Sprintf("%s (unset, was %q)", name, s.Flags[name]))
+		}
+	}
+	sort.Strings(changed)
+	return fmt.Errorf("the interrupted collection used different flags: %s", strings.Join(changed, ", "))
+}
+
+// recordFailure records that a namespace or node failed, so it's reported as such until it completes
+func (s *resumeState) recordFailure(kind, name string) {
+	switch kind {
+	case stateKindNamespace:
+		s.FailedNamespaces = appendUnique(s.FailedNamespaces, name)
+	case stateKindNode:
+		s.FailedNodes = appendUnique(s.FailedNodes, name)
+	}
+}
+
+// snapshot records the namespaces and nodes of the cluster info as completed, removing them from the failed ones,
+// along with the totals the completed namespaces added to the node densities
+func (s *resumeState) snapshot(clusterInfo *models.ClusterInfo, densities *nodeDensities) {
+	s.CompletedNamespaces = sortedKeys(clusterInfo.Namespaces)
+	s.NodeTotals = densities.save(clusterInfo.Namespaces)
+	s.CompletedNodes = sortedKeys(clusterInfo.Nodes)
+	s.FailedNamespaces = withoutKeys(s.FailedNamespaces, clusterInfo.Namespaces)
+	s.FailedNodes = withoutKeys(s.FailedNodes, clusterInfo.Nodes)
+	s.UpdatedAt = time.Now().UTC()
+}
+
+// completedOnly removes the namespaces and nodes of the cluster info which the state doesn't record as completed,
+// as the output may have been saved after the state was. Collection errors are removed as well, as the namespaces and
+// nodes which couldn't be collected are retried.
+func (s *resumeState) completedOnly(clusterInfo *models.ClusterInfo) {
+	clusterInfo.CollectionErrors = nil
+
+	completedNamespaces := make(map[string]struct{}, len(s.CompletedNamespaces))
+	for _, name := range s.CompletedNamespaces {
+		completedNamespaces[name] = struct{}{}
+	}
+	for name := range clusterInfo.Namespaces {
+		if _, ok := completedNamespaces[name]; !ok {
+			delete(clusterInfo.Namespaces, name)
+		}
+	}
+
+	completedNodes := make(map[string]struct{}, len(s.CompletedNodes))
+	for _, name := range s.CompletedNodes {
+		completedNodes[name] = struct{}{}
+	}
+	for name := range clusterInfo.Nodes {
+		if _, ok := completedNodes[name]; !ok {
+			delete(clusterInfo.Nodes, name)
+		}
+	}
+}
+
+// removeStale removes the namespaces or nodes processed by an interrupted collection which no longer exist in the cluster
+func removeStale[V any](kind string, processed map[string]V, existing map[string]struct{}) {
+	for name := range processed {
+		if _, ok := existing[name]; !ok {
+			logging.Warn("The interrupted collection processed %s %s, which no longer exists, removing it", kind, name)
+			delete(processed, name)
+		}
+	}
+}
+
+// resumeCollection loads the state and output of an interrupted collection to continue from. If there is no state,
+// the collection starts fresh; if the state can't be continued with this collection's cluster and flags, or its output
+// can't be loaded, an error is returned so the interrupted collection is never discarded.
+func resumeCollection(outputFile, stateFile, cluster string, cfg *utils.Config) (*models.ClusterInfo, *resumeState, error) {
+	state, err := loadResumeState(stateFile)
+	if os.IsNotExist(err) {
+		logging.Warn("No interrupted collection found to continue from (%s doesn't exist). Starting fresh.", stateFile)
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load the state of the interrupted collection, delete %s to start fresh: %w", stateFile, err)
+	}
+	if err := state.check(cluster, cfg); err != nil {
+		return nil, nil, fmt.Errorf("can't continue from %s, delete it or run the collection without --continue: %w", stateFile, err)
+	}
+
+	logging.Info("Continuing from existing data file %s", outputFile)
+	clusterInfo, err := loadExistingData(outputFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't continue from %s, delete %s or run the collection without --continue to start fresh: %w", outputFile, stateFile, err)
+	}
+	state.completedOnly(clusterInfo)
+	logging.Info("Loaded existing data with %d namespaces and %d nodes, started at %s", len(clusterInfo.Namespaces), len(clusterInfo.Nodes), state.StartedAt.Format(time.RFC3339))
+	return clusterInfo, state, nil
+}
+
+// loadResumeState loads the state of an interrupted collection
+func loadResumeState(stateFile string) (*resumeState, error) {
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &resumeState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+	return state, nil
+}
+
+// saveResumeState saves the state of the collection, replacing the previous state file atomically
+func saveResumeState(state *resumeState, stateFile string) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+	return writeFileAtomically(stateFile, data)
+}
+
+// appendUnique appends the value if it isn't in the values yet
+func appendUnique(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+// sortedKeys returns the keys of a map in ascending order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// withoutKeys returns the values which aren't keys of the map
+func withoutKeys[V any](values []string, m map[string]V) []string {
+	var remaining []string
+	for _, value := range values {
+		if _, ok := m[value]; !ok {
+			remaining = append(remaining, value)
+		}
+	}
+	return remaining
+}
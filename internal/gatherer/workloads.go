@@ -0,0 +1,99 @@
+package gatherer
+
+import (
+	"context"
+	"strings"
+
+	"github.com/solo-io/istio-usage-collector/internal/logging"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// workloadKey identifies a workload by the kind and name of its top-level controller
+type workloadKey struct {
+	kind string
+	name string
+}
+
+// workloadTotals accumulates the pods and resources of a workload
+type workloadTotals struct {
+	replicas          int
+	isIstioInjected   bool
+	isAmbientEnrolled bool
+	totals            resourceTotals
+}
+
+// ownerResolver resolves pods to their top-level controller within a namespace.
+// Pods owned by a ReplicaSet or Job are resolved to the controller of that ReplicaSet (Deployment) or Job (CronJob), if any.
+type ownerResolver struct {
+	// controllers are the controllers of the namespace's ReplicaSets and Jobs, keyed by the ReplicaSet or Job
+	controllers map[workloadKey]workloadKey
+}
+
+// newOwnerResolver lists the ReplicaSets and Jobs of a namespace to resolve their controllers.
+// If either can't be listed, pods are resolved to the ReplicaSet or Job which owns them instead.
+func newOwnerResolver(ctx context.Context, clientset kubernetes.Interface, namespace string) *ownerResolver {
+	resolver := &ownerResolver{controllers: make(map[workloadKey]workloadKey)}
+
+	replicaSets, err := clientset.AppsV1().ReplicaSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		logging.Warn("Failed to list replicasets in namespace %s, pods will be grouped by replicaset instead of deployment: %v", namespace, err)
+	} else {
+		for _, replicaSet := range replicaSets.Items {
+			resolver.addController("ReplicaSet", &replicaSet.ObjectMeta)
+		}
+	}
+
+	jobs, err := clientset.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		logging.Warn("Failed to list jobs in namespace %s, pods will be grouped by job instead of cronjob: %v", namespace, err)
+	} else {
+		for _, job := range jobs.Items {
+			resolver.addController("Job", &job.ObjectMeta)
+		}
+	}
+
+	return resolver
+}
+
+// addController records the controller of an object, if it has one
+func (r *ownerResolver) addController(kind string, object *metav1.ObjectMeta) {
+	if controller := metav1.GetControllerOfNoCopy(object); controller != nil {
+		r.controllers[workloadKey{kind: kind, name: object.Name}] = workloadKey{kind: controller.Kind, name: controller.Name}
+	}
+}
+
+// resolve returns the top-level controller of a pod, or the pod itself if it has no controller
+func (r *ownerResolver) resolve(pod *corev1.Pod) workloadKey {
+	controller := metav1.GetControllerOfNoCopy(pod)
+	if controller == nil {
+		return workloadKey{kind: "Pod", name: pod.Name}
+	}
+
+	owner := workloadKey{kind: controller.Kind, name: controller.Name}
+	if parent, ok := r.controllers[owner]; ok {
+		return parent
+	}
+	return owner
+}
+
+// podWorkload returns the top-level controller of a pod, resolved through the owner resolver if there is one.
+// Otherwise, or if its ReplicaSets couldn't be listed, a pod owned by a ReplicaSet is resolved to the Deployment the
+// ReplicaSet is named after, which is the ReplicaSet's name without the pod template hash.
+func podWorkload(pod *corev1.Pod, owners *ownerResolver) workloadKey {
+	key := workloadKey{kind: "Pod", name: pod.Name}
+	if owners != nil {
+		key = owners.resolve(pod)
+	} else if controller := metav1.GetControllerOfNoCopy(pod); controller != nil {
+		key = workloadKey{kind: controller.Kind, name: controller.Name}
+	}
+
+	if hash := pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey]; key.kind == "ReplicaSet" && hash != "" {
+		if name, ok := strings.CutSuffix(key.name, "-"+hash); ok {
+			return workloadKey{kind: "Deployment", name: name}
+		}
+	}
+	return key
+}
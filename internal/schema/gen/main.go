@@ -0,0 +1,30 @@
+// Command gen writes the JSON Schema of the report, generated from the types of pkg/models and their doc comments.
+// It's run through go generate from pkg/models.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/solo-io/istio-usage-collector/internal/schema"
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+)
+
+func main() {
+	source := flag.String("source", ".", "Directory of the Go source files of pkg/models, read for their doc comments.")
+	output := flag.String("output", "cluster_info.schema.json", "File to write the schema to.")
+	flag.Parse()
+
+	comments, err := schema.Comments(*source)
+	if err != nil {
+		log.Fatalf("failed to read doc comments: %v", err)
+	}
+	data, err := schema.Generate(models.ClusterInfo{}, comments)
+	if err != nil {
+		log.Fatalf("failed to generate schema: %v", err)
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		log.Fatalf("failed to write schema: %v", err)
+	}
+}
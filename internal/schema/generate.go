@@ -0,0 +1,229 @@
+package schema
+
+// Generates the JSON Schema of the report from the Go types it's encoded from, so the schema can't drift from the report:
+// https://json-schema.org/draft/2020-12/json-schema-core
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// draft is the version of JSON Schema the generated schemas conform to
+const draft = "https://json-schema.org/draft/2020-12/schema"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// generator builds the schema of a type, defining each struct type it references once under $defs
+type generator struct {
+	// comments are the doc comments of the struct types and their fields, keyed by "Type" and "Type.Field"
+	comments map[string]string
+	defs     map[string]any
+	err      error
+}
+
+// Generate returns the JSON Schema of the JSON encoding of v, which must be a struct. Struct types are defined under $defs
+// and described with their doc comments, keyed by "Type" and "Type.Field" as returned by Comments.
+// All numbers are non-negative, as the report only contains counts, resources and durations.
+func Generate(v any, comments map[string]string) ([]byte, error) {
+	t := reflect.TypeOf(v)
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("can't generate the schema of %s, it must be a struct", t)
+	}
+
+	g := &generator{comments: comments, defs: make(map[string]any)}
+	root := g.structSchema(t)
+	if g.err != nil {
+		return nil, g.err
+	}
+	root["$schema"] = draft
+	root["title"] = t.Name()
+	root["$defs"] = g.defs
+
+	data, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal schema: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// typeSchema returns the schema of a type, referencing the definition of struct types
+func (g *generator) typeSchema(t reflect.Type) map[string]any {
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.typeSchema(t.Elem())
+	case reflect.Struct:
+		if _, ok := g.defs[t.Name()]; !ok {
+			// the definition is reserved before it's built, so recursive types reference it rather than building it again
+			g.defs[t.Name()] = nil
+			g.defs[t.Name()] = g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			g.fail(fmt.Errorf("unsupported map key type %s", t.Key()))
+		}
+		return map[string]any{"type": "object", "additionalProperties": g.typeSchema(t.Elem())}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": g.typeSchema(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number", "minimum": 0}
+	default:
+		g.fail(fmt.Errorf("unsupported type %s", t))
+		return map[string]any{}
+	}
+}
+
+// structSchema returns the schema of a struct type. Fields without omitempty are required, and may be null if they're
+// maps, slices or pointers, as that's how encoding/json encodes them when they're nil.
+func (g *generator) structSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if field.Anonymous {
+			g.fail(fmt.Errorf("unsupported embedded field %s.%s", t.Name(), field.Name))
+			continue
+		}
+		name, omitEmpty := jsonName(field)
+		if name == "-" {
+			continue
+		}
+
+		property := g.typeSchema(field.Type)
+		if !omitEmpty {
+			required = append(required, name)
+			switch field.Type.Kind() {
+			case reflect.Map, reflect.Slice, reflect.Pointer:
+				property = nullable(property)
+			}
+		}
+		if comment := g.comments[t.Name()+"."+field.Name]; comment != "" {
+			property["description"] = comment
+		}
+		properties[name] = property
+	}
+
+	schema := map[string]any{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	if comment := g.comments[t.Name()]; comment != "" {
+		schema["description"] = comment
+	}
+	return schema
+}
+
+// fail records the first error generating the schema
+func (g *generator) fail(err error) {
+	if g.err == nil {
+		g.err = err
+	}
+}
+
+// nullable allows null in addition to the values allowed by the schema
+func nullable(schema map[string]any) map[string]any {
+	if typeName, ok := schema["type"].(string); ok {
+		schema["type"] = []string{typeName, "null"}
+		return schema
+	}
+	return map[string]any{"anyOf": []any{schema, map[string]any{"type": "null"}}}
+}
+
+// jsonName returns the name of a field in its JSON encoding, and whether it's omitted when empty
+func jsonName(field reflect.StructField) (string, bool) {
+	tag, ok := field.Tag.Lookup("json")
+	if !ok {
+		return field.Name, false
+	}
+	name, options, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name, strings.Contains(","+options+",", ",omitempty,")
+}
+
+// Comments parses the Go source files of the package in a directory, returning the doc comments of its struct types and
+// their fields keyed by "Type" and "Type.Field". Comments are joined into a single line.
+func Comments(dir string) (map[string]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	comments := make(map[string]string)
+	fileSet := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		source, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := parser.ParseFile(fileSet, file, source, parser.ParseComments)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+
+		for _, decl := range parsed.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range genDecl.Specs {
+				typeSpec := spec.(*ast.TypeSpec)
+				structType, ok := typeSpec.Type.(*ast.StructType)
+				if !ok {
+					continue
+				}
+				// the doc comment of a type declared on its own is the declaration's
+				doc := typeSpec.Doc
+				if doc == nil && len(genDecl.Specs) == 1 {
+					doc = genDecl.Doc
+				}
+				addComment(comments, typeSpec.Name.Name, doc)
+				for _, field := range structType.Fields.List {
+					for _, name := range field.Names {
+						addComment(comments, typeSpec.Name.Name+"."+name.Name, field.Doc)
+					}
+				}
+			}
+		}
+	}
+	return comments, nil
+}
+
+// addComment records a doc comment joined into a single line, if there is one
+func addComment(comments map[string]string, key string, doc *ast.CommentGroup) {
+	if doc == nil {
+		return
+	}
+	if text := strings.Join(strings.Fields(doc.Text()), " "); text != "" {
+		comments[key] = text
+	}
+}
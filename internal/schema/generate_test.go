@@ -0,0 +1,65 @@
+//go:build test || unit
+
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbeddedSchemaIsUpToDate(t *testing.T) {
+	comments, err := Comments("../../pkg/models")
+	require.NoError(t, err)
+	generated, err := Generate(models.ClusterInfo{}, comments)
+	require.NoError(t, err)
+	assert.Equal(t, string(generated), string(models.Schema), "the schema is out of date, run go generate ./pkg/models")
+}
+
+type testReport struct {
+	Name     string            `json:"name"`
+	Count    int               `json:"count"`
+	Labels   map[string]string `json:"labels"`
+	Started  time.Time         `json:"started,omitempty"`
+	Item     *testItem         `json:"item"`
+	Items    []testItem        `json:"items,omitempty"`
+	internal string
+}
+
+type testItem struct {
+	CPU float64 `json:"cpu"`
+}
+
+func TestGenerate(t *testing.T) {
+	data, err := Generate(testReport{}, map[string]string{"testReport": "A test report", "testItem.CPU": "The CPU"})
+	require.NoError(t, err)
+
+	var schema map[string]any
+	require.NoError(t, json.Unmarshal(data, &schema))
+	assert.Equal(t, draft, schema["$schema"])
+	assert.Equal(t, "A test report", schema["description"])
+	// fields with omitempty aren't required, and the others may be null if encoding/json encodes them as null
+	assert.Equal(t, []any{"name", "count", "labels", "item"}, schema["required"])
+
+	properties := schema["properties"].(map[string]any)
+	assert.NotContains(t, properties, "internal")
+	assert.Equal(t, map[string]any{"type": "integer", "minimum": 0.0}, properties["count"])
+	assert.Equal(t, map[string]any{"type": []any{"object", "null"}, "additionalProperties": map[string]any{"type": "string"}}, properties["labels"])
+	assert.Equal(t, map[string]any{"type": "string", "format": "date-time"}, properties["started"])
+	assert.Equal(t, map[string]any{"anyOf": []any{map[string]any{"$ref": "#/$defs/testItem"}, map[string]any{"type": "null"}}}, properties["item"])
+	assert.Equal(t, map[string]any{"type": "array", "items": map[string]any{"$ref": "#/$defs/testItem"}}, properties["items"])
+
+	defs := schema["$defs"].(map[string]any)
+	assert.Equal(t, map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"cpu": map[string]any{"type": "number", "minimum": 0.0, "description": "The CPU"}},
+		"required":   []any{"cpu"},
+	}, defs["testItem"])
+
+	_, err = Generate(map[string]int{}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,121 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+	"gopkg.in/yaml.v3"
+)
+
+// ValidateFile checks a report saved by the collector, in JSON or YAML depending on its extension, against the schema of
+// this version of the collector. Reports matching the schema are then checked for consistency. The problems found are
+// returned, or an error if the report can't be read.
+func ValidateFile(fileName string) ([]Problem, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	// YAML reports are converted to JSON, which the schema describes
+	switch fileExt := filepath.Ext(fileName); fileExt {
+	case ".json":
+	case ".yaml", ".yml":
+		var document any
+		if err := yaml.Unmarshal(data, &document); err != nil {
+			return nil, fmt.Errorf("failed to parse file: %w", err)
+		}
+		data, err = json.Marshal(document)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert YAML to JSON: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported file extension: %s", fileExt)
+	}
+
+	var document any
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse file: %w", err)
+	}
+	problems, err := Validate(models.Schema, document)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		return problems, nil
+	}
+
+	var clusterInfo models.ClusterInfo
+	if err := json.Unmarshal(data, &clusterInfo); err != nil {
+		return nil, fmt.Errorf("failed to parse file: %w", err)
+	}
+	return CheckConsistency(&clusterInfo), nil
+}
+
+// CheckConsistency returns the problems of a report which matches the schema, but whose values contradict each other
+// or which was written with another schema version
+func CheckConsistency(clusterInfo *models.ClusterInfo) []Problem {
+	var problems []Problem
+	fail := func(path, format string, args ...any) {
+		problems = append(problems, Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if clusterInfo.Metadata == nil {
+		fail("$", "missing metadata, the report was written by an older version of the collector")
+	} else if clusterInfo.Metadata.SchemaVersion != models.SchemaVersion {
+		fail("$.metadata.schema_version", "unsupported schema version %d, expected %d", clusterInfo.Metadata.SchemaVersion, models.SchemaVersion)
+	}
+
+	// Each pod counted in the totals has at most one sidecar
+	for _, name := range sortedKeys(clusterInfo.Namespaces) {
+		nsInfo := clusterInfo.Namespaces[name]
+		if nsInfo == nil {
+			continue
+		}
+		path := "$.namespaces[" + strconv.Quote(name) + "]"
+		if istio := nsInfo.Resources.Istio; istio != nil && istio.Containers > nsInfo.Pods {
+			fail(path+".resources.istio.containers", "%d istio containers is more than the %d pods of the namespace", istio.Containers, nsInfo.Pods)
+		}
+		for _, workloadName := range sortedKeys(nsInfo.Workloads) {
+			workload := nsInfo.Workloads[workloadName]
+			if workload == nil {
+				continue
+			}
+			if istio := workload.Resources.Istio; istio != nil && istio.Containers > workload.Replicas {
+				fail(path+".workloads["+strconv.Quote(workloadName)+"].resources.istio.containers",
+					"%d istio containers is more than the %d replicas of the workload", istio.Containers, workload.Replicas)
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(clusterInfo.Nodes) {
+		density := clusterInfo.Nodes[name].Density
+		if density == nil {
+			continue
+		}
+		path := "$.nodes[" + strconv.Quote(name) + "].density"
+		if density.MeshedPods > density.Pods {
+			fail(path+".meshed_pods", "%d meshed pods is more than the %d pods of the node", density.MeshedPods, density.Pods)
+		}
+		if density.Sidecars.Containers > density.Pods {
+			fail(path+".sidecars.containers", "%d sidecars is more than the %d pods of the node", density.Sidecars.Containers, density.Pods)
+		}
+	}
+
+	sortProblems(problems)
+	return problems
+}
+
+// sortedKeys returns the keys of a map in ascending order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
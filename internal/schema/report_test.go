@@ -0,0 +1,121 @@
+//go:build test || unit
+
+package schema
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/solo-io/istio-usage-collector/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// newReport returns a report as the collector writes it
+func newReport() *models.ClusterInfo {
+	clusterInfo := models.NewClusterInfo()
+	clusterInfo.Name = "cluster"
+	clusterInfo.Metadata.StartedAt = time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC)
+	clusterInfo.Namespaces["default"] = &models.NamespaceInfo{
+		Pods:            2,
+		IsIstioInjected: true,
+		Resources: models.ResourceInfo{
+			Regular: models.ContainerResources{Containers: 2, Request: models.Resources{CPU: 0.2, MemoryGB: 0.5}},
+			Istio:   &models.ContainerResources{Containers: 2, Request: models.Resources{CPU: 0.2, MemoryGB: 0.25}},
+		},
+	}
+	clusterInfo.Nodes["node-a"] = models.NodeInfo{
+		InstanceType: "m5.large",
+		Resources:    models.NodeResources{Capacity: models.NodeResourceSpec{CPU: 2, MemoryGB: 8}},
+		Density:      &models.NodeDensity{Pods: 2, MeshedPods: 2, Sidecars: models.ContainerResources{Containers: 2}},
+	}
+	return clusterInfo
+}
+
+// writeReport writes a report to a file, in JSON or YAML depending on its extension
+func writeReport(t *testing.T, clusterInfo *models.ClusterInfo, fileName string) string {
+	var data []byte
+	var err error
+	if filepath.Ext(fileName) == ".json" {
+		data, err = json.MarshalIndent(clusterInfo, "", "  ")
+	} else {
+		data, err = yaml.Marshal(clusterInfo)
+	}
+	require.NoError(t, err)
+	fileName = filepath.Join(t.TempDir(), fileName)
+	require.NoError(t, os.WriteFile(fileName, data, 0644))
+	return fileName
+}
+
+func TestValidateFile(t *testing.T) {
+	t.Run("Reports written by the collector are valid", func(t *testing.T) {
+		for _, fileName := range []string{"cluster.json", "cluster.yaml"} {
+			problems, err := ValidateFile(writeReport(t, newReport(), fileName))
+			require.NoError(t, err)
+			assert.Empty(t, problems, fileName)
+		}
+	})
+
+	t.Run("Reports not matching the schema are invalid", func(t *testing.T) {
+		fileName := filepath.Join(t.TempDir(), "cluster.json")
+		require.NoError(t, os.WriteFile(fileName, []byte(`{
+  "metadata": {"schema_version": 1, "started_at": "yesterday"},
+  "name": "cluster",
+  "namespaces": {"default": {"pods": 1.5, "is_istio_injected": "yes"}},
+  "nodes": {"node-a": {"resources": {"capacity": {"cpu": -2, "memory_gb": 8}}}},
+  "has_metrics": true
+}`), 0644))
+
+		problems, err := ValidateFile(fileName)
+		require.NoError(t, err)
+		var messages []string
+		for _, problem := range problems {
+			messages = append(messages, problem.String())
+		}
+		assert.Contains(t, messages, `$.metadata.started_at: "yesterday" isn't a date-time`)
+		assert.Contains(t, messages, `$.namespaces["default"].pods: expected integer, got number`)
+		assert.Contains(t, messages, `$.namespaces["default"].is_istio_injected: expected boolean, got string`)
+		assert.Contains(t, messages, `$.namespaces["default"]: missing required property "resources"`)
+		assert.Contains(t, messages, `$.nodes["node-a"].resources.capacity.cpu: -2 is less than the minimum of 0`)
+	})
+
+	t.Run("Reports with inconsistent values are invalid", func(t *testing.T) {
+		clusterInfo := newReport()
+		clusterInfo.Metadata.SchemaVersion = models.SchemaVersion + 1
+		clusterInfo.Namespaces["default"].Pods = 1
+		clusterInfo.Nodes["node-a"].Density.MeshedPods = 3
+
+		problems, err := ValidateFile(writeReport(t, clusterInfo, "cluster.yml"))
+		require.NoError(t, err)
+		require.Len(t, problems, 3)
+		assert.Equal(t, "$.metadata.schema_version", problems[0].Path)
+		assert.Equal(t, Problem{
+			Path:    `$.namespaces["default"].resources.istio.containers`,
+			Message: "2 istio containers is more than the 1 pods of the namespace",
+		}, problems[1])
+		assert.Equal(t, `$.nodes["node-a"].density.meshed_pods`, problems[2].Path)
+	})
+
+	t.Run("Reports without metadata were written by an older version", func(t *testing.T) {
+		clusterInfo := newReport()
+		clusterInfo.Metadata = nil
+		problems, err := ValidateFile(writeReport(t, clusterInfo, "cluster.json"))
+		require.NoError(t, err)
+		require.Len(t, problems, 1)
+		assert.Contains(t, problems[0].Message, "missing metadata")
+	})
+
+	t.Run("Files which can't be parsed return an error", func(t *testing.T) {
+		_, err := ValidateFile(filepath.Join(t.TempDir(), "missing.json"))
+		assert.Error(t, err)
+
+		fileName := filepath.Join(t.TempDir(), "cluster.txt")
+		require.NoError(t, os.WriteFile(fileName, []byte("{}"), 0644))
+		_, err = ValidateFile(fileName)
+		assert.ErrorContains(t, err, "unsupported file extension")
+	})
+}
@@ -0,0 +1,202 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Problem is a way a report doesn't match its schema or isn't consistent, at a path within it such as $.nodes["node-a"].max_pods
+type Problem struct {
+	Path    string
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Path, p.Message)
+}
+
+// validator checks a document against a schema generated by Generate, so it only supports the keywords Generate uses:
+// $ref to $defs, anyOf, type, properties, required, additionalProperties, items, minimum and the date-time format
+type validator struct {
+	defs     map[string]any
+	problems []Problem
+}
+
+// Validate checks a document decoded from JSON against a schema generated by Generate, returning the problems found
+// sorted by path
+func Validate(schemaData []byte, document any) ([]Problem, error) {
+	var schema map[string]any
+	if err := json.Unmarshal(schemaData, &schema); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+	defs, _ := schema["$defs"].(map[string]any)
+
+	v := &validator{defs: defs}
+	v.validate(schema, document, "$")
+	sortProblems(v.problems)
+	return v.problems, nil
+}
+
+// validate checks a value against a schema, recording the problems found at the value's path
+func (v *validator) validate(schema map[string]any, value any, path string) {
+	if ref, ok := schema["$ref"].(string); ok {
+		def, ok := v.defs[strings.TrimPrefix(ref, "#/$defs/")].(map[string]any)
+		if !ok {
+			v.fail(path, "unknown reference %s in the schema", ref)
+			return
+		}
+		v.validate(def, value, path)
+	}
+
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		matched := false
+		for _, option := range anyOf {
+			optionSchema, _ := option.(map[string]any)
+			optionValidator := &validator{defs: v.defs}
+			optionValidator.validate(optionSchema, value, path)
+			if len(optionValidator.problems) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.fail(path, "%s doesn't match any of the allowed schemas", describe(value))
+			return
+		}
+	}
+
+	if types, ok := schema["type"]; ok && !matchesType(types, value) {
+		v.fail(path, "expected %s, got %s", typeNames(types), describe(value))
+		return
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		for name, property := range properties {
+			if propertyValue, ok := value[name]; ok {
+				propertySchema, _ := property.(map[string]any)
+				v.validate(propertySchema, propertyValue, path+"."+name)
+			}
+		}
+		if required, ok := schema["required"].([]any); ok {
+			for _, name := range required {
+				if _, ok := value[name.(string)]; !ok {
+					v.fail(path, "missing required property %q", name)
+				}
+			}
+		}
+		if additional, ok := schema["additionalProperties"].(map[string]any); ok {
+			for key, entry := range value {
+				if _, declared := properties[key]; !declared {
+					v.validate(additional, entry, path+"["+strconv.Quote(key)+"]")
+				}
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range value {
+				v.validate(items, item, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	case float64:
+		if minimum, ok := schema["minimum"].(float64); ok && value < minimum {
+			v.fail(path, "%v is less than the minimum of %v", value, minimum)
+		}
+	case string:
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, value); err != nil {
+				v.fail(path, "%q isn't a date-time", value)
+			}
+		}
+	}
+}
+
+// sortProblems sorts problems by path, then message
+func sortProblems(problems []Problem) {
+	sort.Slice(problems, func(i, j int) bool {
+		if problems[i].Path != problems[j].Path {
+			return problems[i].Path < problems[j].Path
+		}
+		return problems[i].Message < problems[j].Message
+	})
+}
+
+// fail records a problem at a path
+func (v *validator) fail(path, format string, args ...any) {
+	v.problems = append(v.problems, Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// matchesType returns true if a value decoded from JSON is of one of the types, which is a type name or a list of them
+func matchesType(types any, value any) bool {
+	switch types := types.(type) {
+	case string:
+		switch types {
+		case "object":
+			_, ok := value.(map[string]any)
+			return ok
+		case "array":
+			_, ok := value.([]any)
+			return ok
+		case "string":
+			_, ok := value.(string)
+			return ok
+		case "boolean":
+			_, ok := value.(bool)
+			return ok
+		case "number":
+			_, ok := value.(float64)
+			return ok
+		case "integer":
+			number, ok := value.(float64)
+			return ok && number == math.Trunc(number)
+		case "null":
+			return value == nil
+		}
+		return false
+	case []any:
+		for _, typeName := range types {
+			if matchesType(typeName, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// typeNames describes the types of a schema
+func typeNames(types any) string {
+	if list, ok := types.([]any); ok {
+		names := make([]string, 0, len(list))
+		for _, name := range list {
+			names = append(names, fmt.Sprint(name))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(types)
+}
+
+// describe returns the JSON type of a value decoded from JSON
+func describe(value any) string {
+	switch value.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
@@ -0,0 +1,61 @@
+package utils
+
+// Helpers to identify workloads participating in Istio's ambient mode: https://istio.io/latest/docs/ambient/
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DataplaneModeLabel is the label used on namespaces and pods to enroll them in (or exclude them from) ambient mode
+	DataplaneModeLabel = "istio.io/dataplane-mode"
+	// DataplaneModeAmbient is the DataplaneModeLabel value which enrolls a namespace or pod in ambient mode
+	DataplaneModeAmbient = "ambient"
+	// DataplaneModeNone is the DataplaneModeLabel value which excludes a pod from ambient mode
+	DataplaneModeNone = "none"
+
+	// ManagedGatewayLabel is the label istiod sets on the deployments (and pods) of the gateways it manages
+	ManagedGatewayLabel = "gateway.istio.io/managed"
+	// ManagedGatewayMeshController is the ManagedGatewayLabel value used for waypoint proxies
+	ManagedGatewayMeshController = "istio.io-mesh-controller"
+
+	// ztunnelName is the name of the ztunnel DaemonSet, and the value of the "app" and "app.kubernetes.io/name" labels on its pods
+	ztunnelName = "ztunnel"
+	// appNameLabel is the recommended Kubernetes label naming the application, which Istio's ztunnel chart sets
+	appNameLabel = "app.kubernetes.io/name"
+)
+
+// IsAmbientEnrolled checks if a pod is enrolled in ambient mode, either through its own labels or its namespace's labels.
+// A pod-level label takes precedence over the namespace label, which allows pods to opt out of an ambient namespace.
+// Note: it does not account for sidecar injection, which takes precedence over ambient mode.
+func IsAmbientEnrolled(podLabels, nsLabels map[string]string) bool {
+	if mode, ok := podLabels[DataplaneModeLabel]; ok {
+		return mode == DataplaneModeAmbient
+	}
+	return nsLabels[DataplaneModeLabel] == DataplaneModeAmbient
+}
+
+// IsNamespaceAmbientEnrolled checks if a namespace is labelled to enroll its pods in ambient mode
+func IsNamespaceAmbientEnrolled(nsLabels map[string]string) bool {
+	return nsLabels[DataplaneModeLabel] == DataplaneModeAmbient
+}
+
+// IsZtunnelPod checks if a pod belongs to Istio's ztunnel DaemonSet. As unrelated workloads may be labelled app=ztunnel as well,
+// the pod must also carry the app.kubernetes.io/name label of Istio's ztunnel chart, or be controlled by a DaemonSet named ztunnel
+// as in releases whose chart doesn't set that label.
+func IsZtunnelPod(pod *corev1.Pod) bool {
+	if pod.Labels["app"] != ztunnelName {
+		return false
+	}
+	if pod.Labels[appNameLabel] == ztunnelName {
+		return true
+	}
+	controller := metav1.GetControllerOfNoCopy(pod)
+	return controller != nil && controller.Kind == "DaemonSet" && controller.Name == ztunnelName
+}
+
+// IsWaypointPod checks if a pod is a waypoint proxy managed by istiod
+func IsWaypointPod(podLabels map[string]string) bool {
+	return podLabels[ManagedGatewayLabel] == ManagedGatewayMeshController
+}
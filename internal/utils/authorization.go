@@ -0,0 +1,109 @@
+package utils
+
+// Helpers to evaluate Istio AuthorizationPolicies, whose L7 rules can only be enforced by a waypoint proxy in ambient mode:
+// https://istio.io/latest/docs/ambient/usage/l4-policy/
+
+import (
+	"context"
+	"strings"
+
+	"k8s.io/client-go/dynamic"
+)
+
+// securityGroup is the API group of Istio's security resources
+const securityGroup = "security.istio.io"
+
+// authorizationActionCustom is the action delegating authorization to an external authorizer, which requires a waypoint in ambient mode
+const authorizationActionCustom = "CUSTOM"
+
+// l4ConditionKeys are the keys of authorization conditions which ztunnel can enforce, all others require a waypoint
+var l4ConditionKeys = map[string]struct{}{
+	"source.ip":        {},
+	"remote.ip":        {},
+	"source.namespace": {},
+	"source.principal": {},
+	"destination.ip":   {},
+	"destination.port": {},
+}
+
+// AuthorizationPolicy is the subset of an Istio AuthorizationPolicy used to evaluate whether it requires a waypoint in ambient mode
+type AuthorizationPolicy struct {
+	Name      string
+	Namespace string
+	// HasTargetRef is true if the policy is attached to a gateway or waypoint through targetRef(s), rather than selecting workloads
+	HasTargetRef bool
+	// RequiresL7 is true if the policy uses the CUSTOM action, or matches requests on L7 attributes such as paths, methods or request principals
+	RequiresL7 bool
+}
+
+// authorizationPolicySpec is the subset of the AuthorizationPolicy spec which is read
+type authorizationPolicySpec struct {
+	Action     string                 `json:"action"`
+	TargetRef  map[string]interface{} `json:"targetRef"`
+	TargetRefs []interface{}          `json:"targetRefs"`
+	Rules      []struct {
+		From []struct {
+			Source map[string][]string `json:"source"`
+		} `json:"from"`
+		To []struct {
+			Operation map[string][]string `json:"operation"`
+		} `json:"to"`
+		When []struct {
+			Key string `json:"key"`
+		} `json:"when"`
+	} `json:"rules"`
+}
+
+// requiresL7 checks if the policy can only be enforced by a proxy inspecting L7 traffic
+func (s *authorizationPolicySpec) requiresL7() bool {
+	if strings.EqualFold(s.Action, authorizationActionCustom) {
+		return true
+	}
+	for _, rule := range s.Rules {
+		for _, from := range rule.From {
+			if len(from.Source["requestPrincipals"]) > 0 || len(from.Source["notRequestPrincipals"]) > 0 {
+				return true
+			}
+		}
+		for _, to := range rule.To {
+			for field, values := range to.Operation {
+				// only ports can be matched at L4
+				if field != "ports" && field != "notPorts" && len(values) > 0 {
+					return true
+				}
+			}
+		}
+		for _, when := range rule.When {
+			if _, ok := l4ConditionKeys[when.Key]; !ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ListAuthorizationPolicies lists the AuthorizationPolicies of every namespace, keyed by namespace.
+// If the AuthorizationPolicy CRD isn't installed, no policies are returned.
+func ListAuthorizationPolicies(ctx context.Context, dynamicClient dynamic.Interface) (map[string][]AuthorizationPolicy, error) {
+	items, err := ListIstioResources(ctx, dynamicClient, securityGroup, "authorizationpolicies", "v1", "v1beta1")
+	if err != nil {
+		return nil, err
+	}
+
+	policies := make(map[string][]AuthorizationPolicy)
+	for i := range items {
+		var spec authorizationPolicySpec
+		if err := decodeSpec(&items[i], &spec); err != nil {
+			return nil, err
+		}
+
+		policy := AuthorizationPolicy{
+			Name:         items[i].GetName(),
+			Namespace:    items[i].GetNamespace(),
+			HasTargetRef: len(spec.TargetRef) > 0 || len(spec.TargetRefs) > 0,
+			RequiresL7:   spec.requiresL7(),
+		}
+		policies[policy.Namespace] = append(policies[policy.Namespace], policy)
+	}
+	return policies, nil
+}
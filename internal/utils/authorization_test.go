@@ -0,0 +1,94 @@
+//go:build test || unit
+
+package utils
+
+import (
+	"context"
+	"testing"
+
+	testutils "github.com/solo-io/istio-usage-collector/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestListAuthorizationPolicies(t *testing.T) {
+	tests := []struct {
+		name         string
+		spec         map[string]interface{}
+		requiresL7   bool
+		hasTargetRef bool
+	}{
+		{
+			name:       "allow-nothing",
+			spec:       map[string]interface{}{},
+			requiresL7: false,
+		},
+		{
+			name: "l4-only",
+			spec: map[string]interface{}{
+				"rules": []interface{}{map[string]interface{}{
+					"from": []interface{}{map[string]interface{}{"source": map[string]interface{}{"principals": []interface{}{"cluster.local/ns/app/sa/client"}}}},
+					"to":   []interface{}{map[string]interface{}{"operation": map[string]interface{}{"ports": []interface{}{"8080"}}}},
+					"when": []interface{}{map[string]interface{}{"key": "source.ip", "values": []interface{}{"10.0.0.0/8"}}},
+				}},
+			},
+			requiresL7: false,
+		},
+		{
+			name: "paths",
+			spec: map[string]interface{}{
+				"rules": []interface{}{map[string]interface{}{
+					"to": []interface{}{map[string]interface{}{"operation": map[string]interface{}{"paths": []interface{}{"/admin"}}}},
+				}},
+			},
+			requiresL7: true,
+		},
+		{
+			name: "request-principals",
+			spec: map[string]interface{}{
+				"rules": []interface{}{map[string]interface{}{
+					"from": []interface{}{map[string]interface{}{"source": map[string]interface{}{"requestPrincipals": []interface{}{"*"}}}},
+				}},
+			},
+			requiresL7: true,
+		},
+		{
+			name: "jwt-claims",
+			spec: map[string]interface{}{
+				"rules": []interface{}{map[string]interface{}{
+					"when": []interface{}{map[string]interface{}{"key": "request.auth.claims[groups]", "values": []interface{}{"admin"}}},
+				}},
+			},
+			requiresL7: true,
+		},
+		{
+			name:       "ext-authz",
+			spec:       map[string]interface{}{"action": "CUSTOM", "provider": map[string]interface{}{"name": "ext-authz"}},
+			requiresL7: true,
+		},
+		{
+			name: "waypoint",
+			spec: map[string]interface{}{
+				"targetRefs": []interface{}{map[string]interface{}{"kind": "Gateway", "group": "gateway.networking.k8s.io", "name": "waypoint"}},
+				"rules": []interface{}{map[string]interface{}{
+					"to": []interface{}{map[string]interface{}{"operation": map[string]interface{}{"methods": []interface{}{"GET"}}}},
+				}},
+			},
+			requiresL7:   true,
+			hasTargetRef: true,
+		},
+	}
+
+	var objects []runtime.Object
+	expected := make(map[string][]AuthorizationPolicy)
+	for _, tt := range tests {
+		objects = append(objects, testutils.NewIstioObject("security.istio.io/v1", "AuthorizationPolicy", "app", tt.name, tt.spec))
+		expected["app"] = append(expected["app"], AuthorizationPolicy{Name: tt.name, Namespace: "app", HasTargetRef: tt.hasTargetRef, RequiresL7: tt.requiresL7})
+	}
+	client := testutils.NewIstioDynamicClient(objects...)
+
+	policies, err := ListAuthorizationPolicies(context.Background(), client)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, expected["app"], policies["app"])
+}
@@ -0,0 +1,45 @@
+package utils
+
+// Helpers to read Istio's custom resources through the dynamic client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// ListIstioResources lists the objects of a resource across every namespace, trying each version in order until one is served,
+// as older Istio releases don't serve the newer versions. If no version is served (the CRD isn't installed), no objects are returned.
+func ListIstioResources(ctx context.Context, dynamicClient dynamic.Interface, group, resource string, versions ...string) ([]unstructured.Unstructured, error) {
+	for _, version := range versions {
+		gvr := schema.GroupVersionResource{Group: group, Version: version, Resource: resource}
+		list, err := dynamicClient.Resource(gvr).Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+		if errors.IsNotFound(err) {
+			// this version isn't served, try the next one
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", gvr.String(), err)
+		}
+		return list.Items, nil
+	}
+	return nil, nil
+}
+
+// decodeSpec decodes the spec of an object into a typed struct holding the fields which are read
+func decodeSpec(item *unstructured.Unstructured, spec interface{}) error {
+	data, err := json.Marshal(item.Object["spec"])
+	if err != nil {
+		return fmt.Errorf("failed to read %s %s/%s: %w", item.GetKind(), item.GetNamespace(), item.GetName(), err)
+	}
+	if err := json.Unmarshal(data, spec); err != nil {
+		return fmt.Errorf("failed to parse %s %s/%s: %w", item.GetKind(), item.GetNamespace(), item.GetName(), err)
+	}
+	return nil
+}
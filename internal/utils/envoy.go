@@ -0,0 +1,168 @@
+package utils
+
+// Helpers to read the stats of a sidecar's Envoy through the pod proxy subresource, in Prometheus' text format:
+// https://www.envoyproxy.io/docs/envoy/latest/operations/admin#get--stats-prometheus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// EnvoyStatsPort is the port of the listener istio-proxy exposes Envoy's Prometheus stats on. Envoy's admin interface
+	// serves them as well, but it only listens on localhost, so it can't be reached through the pod proxy subresource.
+	EnvoyStatsPort = "15090"
+
+	// envoyStatsPath is the path of the stats in Prometheus' text format
+	envoyStatsPath = "stats/prometheus"
+)
+
+// EnvoyStats is the subset of a sidecar's Envoy stats describing its traffic and the size of its configuration
+type EnvoyStats struct {
+	// UptimeSeconds is the time since the proxy started, which the request counters accumulate over
+	UptimeSeconds float64
+	// InboundRequests and OutboundRequests are the number of requests the proxy received and sent since it started
+	InboundRequests  float64
+	OutboundRequests float64
+	// ActiveConnections is the number of downstream connections currently open on the proxy's listeners, which Istio only
+	// exposes if the proxy's stats matcher includes the listener stats
+	ActiveConnections int
+	// Clusters and Listeners are the number of clusters and listeners of the proxy's configuration
+	Clusters  int
+	Listeners int
+	// Routes is the number of route configurations with RDS stats, which Istio only exposes if the proxy's stats matcher includes them
+	Routes int
+}
+
+// ScrapeEnvoyStats reads the Envoy stats of a pod's istio-proxy through the pod proxy subresource, on the stats port.
+// If the proxy subresource is forbidden, the error is returned as is, so callers can tell it apart with errors.IsForbidden.
+func ScrapeEnvoyStats(ctx context.Context, clientset kubernetes.Interface, namespace, pod string) (*EnvoyStats, error) {
+	response := clientset.CoreV1().Pods(namespace).ProxyGet("http", pod, EnvoyStatsPort, envoyStatsPath, nil)
+	if response == nil {
+		return nil, fmt.Errorf("no response from the pod proxy subresource")
+	}
+	data, err := response.DoRaw(ctx)
+	if err != nil {
+		if errors.IsForbidden(err) || ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to read Envoy stats: %w", err)
+	}
+	return ParseEnvoyStats(data)
+}
+
+// ParseEnvoyStats parses Envoy stats in Prometheus' text format
+func ParseEnvoyStats(data []byte) (*EnvoyStats, error) {
+	stats := &EnvoyStats{}
+	routes := make(map[string]struct{})
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, labels, value, err := parsePrometheusSample(line)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case name == "envoy_server_uptime":
+			stats.UptimeSeconds = value
+		case name == "envoy_listener_downstream_cx_active":
+			stats.ActiveConnections += int(value)
+		case name == "envoy_cluster_manager_active_clusters":
+			stats.Clusters = int(value)
+		case name == "envoy_listener_manager_total_listeners_active":
+			stats.Listeners = int(value)
+		case name == "istio_requests_total" && labels["reporter"] == "destination":
+			stats.InboundRequests += value
+		case name == "istio_requests_total" && labels["reporter"] == "source":
+			stats.OutboundRequests += value
+		case strings.HasPrefix(name, "envoy_http_rds_") && labels["envoy_rds_route_config"] != "":
+			routes[labels["envoy_http_conn_manager_prefix"]+"/"+labels["envoy_rds_route_config"]] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read Envoy stats: %w", err)
+	}
+
+	stats.Routes = len(routes)
+	return stats, nil
+}
+
+// parsePrometheusSample parses a sample line such as `name{label="value"} 1`, ignoring its optional timestamp
+func parsePrometheusSample(line string) (string, map[string]string, float64, error) {
+	nameEnd := strings.IndexAny(line, "{ ")
+	if nameEnd < 0 {
+		return "", nil, 0, fmt.Errorf("invalid Prometheus sample %q", line)
+	}
+	name := line[:nameEnd]
+	rest := line[nameEnd:]
+
+	labels := make(map[string]string)
+	if strings.HasPrefix(rest, "{") {
+		rest = rest[1:]
+		for {
+			rest = strings.TrimLeft(rest, ", ")
+			if strings.HasPrefix(rest, "}") {
+				rest = rest[1:]
+				break
+			}
+			eq := strings.Index(rest, "=")
+			if eq < 0 || len(rest) <= eq+1 || rest[eq+1] != '"' {
+				return "", nil, 0, fmt.Errorf("invalid Prometheus labels in %q", line)
+			}
+			key := strings.TrimSpace(rest[:eq])
+			value, remaining, err := unquoteLabelValue(rest[eq+1:])
+			if err != nil {
+				return "", nil, 0, fmt.Errorf("invalid Prometheus labels in %q: %w", line, err)
+			}
+			labels[key] = value
+			rest = remaining
+		}
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return "", nil, 0, fmt.Errorf("missing value in Prometheus sample %q", line)
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("invalid value in Prometheus sample %q: %w", line, err)
+	}
+	return name, labels, value, nil
+}
+
+// unquoteLabelValue reads a quoted label value, returning it along with the rest of the line
+func unquoteLabelValue(s string) (string, string, error) {
+	var value strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 >= len(s) {
+				return "", "", fmt.Errorf("unterminated escape")
+			}
+			i++
+			if s[i] == 'n' {
+				value.WriteByte('\n')
+			} else {
+				value.WriteByte(s[i])
+			}
+		case '"':
+			return value.String(), s[i+1:], nil
+		default:
+			value.WriteByte(s[i])
+		}
+	}
+	return "", "", fmt.Errorf("unterminated label value")
+}
@@ -0,0 +1,91 @@
+//go:build test || unit
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	testutils "github.com/solo-io/istio-usage-collector/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	restclient "k8s.io/client-go/rest"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+const envoyStatsFixture = `# TYPE envoy_server_uptime gauge
+envoy_server_uptime{} 100
+# TYPE envoy_server_total_connections gauge
+envoy_server_total_connections{} 9
+envoy_listener_downstream_cx_active{envoy_listener_address="0.0.0.0_15006"} 5
+envoy_listener_downstream_cx_active{envoy_listener_address="0.0.0.0_15090"} 2
+envoy_cluster_manager_active_clusters{} 42
+envoy_listener_manager_total_listeners_active{} 12
+istio_requests_total{reporter="destination",source_workload="client",response_code="200"} 300
+istio_requests_total{reporter="destination",source_workload="other, \"quoted\"",response_code="503"} 100
+istio_requests_total{reporter="source",destination_workload="db",response_code="200"} 50
+envoy_http_rds_config_reload{envoy_http_conn_manager_prefix="outbound_0.0.0.0_80",envoy_rds_route_config="80"} 1
+envoy_http_rds_update_success{envoy_http_conn_manager_prefix="outbound_0.0.0.0_80",envoy_rds_route_config="80"} 3
+envoy_http_rds_config_reload{envoy_http_conn_manager_prefix="outbound_0.0.0.0_8080",envoy_rds_route_config="8080"} 1 1700000000000
+`
+
+func TestParseEnvoyStats(t *testing.T) {
+	stats, err := ParseEnvoyStats([]byte(envoyStatsFixture))
+	require.NoError(t, err)
+	assert.Equal(t, &EnvoyStats{
+		UptimeSeconds:     100,
+		InboundRequests:   400,
+		OutboundRequests:  50,
+		ActiveConnections: 7,
+		Clusters:          42,
+		Listeners:         12,
+		Routes:            2,
+	}, stats)
+
+	_, err = ParseEnvoyStats([]byte(`istio_requests_total{reporter="destination} 1`))
+	assert.Error(t, err)
+	_, err = ParseEnvoyStats([]byte(`envoy_server_uptime not-a-number`))
+	assert.Error(t, err)
+}
+
+func TestScrapeEnvoyStats(t *testing.T) {
+	ctx := context.Background()
+
+	// newProxyClient serves the Envoy stats from the stats port, or the given error, every other port failing
+	newProxyClient := func(err error) *fake.Clientset {
+		client := fake.NewSimpleClientset()
+		client.PrependProxyReactor("pods", func(action clienttesting.Action) (bool, restclient.ResponseWrapper, error) {
+			proxy := action.(clienttesting.ProxyGetAction)
+			if proxy.GetPort() != EnvoyStatsPort {
+				return true, &testutils.ProxyResponse{Err: fmt.Errorf("connection refused")}, nil
+			}
+			return true, &testutils.ProxyResponse{Data: []byte(envoyStatsFixture), Err: err}, nil
+		})
+		return client
+	}
+
+	t.Run("stats are read from the stats port", func(t *testing.T) {
+		stats, err := ScrapeEnvoyStats(ctx, newProxyClient(nil), "app", "web-1")
+		require.NoError(t, err)
+		assert.Equal(t, 42, stats.Clusters)
+	})
+
+	t.Run("the error is returned as is if the proxy subresource is forbidden", func(t *testing.T) {
+		forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "pods/proxy"}, "web-1", fmt.Errorf("denied"))
+		client := newProxyClient(forbidden)
+		_, err := ScrapeEnvoyStats(ctx, client, "app", "web-1")
+		assert.True(t, apierrors.IsForbidden(err))
+		assert.Len(t, client.Actions(), 1)
+	})
+
+	t.Run("no other port is tried if the stats port can't be reached", func(t *testing.T) {
+		client := newProxyClient(fmt.Errorf("connection refused"))
+		_, err := ScrapeEnvoyStats(ctx, client, "app", "web-1")
+		assert.ErrorContains(t, err, "connection refused")
+		assert.Len(t, client.Actions(), 1)
+	})
+}
@@ -0,0 +1,26 @@
+package utils
+
+// Helpers to identify Istio ingress and egress gateways, which run a standalone istio-proxy rather than a sidecar
+
+const (
+	// GatewayNameLabel is the label set on the pods of gateways deployed for a Gateway API Gateway (including waypoints)
+	GatewayNameLabel = "gateway.networking.k8s.io/gateway-name"
+
+	// istioGatewayLabel is the label set on the pods of the ingress and egress gateways installed by istioctl
+	istioGatewayLabel = "istio"
+)
+
+// istioGatewayLabelValues are the values of the "istio" label which identify ingress and egress gateway pods
+var istioGatewayLabelValues = map[string]bool{
+	"ingressgateway": true,
+	"egressgateway":  true,
+}
+
+// IsGatewayPod returns true if a pod belongs to an ingress or egress gateway.
+// Waypoint proxies are Gateway API gateways as well, but are not considered to be ingress or egress gateways.
+func IsGatewayPod(podLabels map[string]string) bool {
+	if _, ok := podLabels[GatewayNameLabel]; ok && !IsWaypointPod(podLabels) {
+		return true
+	}
+	return istioGatewayLabelValues[podLabels[istioGatewayLabel]]
+}
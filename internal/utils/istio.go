@@ -3,14 +3,119 @@ package utils
 // A minified version of istioctl's checkinject command which checks if a pod is automatically injected with an istio sidecar: https://github.com/istio/istio/tree/master/istioctl/pkg/checkinject
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
 	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
 )
 
+const (
+	// SidecarInjectKey is the pod label (or legacy annotation) used to explicitly enable or disable sidecar injection
+	SidecarInjectKey = "sidecar.istio.io/inject"
+	// RevisionLabel is the label used on namespaces, pods and istio resources to select an istio revision
+	RevisionLabel = "istio.io/rev"
+
+	// RevisionTagLabel is the label set on revision tag webhooks (istio-revision-tag-<tag>) to the name of the tag
+	RevisionTagLabel = "istio.io/tag"
+	// IstiodLabelSelector selects istiod deployments and pods
+	IstiodLabelSelector = "app=istiod"
+	// IstiodContainerName is the name of istiod's container
+	IstiodContainerName = "discovery"
+
+	// DefaultRevision is the name of the revision installed without an explicit revision
+	DefaultRevision = "default"
+	// DefaultIstioNamespace is the namespace istiod is installed to by default
+	DefaultIstioNamespace = "istio-system"
+
+	// Annotations which override the sidecar proxy's resources, instead of using the mesh-wide defaults
+	ProxyCPUAnnotation         = "sidecar.istio.io/proxyCPU"
+	ProxyMemoryAnnotation      = "sidecar.istio.io/proxyMemory"
+	ProxyCPULimitAnnotation    = "sidecar.istio.io/proxyCPULimit"
+	ProxyMemoryLimitAnnotation = "sidecar.istio.io/proxyMemoryLimit"
+
+	// ProxyConfigAnnotation overrides the mesh-wide proxy configuration of a pod's sidecar
+	ProxyConfigAnnotation = "proxy.istio.io/config"
+
+	// injectorConfigMapName is the name of the ConfigMap holding the sidecar injector configuration for the default revision
+	injectorConfigMapName = "istio-sidecar-injector"
+	// meshConfigMapName is the name of the ConfigMap holding the mesh configuration for the default revision
+	meshConfigMapName = "istio"
+
+	// injection policies, as defined in the sidecar injector configuration
+	injectionPolicyEnabled  = "enabled"
+	injectionPolicyDisabled = "disabled"
+)
+
+// Reasons recorded for the outcome of an injection check
+const (
+	InjectionReasonHostNetwork              = "host-network"
+	InjectionReasonIgnoredNamespace         = "ignored-namespace"
+	InjectionReasonNoWebhookMatch           = "no-webhook-match"
+	InjectionReasonInjectLabelEnabled       = "inject-label-enabled"
+	InjectionReasonInjectLabelDisabled      = "inject-label-disabled"
+	InjectionReasonInjectAnnotationEnabled  = "inject-annotation-enabled"
+	InjectionReasonInjectAnnotationDisabled = "inject-annotation-disabled"
+	InjectionReasonNeverInjectSelector      = "never-inject-selector"
+	InjectionReasonAlwaysInjectSelector     = "always-inject-selector"
+	InjectionReasonPolicyEnabled            = "policy-enabled"
+	InjectionReasonPolicyDisabled           = "policy-disabled"
+)
+
+// ignoredNamespaces are the namespaces istiod never injects sidecars into, regardless of the webhook configuration
+var ignoredNamespaces = map[string]struct{}{
+	metav1.NamespaceSystem: {},
+	metav1.NamespacePublic: {},
+}
+
+// InjectorConfig is the subset of the sidecar injector configuration (the "config" key of the istio-sidecar-injector ConfigMap)
+// which istiod uses to decide whether a pod matched by the webhooks is injected
+type InjectorConfig struct {
+	// Policy is the default injection policy, either "enabled" or "disabled"
+	Policy string `json:"policy"`
+	// NeverInjectSelector is a list of pod label selectors which prevent injection, unless the pod explicitly requests it
+	NeverInjectSelector []metav1.LabelSelector `json:"neverInjectSelector"`
+	// AlwaysInjectSelector is a list of pod label selectors which force injection, unless the pod explicitly opts out
+	AlwaysInjectSelector []metav1.LabelSelector `json:"alwaysInjectSelector"`
+	// ProxyResources are the mesh-wide default sidecar resources (global.proxy.resources), read from the "values" key of the ConfigMap
+	ProxyResources corev1.ResourceRequirements `json:"-"`
+}
+
+// imageVariants are the variants istio images are published as, which are appended to the image tag
+var imageVariants = []string{"distroless", "debug"}
+
+// ProxyResourceAnnotations are the pod annotations which override the sidecar proxy's default resources
+var ProxyResourceAnnotations = []string{ProxyCPUAnnotation, ProxyMemoryAnnotation, ProxyCPULimitAnnotation, ProxyMemoryLimitAnnotation}
+
+// injectorValues is the subset of the helm values (the "values" key of the istio-sidecar-injector ConfigMap) used by the collector
+type injectorValues struct {
+	Global struct {
+		Proxy struct {
+			Resources corev1.ResourceRequirements `json:"resources"`
+		} `json:"proxy"`
+	} `json:"global"`
+}
+
+// meshConfig is the subset of the mesh configuration (the "mesh" key of the istio ConfigMap) used by the collector
+type meshConfig struct {
+	RootNamespace string `json:"rootNamespace"`
+}
+
+// InjectionResult is the outcome of an injection check, along with the reason for it
+type InjectionResult struct {
+	Injected bool
+	Reason   string
+	// Revision is the istio revision of the webhook which matched the pod, even if istiod's policy then decides against injection
+	Revision string
+	// Tag is the revision tag of the webhook which matched the pod, if the webhook belongs to a revision tag
+	Tag string
+}
+
 // FilterIstioWebhooks filters out non-istio webhooks from a list of webhooks
 func FilterIstioWebhooks(whs []admissionregistrationv1.MutatingWebhookConfiguration) []admissionregistrationv1.MutatingWebhookConfiguration {
 	istioWebhooks := make([]admissionregistrationv1.MutatingWebhookConfiguration, 0)
@@ -22,18 +127,227 @@ func FilterIstioWebhooks(whs []admissionregistrationv1.MutatingWebhookConfigurat
 	return istioWebhooks
 }
 
-// CheckInject checks if a pod is automatically injected with an istio sidecar
-// It assumes the passed in mutating webhooks are only istio webhooks
-func CheckInject(istioWebhooks []admissionregistrationv1.MutatingWebhookConfiguration, podLabels, nsLabels map[string]string) bool {
+// CheckInject checks if a pod is automatically injected with an istio sidecar, mirroring istiod's injection policy:
+// the pod must be matched by a webhook, after which host networking, the sidecar.istio.io/inject label/annotation,
+// the injector's never/always inject selectors and finally its default policy are evaluated in that order.
+// It assumes the passed in mutating webhooks are only istio webhooks. Injector configs are keyed by revision,
+// and a missing config is treated as the default (enabled) policy.
+func CheckInject(istioWebhooks []admissionregistrationv1.MutatingWebhookConfiguration, injectorConfigs map[string]*InjectorConfig, pod *corev1.Pod, nsLabels map[string]string) InjectionResult {
+	if pod.Spec.HostNetwork {
+		return InjectionResult{Injected: false, Reason: InjectionReasonHostNetwork}
+	}
+	if _, ok := ignoredNamespaces[pod.Namespace]; ok {
+		return InjectionResult{Injected: false, Reason: InjectionReasonIgnoredNamespace}
+	}
+
 	for _, mwc := range istioWebhooks {
-		// if any istio webhook is found which injects the container, return true
-		if analyzeWebhooksMatchStatus(mwc.Webhooks, podLabels, nsLabels) {
+		// the first istio webhook found which would send the pod to istiod decides the injection policy used
+		if analyzeWebhooksMatchStatus(mwc.Webhooks, pod.Labels, nsLabels) {
+			rev := webhookRevision(&mwc)
+			result := checkInjectionPolicy(injectorConfigs[rev], pod)
+			result.Revision = rev
+			result.Tag = mwc.Labels[RevisionTagLabel]
+			return result
+		}
+	}
+	return InjectionResult{Injected: false, Reason: InjectionReasonNoWebhookMatch}
+}
+
+// checkInjectionPolicy evaluates istiod's injection policy for a pod which was matched by an injection webhook
+func checkInjectionPolicy(cfg *InjectorConfig, pod *corev1.Pod) InjectionResult {
+	if cfg == nil {
+		cfg = &InjectorConfig{Policy: injectionPolicyEnabled}
+	}
+
+	// the label takes precedence over the legacy annotation if both are set
+	value, explicitlySet := pod.Annotations[SidecarInjectKey]
+	enabledReason, disabledReason := InjectionReasonInjectAnnotationEnabled, InjectionReasonInjectAnnotationDisabled
+	if labelValue, ok := pod.Labels[SidecarInjectKey]; ok {
+		value, explicitlySet = labelValue, true
+		enabledReason, disabledReason = InjectionReasonInjectLabelEnabled, InjectionReasonInjectLabelDisabled
+	}
+	if explicitlySet && value != "" {
+		// http://yaml.org/type/bool.html -- any other value disables injection
+		switch strings.ToLower(value) {
+		case "y", "yes", "true", "on":
+			return InjectionResult{Injected: true, Reason: enabledReason}
+		default:
+			return InjectionResult{Injected: false, Reason: disabledReason}
+		}
+	}
+
+	if matchesAnySelector(cfg.NeverInjectSelector, pod.Labels) {
+		return InjectionResult{Injected: false, Reason: InjectionReasonNeverInjectSelector}
+	}
+	if matchesAnySelector(cfg.AlwaysInjectSelector, pod.Labels) {
+		return InjectionResult{Injected: true, Reason: InjectionReasonAlwaysInjectSelector}
+	}
+
+	if cfg.Policy == injectionPolicyDisabled {
+		return InjectionResult{Injected: false, Reason: InjectionReasonPolicyDisabled}
+	}
+	return InjectionResult{Injected: true, Reason: InjectionReasonPolicyEnabled}
+}
+
+// matchesAnySelector checks if the labels match any of the non-empty label selectors
+func matchesAnySelector(selectors []metav1.LabelSelector, objLabels map[string]string) bool {
+	for i := range selectors {
+		selector, err := metav1.LabelSelectorAsSelector(&selectors[i])
+		if err != nil || selector.Empty() {
+			continue
+		}
+		if selector.Matches(labels.Set(objLabels)) {
 			return true
 		}
 	}
 	return false
 }
 
+// webhookRevision returns the istio revision a webhook configuration belongs to
+func webhookRevision(mwc *admissionregistrationv1.MutatingWebhookConfiguration) string {
+	if rev := mwc.Labels[RevisionLabel]; rev != "" {
+		return rev
+	}
+	return DefaultRevision
+}
+
+// SameVersion checks if two istio image versions are the same release, ignoring image variants (e.g. 1.25.0-distroless)
+func SameVersion(a, b string) bool {
+	return trimImageVariant(a) == trimImageVariant(b)
+}
+
+// trimImageVariant removes the image variant suffix from an istio image version
+func trimImageVariant(version string) string {
+	for _, variant := range imageVariants {
+		version = strings.TrimSuffix(version, "-"+variant)
+	}
+	return version
+}
+
+// IstiodRevision returns the revision of an istiod deployment or pod from its labels
+func IstiodRevision(objLabels map[string]string) string {
+	if rev := objLabels[RevisionLabel]; rev != "" {
+		return rev
+	}
+	return DefaultRevision
+}
+
+// RevisionTags returns the revision each revision tag points to, keyed by tag.
+// Revision tags are defined by the istio-revision-tag-<tag> webhook configurations, labelled with both the tag and the revision.
+func RevisionTags(istioWebhooks []admissionregistrationv1.MutatingWebhookConfiguration) map[string]string {
+	tags := make(map[string]string)
+	for i := range istioWebhooks {
+		if tag := istioWebhooks[i].Labels[RevisionTagLabel]; tag != "" {
+			tags[tag] = webhookRevision(&istioWebhooks[i])
+		}
+	}
+	return tags
+}
+
+// ImageVersion returns the tag of a container image, or an empty string if the image isn't tagged
+func ImageVersion(image string) string {
+	// drop the digest, if any, before looking for the tag
+	if idx := strings.Index(image, "@"); idx >= 0 {
+		image = image[:idx]
+	}
+	// the tag follows the last colon, unless that colon separates the registry host from its port
+	idx := strings.LastIndex(image, ":")
+	if idx < 0 || strings.Contains(image[idx+1:], "/") {
+		return ""
+	}
+	return image[idx+1:]
+}
+
+// webhookNamespace returns the namespace of the istiod service a webhook configuration sends requests to
+func webhookNamespace(mwc *admissionregistrationv1.MutatingWebhookConfiguration) string {
+	for _, wh := range mwc.Webhooks {
+		if wh.ClientConfig.Service != nil && wh.ClientConfig.Service.Namespace != "" {
+			return wh.ClientConfig.Service.Namespace
+		}
+	}
+	return DefaultIstioNamespace
+}
+
+// LoadInjectorConfigs loads the sidecar injector configuration of each revision referenced by the istio webhooks, keyed by revision.
+// Revisions whose configuration can't be loaded are left out, so that the default policy is used for them.
+func LoadInjectorConfigs(ctx context.Context, clientset kubernetes.Interface, istioWebhooks []admissionregistrationv1.MutatingWebhookConfiguration) (map[string]*InjectorConfig, error) {
+	configs := make(map[string]*InjectorConfig)
+	loaded := make(map[string]struct{})
+	var errs []error
+	for i := range istioWebhooks {
+		// multiple webhook configurations (e.g. revision tags) can point to the same revision
+		rev := webhookRevision(&istioWebhooks[i])
+		if _, ok := loaded[rev]; ok {
+			continue
+		}
+		loaded[rev] = struct{}{}
+
+		cmName := injectorConfigMapName
+		if rev != DefaultRevision {
+			cmName = fmt.Sprintf("%s-%s", injectorConfigMapName, rev)
+		}
+		cm, err := clientset.CoreV1().ConfigMaps(webhookNamespace(&istioWebhooks[i])).Get(ctx, cmName, metav1.GetOptions{})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get injector config %s: %w", cmName, err))
+			continue
+		}
+
+		cfg, err := ParseInjectorConfig(cm.Data["config"])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to parse injector config %s: %w", cmName, err))
+			continue
+		}
+		if cm.Data["values"] != "" {
+			var values injectorValues
+			if err := yaml.Unmarshal([]byte(cm.Data["values"]), &values); err != nil {
+				errs = append(errs, fmt.Errorf("failed to parse injector values %s: %w", cmName, err))
+			} else {
+				cfg.ProxyResources = values.Global.Proxy.Resources
+			}
+		}
+		configs[rev] = cfg
+	}
+
+	if len(errs) > 0 {
+		return configs, fmt.Errorf("encountered %d errors loading injector configs: %v", len(errs), errs)
+	}
+	return configs, nil
+}
+
+// MeshRootNamespace reads the root namespace from the mesh configuration of an istiod revision, which is stored in istiod's namespace.
+// The root namespace defaults to istiod's namespace if the mesh configuration doesn't set it, or if it can't be read.
+func MeshRootNamespace(ctx context.Context, clientset kubernetes.Interface, istiodNamespace, rev string) (string, error) {
+	cmName := meshConfigMapName
+	if rev != DefaultRevision {
+		cmName = fmt.Sprintf("%s-%s", meshConfigMapName, rev)
+	}
+	cm, err := clientset.CoreV1().ConfigMaps(istiodNamespace).Get(ctx, cmName, metav1.GetOptions{})
+	if err != nil {
+		return istiodNamespace, fmt.Errorf("failed to get mesh config %s: %w", cmName, err)
+	}
+
+	var mesh meshConfig
+	if err := yaml.Unmarshal([]byte(cm.Data["mesh"]), &mesh); err != nil {
+		return istiodNamespace, fmt.Errorf("failed to parse mesh config %s: %w", cmName, err)
+	}
+	if mesh.RootNamespace == "" {
+		return istiodNamespace, nil
+	}
+	return mesh.RootNamespace, nil
+}
+
+// ParseInjectorConfig parses the "config" key of the istio-sidecar-injector ConfigMap
+func ParseInjectorConfig(data string) (*InjectorConfig, error) {
+	cfg := &InjectorConfig{}
+	if err := yaml.Unmarshal([]byte(data), cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Policy == "" {
+		cfg.Policy = injectionPolicyEnabled
+	}
+	return cfg, nil
+}
+
 func analyzeWebhooksMatchStatus(whs []admissionregistrationv1.MutatingWebhook, podLabels, nsLabels map[string]string) (injected bool) {
 	for _, wh := range whs {
 		nsMatched, nsLabel := extractMatchedSelectorInfo(wh.NamespaceSelector, nsLabels)
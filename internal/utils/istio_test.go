@@ -0,0 +1,199 @@
+//go:build test || unit
+
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLoadInjectorConfigs(t *testing.T) {
+	ctx := context.Background()
+
+	newWebhook := func(name, rev, namespace string) admissionregistrationv1.MutatingWebhookConfiguration {
+		return admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{RevisionLabel: rev}},
+			Webhooks: []admissionregistrationv1.MutatingWebhook{{
+				Name:         "namespace.sidecar-injector.istio.io",
+				ClientConfig: admissionregistrationv1.WebhookClientConfig{Service: &admissionregistrationv1.ServiceReference{Name: "istiod", Namespace: namespace}},
+			}},
+		}
+	}
+
+	tests := []struct {
+		name            string
+		kubeObjects     []runtime.Object
+		webhooks        []admissionregistrationv1.MutatingWebhookConfiguration
+		expectedConfigs map[string]*InjectorConfig
+		expectError     bool
+	}{
+		{
+			name: "Default and canary revisions with policies, selectors and proxy resources",
+			kubeObjects: []runtime.Object{
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: "istio-sidecar-injector", Namespace: "istio-system"},
+					Data: map[string]string{
+						"config": "policy: enabled\nneverInjectSelector:\n- matchLabels:\n    tier: legacy\ntemplates: {}\n",
+						"values": `{"global":{"proxy":{"resources":{"requests":{"cpu":"100m","memory":"128Mi"},"limits":{"cpu":"2","memory":"1Gi"}}}}}`,
+					},
+				},
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: "istio-sidecar-injector-canary", Namespace: "istio-canary"},
+					Data:       map[string]string{"config": "policy: disabled\n"},
+				},
+			},
+			webhooks: []admissionregistrationv1.MutatingWebhookConfiguration{
+				newWebhook("istio-revision-tag-default", "default", "istio-system"),
+				newWebhook("istio-sidecar-injector", "default", "istio-system"),
+				newWebhook("istio-sidecar-injector-canary", "canary", "istio-canary"),
+			},
+			expectedConfigs: map[string]*InjectorConfig{
+				"default": {
+					Policy:              "enabled",
+					NeverInjectSelector: []metav1.LabelSelector{{MatchLabels: map[string]string{"tier": "legacy"}}},
+					ProxyResources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("128Mi")},
+						Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+					},
+				},
+				"canary": {Policy: "disabled"},
+			},
+			expectError: false,
+		},
+		{
+			name:            "Missing ConfigMap is left out and reported",
+			kubeObjects:     []runtime.Object{},
+			webhooks:        []admissionregistrationv1.MutatingWebhookConfiguration{newWebhook("istio-sidecar-injector", "default", "istio-system")},
+			expectedConfigs: map[string]*InjectorConfig{},
+			expectError:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configs, err := LoadInjectorConfigs(ctx, fake.NewSimpleClientset(tt.kubeObjects...), tt.webhooks)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			require.Len(t, configs, len(tt.expectedConfigs))
+			for rev, expected := range tt.expectedConfigs {
+				actual, ok := configs[rev]
+				require.True(t, ok, "revision %s missing", rev)
+				assert.Equal(t, expected.Policy, actual.Policy)
+				assert.Equal(t, expected.NeverInjectSelector, actual.NeverInjectSelector)
+				assert.Equal(t, expected.AlwaysInjectSelector, actual.AlwaysInjectSelector)
+				for name, quantity := range expected.ProxyResources.Requests {
+					assert.True(t, quantity.Equal(actual.ProxyResources.Requests[name]), "request %s mismatch", name)
+				}
+				for name, quantity := range expected.ProxyResources.Limits {
+					assert.True(t, quantity.Equal(actual.ProxyResources.Limits[name]), "limit %s mismatch", name)
+				}
+			}
+		})
+	}
+}
+
+func TestMeshRootNamespace(t *testing.T) {
+	ctx := context.Background()
+	newMeshConfig := func(name, namespace, mesh string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}, Data: map[string]string{"mesh": mesh}}
+	}
+
+	tests := []struct {
+		name              string
+		kubeObjects       []runtime.Object
+		istiodNamespace   string
+		rev               string
+		expectedNamespace string
+		expectError       bool
+	}{
+		{
+			name:              "Root namespace set by the mesh config",
+			kubeObjects:       []runtime.Object{newMeshConfig("istio", "istio-system", "rootNamespace: istio-config\ntrustDomain: cluster.local\n")},
+			istiodNamespace:   "istio-system",
+			rev:               DefaultRevision,
+			expectedNamespace: "istio-config",
+		},
+		{
+			name:              "Revisioned mesh config",
+			kubeObjects:       []runtime.Object{newMeshConfig("istio-canary", "istio-canary", "rootNamespace: mesh-root\n")},
+			istiodNamespace:   "istio-canary",
+			rev:               "canary",
+			expectedNamespace: "mesh-root",
+		},
+		{
+			name:              "Root namespace defaults to istiod's namespace",
+			kubeObjects:       []runtime.Object{newMeshConfig("istio", "istio-control", "trustDomain: cluster.local\n")},
+			istiodNamespace:   "istio-control",
+			rev:               DefaultRevision,
+			expectedNamespace: "istio-control",
+		},
+		{
+			name:              "Missing mesh config falls back to istiod's namespace and is reported",
+			istiodNamespace:   "istio-control",
+			rev:               DefaultRevision,
+			expectedNamespace: "istio-control",
+			expectError:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rootNamespace, err := MeshRootNamespace(ctx, fake.NewSimpleClientset(tt.kubeObjects...), tt.istiodNamespace, tt.rev)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedNamespace, rootNamespace)
+		})
+	}
+}
+
+func TestImageVersion(t *testing.T) {
+	tests := []struct {
+		image    string
+		expected string
+	}{
+		{image: "docker.io/istio/pilot:1.25.0", expected: "1.25.0"},
+		{image: "gcr.io/istio-release/pilot:1.24.3-distroless", expected: "1.24.3-distroless"},
+		{image: "registry.local:5000/istio/pilot:1.23.1", expected: "1.23.1"},
+		{image: "registry.local:5000/istio/pilot", expected: ""},
+		{image: "docker.io/istio/pilot:1.25.0@sha256:0123456789abcdef", expected: "1.25.0"},
+		{image: "docker.io/istio/pilot@sha256:0123456789abcdef", expected: ""},
+		{image: "pilot", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ImageVersion(tt.image))
+		})
+	}
+}
+
+func TestRevisionTags(t *testing.T) {
+	newWebhook := func(name string, labels map[string]string) admissionregistrationv1.MutatingWebhookConfiguration {
+		return admissionregistrationv1.MutatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+
+	webhooks := []admissionregistrationv1.MutatingWebhookConfiguration{
+		newWebhook("istio-sidecar-injector", map[string]string{}),
+		newWebhook("istio-sidecar-injector-1-25", map[string]string{RevisionLabel: "1-25"}),
+		newWebhook("istio-revision-tag-default", map[string]string{RevisionLabel: "default", RevisionTagLabel: "default"}),
+		newWebhook("istio-revision-tag-stable", map[string]string{RevisionLabel: "1-25", RevisionTagLabel: "stable"}),
+	}
+
+	assert.Equal(t, map[string]string{"default": "default", "stable": "1-25"}, RevisionTags(webhooks))
+}
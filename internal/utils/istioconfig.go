@@ -0,0 +1,109 @@
+package utils
+
+// Helpers to take an inventory of Istio's configuration resources, without reading anything but their namespace
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+)
+
+// istioConfigResource is an Istio configuration resource which is counted
+type istioConfigResource struct {
+	Kind     string
+	Group    string
+	Resource string
+}
+
+// istioConfigResources are the Istio configuration resources which are counted
+var istioConfigResources = []istioConfigResource{
+	{Kind: "VirtualService", Group: "networking.istio.io", Resource: "virtualservices"},
+	{Kind: "DestinationRule", Group: "networking.istio.io", Resource: "destinationrules"},
+	{Kind: "ServiceEntry", Group: "networking.istio.io", Resource: "serviceentries"},
+	{Kind: "AuthorizationPolicy", Group: "security.istio.io", Resource: "authorizationpolicies"},
+	{Kind: "PeerAuthentication", Group: "security.istio.io", Resource: "peerauthentications"},
+	{Kind: "RequestAuthentication", Group: "security.istio.io", Resource: "requestauthentications"},
+	{Kind: "Telemetry", Group: "telemetry.istio.io", Resource: "telemetries"},
+	{Kind: "WasmPlugin", Group: "extensions.istio.io", Resource: "wasmplugins"},
+}
+
+// IstioConfigCounts is the number of Istio configuration objects of each kind
+type IstioConfigCounts struct {
+	// Namespaces are the number of objects of each kind, keyed by namespace then kind. Kinds without objects are left out.
+	Namespaces map[string]map[string]int
+	// Totals are the number of objects of each kind across the cluster. Kinds whose CRD isn't installed are left out.
+	Totals map[string]int
+}
+
+// CountIstioConfig counts the Istio configuration objects of each kind in each namespace. Discovery is used to find the
+// preferred version of each resource, and resources whose CRD isn't installed are skipped.
+// If some resources can't be listed, the counts of the others are still returned along with the error.
+func CountIstioConfig(ctx context.Context, discoveryClient discovery.DiscoveryInterface, dynamicClient dynamic.Interface) (*IstioConfigCounts, error) {
+	counts := &IstioConfigCounts{
+		Namespaces: make(map[string]map[string]int),
+		Totals:     make(map[string]int),
+	}
+
+	groups, err := discoveryClient.ServerGroups()
+	if err != nil {
+		return counts, fmt.Errorf("failed to discover API groups: %w", err)
+	}
+	preferredVersions := make(map[string]string)
+	for _, group := range groups.Groups {
+		preferredVersions[group.Name] = group.PreferredVersion.Version
+	}
+
+	// the resources served by each group version, which are only discovered once per group version
+	servedResources := make(map[string]map[string]struct{})
+
+	var errs []error
+	for _, config := range istioConfigResources {
+		version, ok := preferredVersions[config.Group]
+		if !ok {
+			continue
+		}
+		gvr := schema.GroupVersionResource{Group: config.Group, Version: version, Resource: config.Resource}
+
+		groupVersion := gvr.GroupVersion().String()
+		if _, ok := servedResources[groupVersion]; !ok {
+			servedResources[groupVersion] = make(map[string]struct{})
+			resources, err := discoveryClient.ServerResourcesForGroupVersion(groupVersion)
+			if err != nil && !errors.IsNotFound(err) {
+				errs = append(errs, fmt.Errorf("failed to discover resources of %s: %w", groupVersion, err))
+			}
+			if resources != nil {
+				for _, resource := range resources.APIResources {
+					servedResources[groupVersion][resource.Name] = struct{}{}
+				}
+			}
+		}
+		if _, ok := servedResources[groupVersion][config.Resource]; !ok {
+			continue
+		}
+
+		list, err := dynamicClient.Resource(gvr).Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to list %s: %w", gvr.String(), err))
+			continue
+		}
+
+		counts.Totals[config.Kind] = len(list.Items)
+		for _, item := range list.Items {
+			namespace := item.GetNamespace()
+			if counts.Namespaces[namespace] == nil {
+				counts.Namespaces[namespace] = make(map[string]int)
+			}
+			counts.Namespaces[namespace][config.Kind]++
+		}
+	}
+
+	if len(errs) > 0 {
+		return counts, fmt.Errorf("encountered %d errors counting istio config: %v", len(errs), errs)
+	}
+	return counts, nil
+}
@@ -0,0 +1,76 @@
+//go:build test || unit
+
+package utils
+
+import (
+	"context"
+	"testing"
+
+	testutils "github.com/solo-io/istio-usage-collector/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestCountIstioConfig(t *testing.T) {
+	ctx := context.Background()
+
+	// Only the networking and security CRDs are installed, with security.istio.io only serving v1beta1
+	discoveryResources := []*metav1.APIResourceList{
+		{GroupVersion: "networking.istio.io/v1", APIResources: []metav1.APIResource{
+			{Name: "virtualservices", Kind: "VirtualService", Namespaced: true},
+			{Name: "destinationrules", Kind: "DestinationRule", Namespaced: true},
+		}},
+		{GroupVersion: "security.istio.io/v1beta1", APIResources: []metav1.APIResource{
+			{Name: "authorizationpolicies", Kind: "AuthorizationPolicy", Namespaced: true},
+		}},
+	}
+	objects := []runtime.Object{
+		testutils.NewIstioObject("networking.istio.io/v1", "VirtualService", "app", "reviews", nil),
+		testutils.NewIstioObject("networking.istio.io/v1", "VirtualService", "app", "ratings", nil),
+		testutils.NewIstioObject("networking.istio.io/v1", "VirtualService", "web", "frontend", nil),
+		testutils.NewIstioObject("security.istio.io/v1beta1", "AuthorizationPolicy", "app", "allow-web", nil),
+	}
+
+	t.Run("Objects are counted per namespace and kind", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset()
+		clientset.Resources = discoveryResources
+		dynamicClient := testutils.NewIstioDynamicClient(objects...)
+
+		counts, err := CountIstioConfig(ctx, clientset.Discovery(), dynamicClient)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]map[string]int{
+			"app": {"VirtualService": 2, "AuthorizationPolicy": 1},
+			"web": {"VirtualService": 1},
+		}, counts.Namespaces)
+		// kinds without objects are part of the totals, unlike kinds whose CRD isn't installed
+		assert.Equal(t, map[string]int{"VirtualService": 3, "DestinationRule": 0, "AuthorizationPolicy": 1}, counts.Totals)
+	})
+
+	t.Run("Resources which can't be listed are skipped", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset()
+		clientset.Resources = discoveryResources
+		dynamicClient := testutils.NewIstioDynamicClient(objects...)
+		dynamicClient.PrependReactor("list", "authorizationpolicies", func(action clienttesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewForbidden(action.GetResource().GroupResource(), "", nil)
+		})
+
+		counts, err := CountIstioConfig(ctx, clientset.Discovery(), dynamicClient)
+		assert.Error(t, err)
+		require.NotNil(t, counts)
+		assert.Equal(t, map[string]int{"VirtualService": 3, "DestinationRule": 0}, counts.Totals)
+	})
+
+	t.Run("Nothing is counted if istio isn't installed", func(t *testing.T) {
+		dynamicClient := testutils.NewIstioDynamicClient()
+
+		counts, err := CountIstioConfig(ctx, fake.NewSimpleClientset().Discovery(), dynamicClient)
+		require.NoError(t, err)
+		assert.Empty(t, counts.Namespaces)
+		assert.Empty(t, counts.Totals)
+	})
+}
@@ -6,7 +6,9 @@ import (
 	"os"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
 )
@@ -30,14 +32,14 @@ func GetCurrentContext() (string, error) {
 	return rawConfig.CurrentContext, nil
 }
 
-// createKubernetesClients creates Kubernetes clients for the specified context
-func CreateKubernetesClients(ctx context.Context, kubeContext string) (*kubernetes.Clientset, *metricsv.Clientset, bool, error) {
+// restConfig builds the client configuration for the specified context
+func restConfig(kubeContext string) (*rest.Config, error) {
 	// Get kubeconfig path
 	kubeconfigPath := os.Getenv("KUBECONFIG")
 	if kubeconfigPath == "" {
 		home := os.Getenv("HOME")
 		if home == "" {
-			return nil, nil, false, fmt.Errorf("HOME environment variable not set")
+			return nil, fmt.Errorf("HOME environment variable not set")
 		}
 		kubeconfigPath = fmt.Sprintf("%s/.kube/config", home)
 	}
@@ -47,13 +49,23 @@ func CreateKubernetesClients(ctx context.Context, kubeContext string) (*kubernet
 		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath},
 		&clientcmd.ConfigOverrides{CurrentContext: kubeContext}).ClientConfig()
 	if err != nil {
-		return nil, nil, false, fmt.Errorf("failed to create Kubernetes config: %w", err)
+		return nil, fmt.Errorf("failed to create Kubernetes config: %w", err)
 	}
 
 	// Increase QPS and burst to avoid client-side throttling
 	config.QPS = 100
 	config.Burst = 100
 
+	return config, nil
+}
+
+// createKubernetesClients creates Kubernetes clients for the specified context
+func CreateKubernetesClients(ctx context.Context, kubeContext string) (*kubernetes.Clientset, *metricsv.Clientset, bool, error) {
+	config, err := restConfig(kubeContext)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
 	// Create clientset
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
@@ -81,3 +93,17 @@ func CreateKubernetesClients(ctx context.Context, kubeContext string) (*kubernet
 
 	return clientset, metricsClient, hasMetrics, nil
 }
+
+// CreateDynamicClient creates a dynamic client for the specified context, used to read custom resources such as Istio's configuration
+func CreateDynamicClient(kubeContext string) (dynamic.Interface, error) {
+	config, err := restConfig(kubeContext)
+	if err != nil {
+		return nil, err
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	return dynamicClient, nil
+}
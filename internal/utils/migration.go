@@ -0,0 +1,40 @@
+package utils
+
+// Helpers to find Istio resources which only apply to sidecar proxies, and don't carry over to ambient mode:
+// https://istio.io/latest/docs/ambient/migrate/
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// CountEnvoyFilters counts the EnvoyFilters of every namespace, keyed by namespace.
+// If the EnvoyFilter CRD isn't installed, no EnvoyFilters are counted.
+func CountEnvoyFilters(ctx context.Context, dynamicClient dynamic.Interface) (map[string]int, error) {
+	items, err := ListIstioResources(ctx, dynamicClient, networkingGroup, "envoyfilters", "v1alpha3")
+	if err != nil {
+		return nil, err
+	}
+	return countByNamespace(items), nil
+}
+
+// CountProxyConfigs counts the ProxyConfigs of every namespace, keyed by namespace.
+// If the ProxyConfig CRD isn't installed, no ProxyConfigs are counted.
+func CountProxyConfigs(ctx context.Context, dynamicClient dynamic.Interface) (map[string]int, error) {
+	items, err := ListIstioResources(ctx, dynamicClient, networkingGroup, "proxyconfigs", "v1beta1")
+	if err != nil {
+		return nil, err
+	}
+	return countByNamespace(items), nil
+}
+
+// countByNamespace counts the objects of each namespace
+func countByNamespace(items []unstructured.Unstructured) map[string]int {
+	counts := make(map[string]int)
+	for i := range items {
+		counts[items[i].GetNamespace()]++
+	}
+	return counts
+}
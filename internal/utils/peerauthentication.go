@@ -0,0 +1,83 @@
+package utils
+
+// Helpers to evaluate Istio PeerAuthentications, which define the mTLS mode of the traffic workloads accept:
+// https://istio.io/latest/docs/reference/config/security/peer_authentication/
+
+import (
+	"context"
+	"sort"
+
+	"k8s.io/client-go/dynamic"
+)
+
+// mTLS modes of a PeerAuthentication
+const (
+	// MTLSModeUnset inherits the mode of the parent policy
+	MTLSModeUnset = "UNSET"
+	// MTLSModeDisable doesn't use mTLS
+	MTLSModeDisable = "DISABLE"
+	// MTLSModePermissive accepts both plaintext and mTLS traffic, and is the default if no policy sets a mode
+	MTLSModePermissive = "PERMISSIVE"
+	// MTLSModeStrict only accepts mTLS traffic
+	MTLSModeStrict = "STRICT"
+)
+
+// PeerAuthentication is the subset of an Istio PeerAuthentication used to evaluate the mTLS mode of a namespace
+type PeerAuthentication struct {
+	Name      string
+	Namespace string
+	// HasSelector is true if the policy only applies to the selected workloads, rather than the whole namespace
+	HasSelector bool
+	// Mode is the mTLS mode of the policy, empty if it inherits the mode of its parent
+	Mode string
+	// PortOverrides is the number of ports setting their own mTLS mode
+	PortOverrides int
+}
+
+// peerAuthenticationSpec is the subset of the PeerAuthentication spec which is read
+type peerAuthenticationSpec struct {
+	Selector *struct {
+		MatchLabels map[string]string `json:"matchLabels"`
+	} `json:"selector"`
+	MTLS *struct {
+		Mode string `json:"mode"`
+	} `json:"mtls"`
+	PortLevelMTLS map[string]interface{} `json:"portLevelMtls"`
+}
+
+// ListPeerAuthentications lists the PeerAuthentications of every namespace, keyed by namespace.
+// Each namespace's policies are ordered oldest first, as Istio uses the oldest one if several apply to the same workloads.
+// If the PeerAuthentication CRD isn't installed, no policies are returned.
+func ListPeerAuthentications(ctx context.Context, dynamicClient dynamic.Interface) (map[string][]PeerAuthentication, error) {
+	items, err := ListIstioResources(ctx, dynamicClient, securityGroup, "peerauthentications", "v1", "v1beta1")
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		ti, tj := items[i].GetCreationTimestamp(), items[j].GetCreationTimestamp()
+		if !ti.Equal(&tj) {
+			return ti.Before(&tj)
+		}
+		return items[i].GetName() < items[j].GetName()
+	})
+
+	policies := make(map[string][]PeerAuthentication)
+	for i := range items {
+		var spec peerAuthenticationSpec
+		if err := decodeSpec(&items[i], &spec); err != nil {
+			return nil, err
+		}
+
+		policy := PeerAuthentication{
+			Name:          items[i].GetName(),
+			Namespace:     items[i].GetNamespace(),
+			HasSelector:   spec.Selector != nil && len(spec.Selector.MatchLabels) > 0,
+			PortOverrides: len(spec.PortLevelMTLS),
+		}
+		if spec.MTLS != nil && spec.MTLS.Mode != MTLSModeUnset {
+			policy.Mode = spec.MTLS.Mode
+		}
+		policies[policy.Namespace] = append(policies[policy.Namespace], policy)
+	}
+	return policies, nil
+}
@@ -0,0 +1,44 @@
+//go:build test || unit
+
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	testutils "github.com/solo-io/istio-usage-collector/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestListPeerAuthentications(t *testing.T) {
+	now := time.Now()
+	newPeerAuthentication := func(namespace, name string, created time.Time, spec map[string]interface{}) *unstructured.Unstructured {
+		policy := testutils.NewIstioObject("security.istio.io/v1", "PeerAuthentication", namespace, name, spec)
+		policy.SetCreationTimestamp(metav1.NewTime(created))
+		return policy
+	}
+	client := testutils.NewIstioDynamicClient(
+		newPeerAuthentication("istio-system", "default", now, map[string]interface{}{"mtls": map[string]interface{}{"mode": "STRICT"}}),
+		newPeerAuthentication("app", "newer", now, map[string]interface{}{"mtls": map[string]interface{}{"mode": "DISABLE"}}),
+		newPeerAuthentication("app", "older", now.Add(-time.Hour), map[string]interface{}{"mtls": map[string]interface{}{"mode": "PERMISSIVE"}}),
+		newPeerAuthentication("app", "legacy-ports", now, map[string]interface{}{
+			"selector":      map[string]interface{}{"matchLabels": map[string]interface{}{"app": "legacy"}},
+			"mtls":          map[string]interface{}{"mode": "UNSET"},
+			"portLevelMtls": map[string]interface{}{"8080": map[string]interface{}{"mode": "DISABLE"}, "9090": map[string]interface{}{"mode": "PERMISSIVE"}},
+		}),
+	)
+
+	policies, err := ListPeerAuthentications(context.Background(), client)
+	require.NoError(t, err)
+	assert.Equal(t, []PeerAuthentication{{Name: "default", Namespace: "istio-system", Mode: MTLSModeStrict}}, policies["istio-system"])
+	// policies are ordered oldest first, then by name
+	assert.Equal(t, []PeerAuthentication{
+		{Name: "older", Namespace: "app", Mode: MTLSModePermissive},
+		{Name: "legacy-ports", Namespace: "app", HasSelector: true, PortOverrides: 2},
+		{Name: "newer", Namespace: "app", Mode: MTLSModeDisable},
+	}, policies["app"])
+}
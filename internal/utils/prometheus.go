@@ -0,0 +1,201 @@
+package utils
+
+// A minimal client for Prometheus' HTTP API, used to read the historical usage of containers:
+// https://prometheus.io/docs/prometheus/latest/querying/api/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultPrometheusLookback is the default window of history to read from Prometheus
+	DefaultPrometheusLookback = 7 * 24 * time.Hour
+
+	// prometheusMaxPoints is the number of points each series is read at, which bounds the size of the responses
+	prometheusMaxPoints = 500
+	// prometheusMinStep is the smallest resolution series are read at
+	prometheusMinStep = time.Minute
+	// prometheusMinRateWindow is the smallest window the CPU usage rate is computed over, which spans several scrapes
+	prometheusMinRateWindow = 5 * time.Minute
+
+	// containerCPUQuery is the CPU usage (in cores) of each container of a namespace, averaged over the rate window
+	containerCPUQuery = `sum by (pod, container) (rate(container_cpu_usage_seconds_total{namespace=%q, container!="", container!="POD"}[%ds]))`
+	// containerMemoryQuery is the memory working set (in bytes) of each container of a namespace
+	containerMemoryQuery = `sum by (pod, container) (container_memory_working_set_bytes{namespace=%q, container!="", container!="POD"})`
+)
+
+// PrometheusConfig is the configuration to query a Prometheus-compatible server
+type PrometheusConfig struct {
+	// URL is the base URL of the server, such as http://prometheus.monitoring:9090
+	URL string
+	// Lookback is the window of history to read
+	Lookback time.Duration
+	// BearerToken is sent as a bearer token, if set
+	BearerToken string
+	// Username and Password are sent through basic authentication, if set
+	Username string
+	Password string
+}
+
+// PrometheusClient queries a Prometheus-compatible server
+type PrometheusClient struct {
+	config     PrometheusConfig
+	baseURL    *url.URL
+	httpClient *http.Client
+}
+
+// ContainerSeries is the value of a metric for a container over time
+type ContainerSeries struct {
+	Pod       string
+	Container string
+	// Samples are the values keyed by unix timestamp
+	Samples map[int64]float64
+}
+
+// prometheusResponse is the response of a range query
+type prometheusResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Values [][2]interface{}  `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// NewPrometheusClient creates a client for the server at the configured URL, using the default lookback if none is set
+func NewPrometheusClient(config PrometheusConfig) (*PrometheusClient, error) {
+	baseURL, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Prometheus URL %q: %w", config.URL, err)
+	}
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" {
+		return nil, fmt.Errorf("invalid Prometheus URL %q: the scheme must be http or https", config.URL)
+	}
+	if config.Lookback <= 0 {
+		config.Lookback = DefaultPrometheusLookback
+	}
+
+	return &PrometheusClient{
+		config:     config,
+		baseURL:    baseURL,
+		httpClient: &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+// RedactedURL returns the URL of a Prometheus server without the credentials it may contain, so it can be reported
+func RedactedURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.User == nil {
+		return rawURL
+	}
+	parsed.User = nil
+	return parsed.String()
+}
+
+// Lookback returns the window of history which is read
+func (c *PrometheusClient) Lookback() time.Duration {
+	return c.config.Lookback
+}
+
+// ContainerCPUUsage returns the CPU usage (in cores) of each container of a namespace over the lookback window
+func (c *PrometheusClient) ContainerCPUUsage(ctx context.Context, namespace string) ([]ContainerSeries, error) {
+	return c.queryContainers(ctx, fmt.Sprintf(containerCPUQuery, namespace, int64(rateWindow(c.step()).Seconds())))
+}
+
+// ContainerMemoryUsage returns the memory working set (in bytes) of each container of a namespace over the lookback window
+func (c *PrometheusClient) ContainerMemoryUsage(ctx context.Context, namespace string) ([]ContainerSeries, error) {
+	return c.queryContainers(ctx, fmt.Sprintf(containerMemoryQuery, namespace))
+}
+
+// step returns the resolution series are read at, in whole seconds, so that the lookback window is covered by at most prometheusMaxPoints
+func (c *PrometheusClient) step() time.Duration {
+	step := (c.config.Lookback / prometheusMaxPoints).Round(time.Second)
+	if step < prometheusMinStep {
+		step = prometheusMinStep
+	}
+	return step
+}
+
+// rateWindow returns the window the CPU usage rate is computed over when reading it at a step.
+// The window covers the whole step, so that the usage between two points isn't missed and spikes still show up in the peaks.
+func rateWindow(step time.Duration) time.Duration {
+	return max(step, prometheusMinRateWindow)
+}
+
+// queryContainers runs a range query over the lookback window, returning a series per pod and container
+func (c *PrometheusClient) queryContainers(ctx context.Context, query string) ([]ContainerSeries, error) {
+	end := time.Now()
+	start := end.Add(-c.config.Lookback)
+	step := c.step()
+
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", strconv.FormatInt(start.Unix(), 10))
+	params.Set("end", strconv.FormatInt(end.Unix(), 10))
+	params.Set("step", strconv.FormatFloat(step.Seconds(), 'f', -1, 64))
+	endpoint := c.baseURL.JoinPath("api", "v1", "query_range")
+	endpoint.RawQuery = params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Prometheus request: %w", err)
+	}
+	if c.config.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.BearerToken)
+	} else if c.config.Username != "" {
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query Prometheus: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body prometheusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to parse Prometheus response (status %d): %w", resp.StatusCode, err)
+	}
+	if body.Status != "success" {
+		return nil, fmt.Errorf("prometheus query failed (status %d): %s: %s", resp.StatusCode, body.ErrorType, body.Error)
+	}
+	if body.Data.ResultType != "matrix" {
+		return nil, fmt.Errorf("unexpected Prometheus result type %q", body.Data.ResultType)
+	}
+
+	series := make([]ContainerSeries, 0, len(body.Data.Result))
+	for _, result := range body.Data.Result {
+		containerSeries := ContainerSeries{
+			Pod:       result.Metric["pod"],
+			Container: result.Metric["container"],
+			Samples:   make(map[int64]float64, len(result.Values)),
+		}
+		for _, value := range result.Values {
+			timestamp, ok := value[0].(float64)
+			if !ok {
+				return nil, fmt.Errorf("unexpected Prometheus sample timestamp %v", value[0])
+			}
+			raw, ok := value[1].(string)
+			if !ok {
+				return nil, fmt.Errorf("unexpected Prometheus sample value %v", value[1])
+			}
+			sample, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected Prometheus sample value %q: %w", raw, err)
+			}
+			containerSeries.Samples[int64(timestamp)] = sample
+		}
+		series = append(series, containerSeries)
+	}
+	return series, nil
+}
@@ -0,0 +1,120 @@
+//go:build test || unit
+
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusClient(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("range queries are parsed into container series", func(t *testing.T) {
+		var requests []*http.Request
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r)
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[
+				{"metric":{"pod":"web-1","container":"app"},"values":[[1700000000,"0.5"],[1700000060,"0.25"]]},
+				{"metric":{"pod":"web-1","container":"istio-proxy"},"values":[[1700000000,"0.01"]]}
+			]}}`))
+		}))
+		defer server.Close()
+
+		client, err := NewPrometheusClient(PrometheusConfig{URL: server.URL + "/prometheus", Lookback: time.Hour, BearerToken: "secret"})
+		require.NoError(t, err)
+
+		series, err := client.ContainerCPUUsage(ctx, "app")
+		require.NoError(t, err)
+		assert.Equal(t, []ContainerSeries{
+			{Pod: "web-1", Container: "app", Samples: map[int64]float64{1700000000: 0.5, 1700000060: 0.25}},
+			{Pod: "web-1", Container: "istio-proxy", Samples: map[int64]float64{1700000000: 0.01}},
+		}, series)
+
+		require.Len(t, requests, 1)
+		assert.Equal(t, "/prometheus/api/v1/query_range", requests[0].URL.Path)
+		assert.Equal(t, "Bearer secret", requests[0].Header.Get("Authorization"))
+		query := requests[0].URL.Query()
+		assert.Contains(t, query.Get("query"), "container_cpu_usage_seconds_total")
+		assert.Contains(t, query.Get("query"), `namespace="app"`)
+		assert.Contains(t, query.Get("query"), "[300s]")
+		assert.Equal(t, "60", query.Get("step"))
+	})
+
+	t.Run("the CPU rate window tracks the step", func(t *testing.T) {
+		tests := []struct {
+			lookback     time.Duration
+			expectedStep string
+			expectedRate string
+		}{
+			// short lookbacks are read at the minimum step, with the minimum rate window
+			{lookback: time.Hour, expectedStep: "60", expectedRate: "[300s]"},
+			{lookback: 24 * time.Hour, expectedStep: "173", expectedRate: "[300s]"},
+			// longer steps widen the rate window, so the usage between two points isn't missed
+			{lookback: 2 * 24 * time.Hour, expectedStep: "346", expectedRate: "[346s]"},
+			{lookback: DefaultPrometheusLookback, expectedStep: "1210", expectedRate: "[1210s]"},
+		}
+		for _, tt := range tests {
+			var query url.Values
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				query = r.URL.Query()
+				_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[]}}`))
+			}))
+
+			client, err := NewPrometheusClient(PrometheusConfig{URL: server.URL, Lookback: tt.lookback})
+			require.NoError(t, err)
+			_, err = client.ContainerCPUUsage(ctx, "app")
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedStep, query.Get("step"), tt.lookback)
+			assert.Contains(t, query.Get("query"), tt.expectedRate, tt.lookback)
+			server.Close()
+		}
+	})
+
+	t.Run("basic authentication is used without a bearer token", func(t *testing.T) {
+		var username, password string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			username, password, _ = r.BasicAuth()
+			assert.True(t, strings.Contains(r.URL.Query().Get("query"), "container_memory_working_set_bytes"))
+			_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[]}}`))
+		}))
+		defer server.Close()
+
+		client, err := NewPrometheusClient(PrometheusConfig{URL: server.URL, Username: "user", Password: "password"})
+		require.NoError(t, err)
+		assert.Equal(t, DefaultPrometheusLookback, client.Lookback())
+
+		series, err := client.ContainerMemoryUsage(ctx, "app")
+		require.NoError(t, err)
+		assert.Empty(t, series)
+		assert.Equal(t, "user", username)
+		assert.Equal(t, "password", password)
+	})
+
+	t.Run("query errors are returned", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"parse error"}`))
+		}))
+		defer server.Close()
+
+		client, err := NewPrometheusClient(PrometheusConfig{URL: server.URL})
+		require.NoError(t, err)
+		_, err = client.ContainerCPUUsage(ctx, "app")
+		assert.ErrorContains(t, err, "parse error")
+	})
+
+	t.Run("invalid URLs are rejected", func(t *testing.T) {
+		_, err := NewPrometheusClient(PrometheusConfig{URL: "prometheus:9090"})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,77 @@
+package utils
+
+// Helpers to evaluate Istio Sidecar resources, which scope the configuration sidecar proxies receive: https://istio.io/latest/docs/reference/config/networking/sidecar/
+
+import (
+	"context"
+
+	"k8s.io/client-go/dynamic"
+)
+
+// networkingGroup is the API group of Istio's traffic management resources
+const networkingGroup = "networking.istio.io"
+
+// allHosts is the egress host which imports the services of every namespace, giving sidecars the configuration of the whole mesh
+const allHosts = "*/*"
+
+// Sidecar is the subset of an Istio Sidecar resource used to evaluate the scope of the configuration its proxies receive
+type Sidecar struct {
+	Name      string
+	Namespace string
+	// HasWorkloadSelector is true if the Sidecar only applies to the selected workloads, rather than the whole namespace
+	HasWorkloadSelector bool
+	// EgressHosts are the hosts of all egress listeners, in namespace/dnsName format
+	EgressHosts []string
+}
+
+// sidecarSpec is the subset of the Sidecar spec which is read
+type sidecarSpec struct {
+	WorkloadSelector *struct {
+		Labels map[string]string `json:"labels"`
+	} `json:"workloadSelector"`
+	Egress []struct {
+		Hosts []string `json:"hosts"`
+	} `json:"egress"`
+}
+
+// ScopesEgress checks if the Sidecar limits the configuration its proxies receive, which is the case if it
+// defines egress hosts and none of them import every host of every namespace
+func (s *Sidecar) ScopesEgress() bool {
+	if len(s.EgressHosts) == 0 {
+		return false
+	}
+	for _, host := range s.EgressHosts {
+		if host == allHosts {
+			return false
+		}
+	}
+	return true
+}
+
+// ListSidecars lists the Sidecar resources of every namespace, keyed by namespace.
+// If the Sidecar CRD isn't installed, no Sidecars are returned.
+func ListSidecars(ctx context.Context, dynamicClient dynamic.Interface) (map[string][]Sidecar, error) {
+	items, err := ListIstioResources(ctx, dynamicClient, networkingGroup, "sidecars", "v1", "v1beta1", "v1alpha3")
+	if err != nil {
+		return nil, err
+	}
+
+	sidecars := make(map[string][]Sidecar)
+	for i := range items {
+		var spec sidecarSpec
+		if err := decodeSpec(&items[i], &spec); err != nil {
+			return nil, err
+		}
+
+		sidecar := Sidecar{
+			Name:                items[i].GetName(),
+			Namespace:           items[i].GetNamespace(),
+			HasWorkloadSelector: spec.WorkloadSelector != nil && len(spec.WorkloadSelector.Labels) > 0,
+		}
+		for _, egress := range spec.Egress {
+			sidecar.EgressHosts = append(sidecar.EgressHosts, egress.Hosts...)
+		}
+		sidecars[sidecar.Namespace] = append(sidecars[sidecar.Namespace], sidecar)
+	}
+	return sidecars, nil
+}
@@ -0,0 +1,79 @@
+//go:build test || unit
+
+package utils
+
+import (
+	"context"
+	"testing"
+
+	testutils "github.com/solo-io/istio-usage-collector/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestListSidecars(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Sidecars are grouped by namespace", func(t *testing.T) {
+		client := testutils.NewIstioDynamicClient(
+			testutils.NewIstioObject("networking.istio.io/v1", "Sidecar", "istio-system", "default", map[string]interface{}{
+				"egress": []interface{}{map[string]interface{}{"hosts": []interface{}{"./*", "istio-system/*"}}},
+			}),
+			testutils.NewIstioObject("networking.istio.io/v1", "Sidecar", "app", "reviews", map[string]interface{}{
+				"workloadSelector": map[string]interface{}{"labels": map[string]interface{}{"app": "reviews"}},
+				"egress": []interface{}{
+					map[string]interface{}{"hosts": []interface{}{"./*"}},
+					map[string]interface{}{"hosts": []interface{}{"*/*"}},
+				},
+			}),
+		)
+
+		sidecars, err := ListSidecars(ctx, client)
+		require.NoError(t, err)
+		assert.Equal(t, map[string][]Sidecar{
+			"istio-system": {{Name: "default", Namespace: "istio-system", EgressHosts: []string{"./*", "istio-system/*"}}},
+			"app":          {{Name: "reviews", Namespace: "app", HasWorkloadSelector: true, EgressHosts: []string{"./*", "*/*"}}},
+		}, sidecars)
+		assert.True(t, sidecars["istio-system"][0].ScopesEgress())
+		assert.False(t, sidecars["app"][0].ScopesEgress())
+	})
+
+	t.Run("Older versions are used if v1 isn't served", func(t *testing.T) {
+		client := testutils.NewIstioDynamicClient(testutils.NewIstioObject("networking.istio.io/v1beta1", "Sidecar", "app", "default", map[string]interface{}{}))
+		client.PrependReactor("list", "sidecars", func(action clienttesting.Action) (bool, runtime.Object, error) {
+			if action.GetResource().Version == "v1" {
+				return true, nil, apierrors.NewNotFound(action.GetResource().GroupResource(), "")
+			}
+			return false, nil, nil
+		})
+
+		sidecars, err := ListSidecars(ctx, client)
+		require.NoError(t, err)
+		require.Len(t, sidecars["app"], 1)
+		assert.False(t, sidecars["app"][0].ScopesEgress())
+	})
+
+	t.Run("No sidecars are returned if the CRD isn't installed", func(t *testing.T) {
+		client := testutils.NewIstioDynamicClient()
+		client.PrependReactor("list", "sidecars", func(action clienttesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewNotFound(action.GetResource().GroupResource(), "")
+		})
+
+		sidecars, err := ListSidecars(ctx, client)
+		require.NoError(t, err)
+		assert.Empty(t, sidecars)
+	})
+
+	t.Run("Other errors are returned", func(t *testing.T) {
+		client := testutils.NewIstioDynamicClient()
+		client.PrependReactor("list", "sidecars", func(action clienttesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewForbidden(action.GetResource().GroupResource(), "", nil)
+		})
+
+		_, err := ListSidecars(ctx, client)
+		assert.Error(t, err)
+	})
+}
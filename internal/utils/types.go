@@ -1,5 +1,10 @@
 package utils
 
+import "time"
+
+// DefaultSampleInterval is the default time between two samples of the metrics API
+const DefaultSampleInterval = 30 * time.Second
+
 // Config represents the configuration for the cluster information gatherer
 type Config struct {
 	// KubeContext is the name of the Kubernetes context to use
@@ -26,4 +31,35 @@ type Config struct {
 	// MaxProcessors is the maximum number of processors to use.
 	// By default, all available processors will be used.
 	MaxProcessors int
+
+	// IncludeNonRunningPods indicates whether pods which aren't running (pending, succeeded, failed or unknown)
+	// should contribute to the resource totals
+	IncludeNonRunningPods bool
+
+	// IncludeWorkloads indicates whether to break each namespace down by the top-level controller of its pods
+	IncludeWorkloads bool
+
+	// Prometheus is the configuration of the Prometheus server to read historical usage from, only queried if its URL is set
+	Prometheus PrometheusConfig
+
+	// SampleDuration is how long to sample the metrics API for, metrics are only gathered once if it isn't positive
+	SampleDuration time.Duration
+
+	// SampleInterval is the time between two samples of the metrics API
+	SampleInterval time.Duration
+
+	// CollectEnvoyStats is true if the Envoy stats of each sidecar are read to report the traffic and configuration size of each namespace
+	CollectEnvoyStats bool
+
+	// FailFast is true if the collection fails when a namespace or node can't be collected, rather than recording the error
+	// in the output and continuing with the others
+	FailFast bool
+
+	// CollectorVersion is the version of the collector, which an interrupted collection must match to be continued
+	CollectorVersion string
+	// CollectorCommit is the git commit the collector was built from
+	CollectorCommit string
+
+	// Flags are the flags the collector was run with, keyed by name and without secrets, reported in the output's metadata
+	Flags map[string]string
 }
@@ -1,32 +1,327 @@
 package models
 
+import "time"
+
+// SchemaVersion is the version of the structure of the cluster info, incremented when it changes incompatibly
+const SchemaVersion = 1
+
 // ClusterInfo represents the top level structure for a Kubernetes cluster
 type ClusterInfo struct {
+	// Metadata describes the collection which produced the cluster info
+	Metadata   *Metadata                 `json:"metadata,omitempty" yaml:"metadata,omitempty"`
 	Name       string                    `json:"name" yaml:"name"`
 	Namespaces map[string]*NamespaceInfo `json:"namespaces" yaml:"namespaces"`
 	Nodes      map[string]NodeInfo       `json:"nodes" yaml:"nodes"`
 	HasMetrics bool                      `json:"has_metrics" yaml:"has_metrics"`
+	// SidecarDefaults are the mesh-wide default sidecar proxy resources, keyed by istio revision
+	SidecarDefaults map[string]ProxyResources `json:"sidecar_defaults,omitempty" yaml:"sidecar_defaults,omitempty"`
+	// IstioConfig is the number of Istio configuration objects of each kind across the cluster, only including kinds whose CRD is installed
+	IstioConfig map[string]int `json:"istio_config,omitempty" yaml:"istio_config,omitempty"`
+	// ControlPlane is the istio control plane, only set if istiod or its webhooks are found
+	ControlPlane *ControlPlaneInfo `json:"control_plane,omitempty" yaml:"control_plane,omitempty"`
+	// MigrationReadiness is the analysis of what blocks each namespace from moving to ambient mode, only set if Istio's resources could be read
+	MigrationReadiness *MigrationReadiness `json:"migration_readiness,omitempty" yaml:"migration_readiness,omitempty"`
+	// Partial is true if the collection was interrupted or failed before completing, in which case the file is a checkpoint
+	// which is missing some namespaces or nodes, and can be continued from with --continue
+	Partial bool `json:"partial,omitempty" yaml:"partial,omitempty"`
+	// CollectionErrors are the namespaces and nodes which couldn't be collected, and are missing from the output
+	CollectionErrors []CollectionError `json:"collection_errors,omitempty" yaml:"collection_errors,omitempty"`
+}
+
+// Metadata represents when, how and by which build of the collector the cluster info was collected
+type Metadata struct {
+	// SchemaVersion is the version of the structure of the cluster info
+	SchemaVersion int `json:"schema_version" yaml:"schema_version"`
+	// CollectorVersion and CollectorCommit identify the build of the collector
+	CollectorVersion string `json:"collector_version,omitempty" yaml:"collector_version,omitempty"`
+	CollectorCommit  string `json:"collector_commit,omitempty" yaml:"collector_commit,omitempty"`
+	// KubernetesVersion is the version of the Kubernetes API server, empty if it couldn't be read
+	KubernetesVersion string `json:"kubernetes_version,omitempty" yaml:"kubernetes_version,omitempty"`
+	// StartedAt is when the collection started, which is when its first run started if it was continued
+	StartedAt time.Time `json:"started_at" yaml:"started_at"`
+	// FinishedAt is when the collection finished, only set once it completed
+	FinishedAt *time.Time `json:"finished_at,omitempty" yaml:"finished_at,omitempty"`
+	// DurationSeconds is the time between the start and the end of the collection
+	DurationSeconds float64 `json:"duration_seconds,omitempty" yaml:"duration_seconds,omitempty"`
+	// Flags are the flags the collector was run with, keyed by name, without secrets
+	Flags map[string]string `json:"flags,omitempty" yaml:"flags,omitempty"`
+}
+
+// CollectionError represents a namespace or node which couldn't be collected
+type CollectionError struct {
+	// Kind is the kind of the object which couldn't be collected, either namespace or node
+	Kind string `json:"kind" yaml:"kind"`
+	// Name is the name of the namespace or node
+	Name string `json:"name" yaml:"name"`
+	// Class is the class of the error, such as forbidden, timeout or throttled
+	Class string `json:"class" yaml:"class"`
+	// Retries is the number of times collecting the object was retried before giving up
+	Retries int `json:"retries" yaml:"retries"`
+	// Message is the error message, which is left out when hiding names as it may contain them
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+}
+
+// Categories of migration readiness findings
+const (
+	// FindingBlocker is a finding which must be addressed before the namespace can move to ambient mode
+	FindingBlocker = "blocker"
+	// FindingNeedsWaypoint is a finding which requires a waypoint proxy to keep working in ambient mode
+	FindingNeedsWaypoint = "needs-waypoint"
+	// FindingWarning is a finding which doesn't prevent moving to ambient mode, but behaves differently in it
+	FindingWarning = "warning"
+)
+
+// Severities of migration readiness findings
+const (
+	SeverityHigh   = "high"
+	SeverityMedium = "medium"
+	SeverityLow    = "low"
+)
+
+// MigrationReadiness represents whether each namespace can move to ambient mode today
+type MigrationReadiness struct {
+	// MeshWide are the findings from the resources in the Istio root namespace, which apply to every namespace
+	MeshWide []ReadinessFinding `json:"mesh_wide,omitempty" yaml:"mesh_wide,omitempty"`
+	// Namespaces are the readiness of each namespace, keyed the same way as the cluster's namespaces
+	Namespaces map[string]*NamespaceReadiness `json:"namespaces" yaml:"namespaces"`
+}
+
+// NamespaceReadiness represents whether a namespace can move to ambient mode today
+type NamespaceReadiness struct {
+	// Ready is true if neither the namespace nor the mesh-wide resources have blockers
+	Ready bool `json:"ready" yaml:"ready"`
+	// NeedsWaypoint is true if the namespace or the mesh-wide resources rely on L7 features, which require a waypoint proxy
+	NeedsWaypoint bool `json:"needs_waypoint" yaml:"needs_waypoint"`
+	// Findings are the namespace's own findings, mesh-wide findings aren't repeated
+	Findings []ReadinessFinding `json:"findings,omitempty" yaml:"findings,omitempty"`
+}
+
+// ReadinessFinding represents a group of resources affecting the move to ambient mode
+type ReadinessFinding struct {
+	// Kind is the kind of resource the finding is about, such as EnvoyFilter or AuthorizationPolicy
+	Kind string `json:"kind" yaml:"kind"`
+	// Category is either "blocker", "needs-waypoint" or "warning"
+	Category string `json:"category" yaml:"category"`
+	// Severity is either "high", "medium" or "low"
+	Severity string `json:"severity" yaml:"severity"`
+	// Count is the number of resources (or pods) the finding applies to
+	Count   int    `json:"count" yaml:"count"`
+	Message string `json:"message" yaml:"message"`
+}
+
+// ControlPlaneInfo represents the istio control plane of a cluster
+type ControlPlaneInfo struct {
+	// Revisions are the istiod deployments, keyed by istio revision
+	Revisions map[string]*RevisionInfo `json:"revisions" yaml:"revisions"`
+	// RevisionTags are the revision each revision tag points to, keyed by tag
+	RevisionTags map[string]string `json:"revision_tags,omitempty" yaml:"revision_tags,omitempty"`
+}
+
+// RevisionInfo represents an istio revision's istiod deployment
+type RevisionInfo struct {
+	// Version is the image tag of istiod, if the image is tagged
+	Version string `json:"version" yaml:"version"`
+	// Replicas is the desired number of istiod replicas
+	Replicas int `json:"replicas" yaml:"replicas"`
+	// ReadyReplicas is the number of istiod replicas which are ready
+	ReadyReplicas int `json:"ready_replicas" yaml:"ready_replicas"`
+	// Resources are the resources of istiod's running pods
+	Resources ContainerResources `json:"resources" yaml:"resources"`
 }
 
 // NamespaceInfo represents information about a Kubernetes namespace
 type NamespaceInfo struct {
+	// Pods is the number of pods contributing to the resource totals, which are only running pods unless non-running pods are included
 	Pods int `json:"pods" yaml:"pods"`
+	// NonRunningPods is the number of pods per phase which aren't running, only set if there is at least one
+	NonRunningPods *PodPhaseCounts `json:"non_running_pods,omitempty" yaml:"non_running_pods,omitempty"`
 	// IsIstioInjected is true the namespace contains at least one pod with istio injection enabled
-	IsIstioInjected bool         `json:"is_istio_injected" yaml:"is_istio_injected"`
-	Resources       ResourceInfo `json:"resources" yaml:"resources"`
+	IsIstioInjected bool `json:"is_istio_injected" yaml:"is_istio_injected"`
+	// IsAmbientEnrolled is true if the namespace is labelled for ambient mode or contains at least one pod enrolled in ambient mode
+	IsAmbientEnrolled bool `json:"is_ambient_enrolled" yaml:"is_ambient_enrolled"`
+	// AmbientPods is the number of pods enrolled in ambient mode (pods with an injected sidecar are not counted)
+	AmbientPods int `json:"ambient_pods" yaml:"ambient_pods"`
+	// InjectionReasons is the number of pods for each reason istio injection was (or was not) applied, used to audit injection decisions
+	InjectionReasons map[string]int `json:"injection_reasons,omitempty" yaml:"injection_reasons,omitempty"`
+	// InjectedRevisions is the number of pods injected by each istio revision (revision tags are resolved to the revision they point to)
+	InjectedRevisions map[string]int `json:"injected_revisions,omitempty" yaml:"injected_revisions,omitempty"`
+	// PodsNeedingRestart is the number of injected pods whose sidecar version differs from the version of the revision which injects them today
+	PodsNeedingRestart int `json:"pods_needing_restart" yaml:"pods_needing_restart"`
+	// UnmatchedPodMetrics is the number of pod metrics which couldn't be joined to a pod, as the pod was deleted or created between listing the pods and getting the metrics
+	UnmatchedPodMetrics int `json:"unmatched_pod_metrics" yaml:"unmatched_pod_metrics"`
+	// IstioConfig is the number of Istio configuration objects of each kind (e.g. VirtualService, AuthorizationPolicy) in the namespace, only including kinds with objects
+	IstioConfig map[string]int `json:"istio_config,omitempty" yaml:"istio_config,omitempty"`
+	// SidecarScope is how Sidecar resources scope the configuration the namespace's sidecars receive, only set if the namespace has sidecars or Sidecar resources
+	SidecarScope *SidecarScope `json:"sidecar_scope,omitempty" yaml:"sidecar_scope,omitempty"`
+	// SidecarProfiles is the number of sidecars using the default proxy resources versus custom overrides, only set if the namespace has sidecars
+	SidecarProfiles *SidecarProfiles `json:"sidecar_profiles,omitempty" yaml:"sidecar_profiles,omitempty"`
+	// MTLS is the namespace's mTLS mode resolved from PeerAuthentications, only set if they could be read
+	MTLS *MTLSInfo `json:"mtls,omitempty" yaml:"mtls,omitempty"`
+	// EnvoyStats is the traffic of the namespace's sidecars and the size of their configuration, only set if Envoy stats are collected and the namespace has sidecars
+	EnvoyStats *EnvoyStats `json:"envoy_stats,omitempty" yaml:"envoy_stats,omitempty"`
+	// Gateways is the number of ingress and egress gateways and their replicas, only set if the namespace runs gateway pods
+	Gateways  *GatewayCounts `json:"gateways,omitempty" yaml:"gateways,omitempty"`
+	Resources ResourceInfo   `json:"resources" yaml:"resources"`
+	// Workloads is the breakdown of the namespace by each pod's top-level controller, keyed by "<kind>/<name>". Only set if workloads are collected.
+	Workloads map[string]*WorkloadInfo `json:"workloads,omitempty" yaml:"workloads,omitempty"`
+}
+
+// EnvoyStats represents the traffic of a namespace's sidecars and the size of their configuration, read from their Envoy stats
+type EnvoyStats struct {
+	// Sidecars is the number of sidecars whose stats were read
+	Sidecars int `json:"sidecars" yaml:"sidecars"`
+	// Failed is the number of sidecars whose stats couldn't be read, which aren't included in the totals
+	Failed int `json:"failed" yaml:"failed"`
+	// InboundRPS and OutboundRPS are the combined requests per second received and sent by the sidecars, averaged since each sidecar started
+	InboundRPS  float64 `json:"inbound_rps" yaml:"inbound_rps"`
+	OutboundRPS float64 `json:"outbound_rps" yaml:"outbound_rps"`
+	// ActiveConnections is the combined number of downstream connections currently open on the sidecars' listeners, which is 0
+	// unless the sidecars' stats include the listener stats
+	ActiveConnections int `json:"active_connections" yaml:"active_connections"`
+	// MaxClusters, MaxListeners and MaxRoutes are the largest number of clusters, listeners and route configurations in a sidecar's configuration
+	MaxClusters  int `json:"max_clusters" yaml:"max_clusters"`
+	MaxListeners int `json:"max_listeners" yaml:"max_listeners"`
+	MaxRoutes    int `json:"max_routes" yaml:"max_routes"`
+}
+
+// GatewayCounts represents the ingress and egress gateways of a namespace
+type GatewayCounts struct {
+	// Count is the number of distinct gateways, told apart by the Deployment (or other workload) running their pods
+	Count int `json:"count" yaml:"count"`
+	// Replicas is the number of gateway pods across all gateways
+	Replicas int `json:"replicas" yaml:"replicas"`
+}
+
+// WorkloadInfo represents information about a workload, which is the top-level controller of a group of pods (or a pod without a controller)
+type WorkloadInfo struct {
+	// Kind is the kind of the controller, such as Deployment, StatefulSet, DaemonSet, CronJob, Job, or Pod for pods without a controller
+	Kind string `json:"kind" yaml:"kind"`
+	// Replicas is the number of the workload's pods contributing to the resource totals
+	Replicas int `json:"replicas" yaml:"replicas"`
+	// IsIstioInjected is true if at least one of the workload's pods has istio injection enabled
+	IsIstioInjected bool `json:"is_istio_injected" yaml:"is_istio_injected"`
+	// IsAmbientEnrolled is true if at least one of the workload's pods is enrolled in ambient mode
+	IsAmbientEnrolled bool         `json:"is_ambient_enrolled" yaml:"is_ambient_enrolled"`
+	Resources         ResourceInfo `json:"resources" yaml:"resources"`
+}
+
+// PodPhaseCounts represents the number of pods in each phase other than running
+type PodPhaseCounts struct {
+	Pending   int `json:"pending" yaml:"pending"`
+	Succeeded int `json:"succeeded" yaml:"succeeded"`
+	// Failed includes evicted pods
+	Failed  int `json:"failed" yaml:"failed"`
+	Unknown int `json:"unknown" yaml:"unknown"`
+}
+
+// Where the default Sidecar resource of a namespace is defined
+const (
+	// SidecarScopeNamespace is a Sidecar without a workload selector in the namespace itself
+	SidecarScopeNamespace = "namespace"
+	// SidecarScopeMesh is the mesh-wide default Sidecar without a workload selector in the root namespace
+	SidecarScopeMesh = "mesh"
+)
+
+// SidecarScope represents how the Sidecar resources of a namespace scope the configuration its sidecars receive,
+// which dominates the sidecars' memory usage
+type SidecarScope struct {
+	// Resources is the number of Sidecar resources in the namespace
+	Resources int `json:"resources" yaml:"resources"`
+	// WorkloadResources is the number of Sidecar resources in the namespace which only apply to selected workloads
+	WorkloadResources int `json:"workload_resources" yaml:"workload_resources"`
+	// Default is where the Sidecar applying to the namespace's other workloads is defined ("namespace" or "mesh"), empty if there is none
+	Default string `json:"default,omitempty" yaml:"default,omitempty"`
+	// EgressScoped is true if the default Sidecar limits the hosts the sidecars receive configuration for
+	EgressScoped bool `json:"egress_scoped" yaml:"egress_scoped"`
+	// EgressHosts is the number of egress hosts of the default Sidecar
+	EgressHosts int `json:"egress_hosts" yaml:"egress_hosts"`
+	// FullMeshConfig is true if sidecars without a Sidecar resource of their own receive the configuration of the whole mesh
+	FullMeshConfig bool `json:"full_mesh_config" yaml:"full_mesh_config"`
+}
+
+// MTLSInfo represents the mTLS mode of a namespace, resolved from the mesh-wide and namespace-wide PeerAuthentications
+type MTLSInfo struct {
+	// Mode is the effective mode of the namespace's workloads without a policy of their own: STRICT, PERMISSIVE or DISABLE
+	Mode string `json:"mode" yaml:"mode"`
+	// MeshMode is the mode set by the mesh-wide policy in the Istio root namespace, empty if there is none
+	MeshMode string `json:"mesh_mode,omitempty" yaml:"mesh_mode,omitempty"`
+	// NamespaceMode is the mode set by the namespace-wide policy, empty if there is none
+	NamespaceMode string `json:"namespace_mode,omitempty" yaml:"namespace_mode,omitempty"`
+	// WorkloadPolicies is the number of policies in the namespace which only apply to selected workloads
+	WorkloadPolicies int `json:"workload_policies" yaml:"workload_policies"`
+	// PortOverrides is the number of ports setting their own mode across the namespace's workload policies
+	PortOverrides int `json:"port_overrides" yaml:"port_overrides"`
+}
+
+// SidecarProfiles represents how the sidecar proxies of a namespace have their resources configured
+type SidecarProfiles struct {
+	// Default is the number of sidecars using the mesh-wide default proxy resources
+	Default int `json:"default" yaml:"default"`
+	// Custom is the number of sidecars overriding at least one of their resources through sidecar.istio.io/proxy* annotations
+	Custom int `json:"custom" yaml:"custom"`
+	// Overrides is the number of sidecars setting each resource annotation
+	Overrides map[string]int `json:"overrides,omitempty" yaml:"overrides,omitempty"`
+	// ProxyConfigOverrides is the number of sidecars overriding their proxy configuration through the proxy.istio.io/config annotation
+	ProxyConfigOverrides int `json:"proxy_config_overrides" yaml:"proxy_config_overrides"`
+}
+
+// ProxyResources represents the resources configured for a proxy
+type ProxyResources struct {
+	Request Resources `json:"request" yaml:"request"`
+	Limit   Resources `json:"limit" yaml:"limit"`
 }
 
 // ResourceInfo represents resource information for a namespace
 type ResourceInfo struct {
 	Regular ContainerResources  `json:"regular" yaml:"regular"`
 	Istio   *ContainerResources `json:"istio,omitempty" yaml:"istio,omitempty"`
+	// Init is the classic (run-to-completion) init containers. Init containers running as native sidecars are counted as regular or istio containers.
+	Init *ContainerResources `json:"init,omitempty" yaml:"init,omitempty"`
+	// Ztunnel is the containers of the ambient mode ztunnel DaemonSet pods
+	Ztunnel *ContainerResources `json:"ztunnel,omitempty" yaml:"ztunnel,omitempty"`
+	// Waypoint is the containers of the ambient mode waypoint proxy pods
+	Waypoint *ContainerResources `json:"waypoint,omitempty" yaml:"waypoint,omitempty"`
+	// Gateway is the containers of the ingress and egress gateway pods, which are neither sidecars nor applications and remain in place after migrating to ambient mode
+	Gateway *ContainerResources `json:"gateway,omitempty" yaml:"gateway,omitempty"`
+	// UnattributedHistorical is the usage over the Prometheus lookback window of the containers of pods which aren't counted in the totals,
+	// such as pods replaced by a rollout. These can't be classified, as sidecars, ztunnel, waypoints and gateways all run an istio-proxy container.
+	// Only set if Prometheus is queried and such containers used resources.
+	UnattributedHistorical *UsagePercentiles `json:"unattributed_historical,omitempty" yaml:"unattributed_historical,omitempty"`
 }
 
 // ContainerResources represents a group of container resources
 type ContainerResources struct {
-	Containers int        `json:"containers" yaml:"containers"`
-	Request    Resources  `json:"request" yaml:"request"`
-	Actual     *Resources `json:"actual,omitempty" yaml:"actual,omitempty"`
+	Containers int       `json:"containers" yaml:"containers"`
+	Request    Resources `json:"request" yaml:"request"`
+	// Limit is the sum of the limits set, containers without a limit don't contribute to it
+	Limit  Resources  `json:"limit" yaml:"limit"`
+	Actual *Resources `json:"actual,omitempty" yaml:"actual,omitempty"`
+	// Historical is the usage over the Prometheus lookback window, only set if Prometheus is queried
+	Historical *UsagePercentiles `json:"historical,omitempty" yaml:"historical,omitempty"`
+	// Sampled is the usage sampled from the metrics API over the sampling window, only set if metrics are sampled.
+	// Actual is then the average usage.
+	Sampled *UsageStats `json:"sampled,omitempty" yaml:"sampled,omitempty"`
+	// MissingRequests is the number of containers which don't set both a CPU and a memory request
+	MissingRequests int `json:"missing_requests" yaml:"missing_requests"`
+	// MissingLimits is the number of containers which don't set both a CPU and a memory limit
+	MissingLimits int `json:"missing_limits" yaml:"missing_limits"`
+}
+
+// UsagePercentiles represents the distribution of the combined usage of a group of containers over time
+type UsagePercentiles struct {
+	P50 Resources `json:"p50" yaml:"p50"`
+	P95 Resources `json:"p95" yaml:"p95"`
+	Max Resources `json:"max" yaml:"max"`
+}
+
+// UsageStats represents the distribution of the combined usage of a group of containers (or of a node) across samples
+type UsageStats struct {
+	// Samples is the number of samples taken
+	Samples int       `json:"samples" yaml:"samples"`
+	Min     Resources `json:"min" yaml:"min"`
+	Avg     Resources `json:"avg" yaml:"avg"`
+	Max     Resources `json:"max" yaml:"max"`
+	P95     Resources `json:"p95" yaml:"p95"`
 }
 
 // Resources represents resource specifications
@@ -37,16 +332,60 @@ type Resources struct {
 
 // NodeInfo represents information about a Kubernetes node
 type NodeInfo struct {
-	InstanceType string        `json:"instance_type" yaml:"instance_type"`
-	Region       string        `json:"region" yaml:"region"`
-	Zone         string        `json:"zone" yaml:"zone"`
-	Resources    NodeResources `json:"resources" yaml:"resources"`
+	InstanceType string `json:"instance_type" yaml:"instance_type"`
+	Region       string `json:"region" yaml:"region"`
+	Zone         string `json:"zone" yaml:"zone"`
+	// Architecture, OS and KubeletVersion are reported by the node's kubelet
+	Architecture   string `json:"architecture,omitempty" yaml:"architecture,omitempty"`
+	OS             string `json:"os,omitempty" yaml:"os,omitempty"`
+	KubeletVersion string `json:"kubelet_version,omitempty" yaml:"kubelet_version,omitempty"`
+	// MaxPods is the number of pods which can be scheduled on the node
+	MaxPods int `json:"max_pods" yaml:"max_pods"`
+	// Taints are the node's taints, which keep pods (including DaemonSets such as ztunnel) without a matching toleration off the node
+	Taints []NodeTaint `json:"taints,omitempty" yaml:"taints,omitempty"`
+	// NodePool is the managed node pool the node belongs to, only set if it's recognized
+	NodePool  *NodePool     `json:"node_pool,omitempty" yaml:"node_pool,omitempty"`
+	Resources NodeResources `json:"resources" yaml:"resources"`
+	// Density is the pods scheduled on the node across all namespaces, and the resources of their sidecars
+	Density *NodeDensity `json:"density,omitempty" yaml:"density,omitempty"`
+}
+
+// NodeDensity represents the pods scheduled on a node, as ztunnel's cost scales per node while the sidecars' cost scales per pod
+type NodeDensity struct {
+	// Pods is the number of pods on the node which are counted in the namespace totals. Pods of namespaces which couldn't be
+	// collected aren't counted, as they aren't in the namespace totals either.
+	Pods int `json:"pods" yaml:"pods"`
+	// MeshedPods is the number of pods with an Istio sidecar or enrolled in ambient mode
+	MeshedPods int `json:"meshed_pods" yaml:"meshed_pods"`
+	// Sidecars are the resources of the Istio sidecars on the node
+	Sidecars ContainerResources `json:"sidecars" yaml:"sidecars"`
+}
+
+// NodeTaint represents a taint of a node
+type NodeTaint struct {
+	Key string `json:"key" yaml:"key"`
+	// Value is hashed when names are hidden
+	Value  string `json:"value,omitempty" yaml:"value,omitempty"`
+	Effect string `json:"effect" yaml:"effect"`
+}
+
+// NodePool represents the managed node pool of a node
+type NodePool struct {
+	// Provider is the provider managing the pool: "eks", "gke", "aks" or "karpenter"
+	Provider string `json:"provider" yaml:"provider"`
+	// Name is the name of the node group, node pool, agent pool or Karpenter NodePool, hashed when names are hidden
+	Name string `json:"name" yaml:"name"`
 }
 
 // NodeResources represents resource information for a node
 type NodeResources struct {
-	Capacity NodeResourceSpec  `json:"capacity" yaml:"capacity"`
-	Actual   *NodeResourceSpec `json:"actual,omitempty" yaml:"actual,omitempty"`
+	Capacity NodeResourceSpec `json:"capacity" yaml:"capacity"`
+	// Allocatable is the capacity left for pods, once the resources reserved for the system and kubelet are taken out
+	Allocatable NodeResourceSpec  `json:"allocatable" yaml:"allocatable"`
+	Actual      *NodeResourceSpec `json:"actual,omitempty" yaml:"actual,omitempty"`
+	// Sampled is the usage sampled from the metrics API over the sampling window, only set if metrics are sampled.
+	// Actual is then the average usage.
+	Sampled *UsageStats `json:"sampled,omitempty" yaml:"sampled,omitempty"`
 }
 
 // NodeResourceSpec represents resource specifications for a node
@@ -58,6 +397,7 @@ type NodeResourceSpec struct {
 // NewClusterInfo creates a new ClusterInfo with initialized maps
 func NewClusterInfo() *ClusterInfo {
 	return &ClusterInfo{
+		Metadata:   &Metadata{SchemaVersion: SchemaVersion},
 		Namespaces: make(map[string]*NamespaceInfo),
 		Nodes:      make(map[string]NodeInfo),
 	}
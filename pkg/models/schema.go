@@ -0,0 +1,11 @@
+package models
+
+import _ "embed"
+
+//go:generate go run ../../internal/schema/gen -output cluster_info.schema.json
+
+// Schema is the JSON Schema of the JSON encoding of ClusterInfo, generated from the types of this package.
+// Reports in YAML match it once converted to JSON.
+//
+//go:embed cluster_info.schema.json
+var Schema []byte
@@ -13,6 +13,7 @@ import (
 	"github.com/google/go-cmp/cmp/cmpopts"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/solo-io/istio-usage-collector/internal/schema"
 	"github.com/solo-io/istio-usage-collector/internal/utils"
 	"github.com/solo-io/istio-usage-collector/pkg/models"
 )
@@ -156,8 +157,18 @@ func runMainBinary(t *testing.T, config utils.Config) string {
 	return outputFilePath
 }
 
-// compareFiles compares the content of two JSON files using go-cmp.
+// compareFiles compares the content of two JSON files using go-cmp, after checking both match the report's schema.
 func compareFiles(file1, file2 string) error {
+	for _, file := range []string{file1, file2} {
+		problems, err := schema.ValidateFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to validate '%s': %v", file, err)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("'%s' doesn't match the report's schema: %v", file, problems)
+		}
+	}
+
 	content1, err := os.ReadFile(file1)
 	if err != nil {
 		return fmt.Errorf("failed to read file '%s': %v", file1, err)
@@ -192,6 +203,10 @@ func compareFiles(file1, file2 string) error {
 			_, irrelevant := irrelevantNamespaces[key]
 			return irrelevant
 		}),
+		cmpopts.IgnoreMapEntries(func(key string, value *models.NamespaceReadiness) bool {
+			_, irrelevant := irrelevantNamespaces[key]
+			return irrelevant
+		}),
 		// We only check for the presence (nil-ness) of the actual data, because actual usage varies
 		cmp.Transformer("ActualPresence", func(in *models.Resources) bool {
 			return in != nil
@@ -199,10 +214,19 @@ func compareFiles(file1, file2 string) error {
 		cmp.Transformer("NodeActualPresence", func(in *models.NodeResourceSpec) bool {
 			return in != nil
 		}),
-		// We ignore the capacity for nodes as it is dependent on the environment
+		// We ignore the capacity and allocatable resources for nodes as they are dependent on the environment
 		cmp.Transformer("NodeCapacityIgnore", func(in models.NodeResourceSpec) bool {
 			return true
 		}),
+		// The node's architecture and kubelet version depend on the host and kind version
+		cmpopts.IgnoreFields(models.NodeInfo{}, "Architecture", "KubeletVersion"),
+		// The number of pods on each node includes the pods of the namespaces which are ignored
+		cmpopts.IgnoreFields(models.NodeDensity{}, "Pods"),
+		// We ignore the istiod version and revision tags as they depend on the installed Istio chart version
+		cmpopts.IgnoreFields(models.RevisionInfo{}, "Version"),
+		cmpopts.IgnoreFields(models.ControlPlaneInfo{}, "RevisionTags"),
+		// Only the schema version is checked, the rest of the metadata depends on the build and when the collection ran
+		cmpopts.IgnoreFields(models.Metadata{}, "CollectorVersion", "CollectorCommit", "KubernetesVersion", "StartedAt", "FinishedAt", "DurationSeconds", "Flags"),
 	}
 
 	// Compare the unmarshalled data
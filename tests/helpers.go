@@ -1,9 +1,18 @@
 package tests
 
 import (
+	"bytes"
+	"context"
+	"io"
+
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 	v1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
 )
 
@@ -26,6 +35,9 @@ func NewPod(namespace, name, nodeName string, cpuRequest, memRequest string, has
 				},
 			},
 		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+		},
 	}
 	if cpuRequest != "" {
 		pod.Spec.Containers[0].Resources.Requests[corev1.ResourceCPU] = resource.MustParse(cpuRequest)
@@ -49,6 +61,55 @@ func NewPod(namespace, name, nodeName string, cpuRequest, memRequest string, has
 	return pod
 }
 
+// Helper function to add an init container to a pod, optionally running as a native sidecar (restartPolicy: Always)
+func AddInitContainer(pod *corev1.Pod, name, cpuRequest, memRequest string, nativeSidecar bool) *corev1.Pod {
+	container := corev1.Container{
+		Name: name,
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse(cpuRequest),
+				corev1.ResourceMemory: resource.MustParse(memRequest),
+			},
+		},
+	}
+	if nativeSidecar {
+		restartPolicy := corev1.ContainerRestartPolicyAlways
+		container.RestartPolicy = &restartPolicy
+	}
+	pod.Spec.InitContainers = append(pod.Spec.InitContainers, container)
+	return pod
+}
+
+// Helper function to set the resource limits of a pod's container
+func SetLimits(pod *corev1.Pod, containerName, cpuLimit, memLimit string) *corev1.Pod {
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name != containerName {
+			continue
+		}
+		limits := corev1.ResourceList{}
+		if cpuLimit != "" {
+			limits[corev1.ResourceCPU] = resource.MustParse(cpuLimit)
+		}
+		if memLimit != "" {
+			limits[corev1.ResourceMemory] = resource.MustParse(memLimit)
+		}
+		pod.Spec.Containers[i].Resources.Limits = limits
+	}
+	return pod
+}
+
+// Helper function to set the controller of an object, such as the ReplicaSet of a pod or the Deployment of a ReplicaSet
+func SetController(object metav1.Object, apiVersion, kind, name string) {
+	isController := true
+	object.SetOwnerReferences(append(object.GetOwnerReferences(), metav1.OwnerReference{
+		APIVersion: apiVersion,
+		Kind:       kind,
+		Name:       name,
+		UID:        types.UID(kind + "-" + name),
+		Controller: &isController,
+	}))
+}
+
 // Helper function to create pod metrics
 func NewPodMetrics(namespace, name string, cpuUsage, memUsage string, hasIstioProxy bool, istioCpuUsage, istioMemUsage string) *v1beta1.PodMetrics {
 	metrics := &v1beta1.PodMetrics{
@@ -107,3 +168,64 @@ func NewNodeMetrics(name, cpuUsage, memUsage string) *v1beta1.NodeMetrics {
 		},
 	}
 }
+
+// istioKinds are the kinds of Istio's custom resources, keyed by group and resource
+var istioKinds = map[schema.GroupResource]string{
+	{Group: "networking.istio.io", Resource: "virtualservices"}:      "VirtualService",
+	{Group: "networking.istio.io", Resource: "destinationrules"}:     "DestinationRule",
+	{Group: "networking.istio.io", Resource: "serviceentries"}:       "ServiceEntry",
+	{Group: "networking.istio.io", Resource: "sidecars"}:             "Sidecar",
+	{Group: "networking.istio.io", Resource: "envoyfilters"}:         "EnvoyFilter",
+	{Group: "networking.istio.io", Resource: "proxyconfigs"}:         "ProxyConfig",
+	{Group: "security.istio.io", Resource: "authorizationpolicies"}:  "AuthorizationPolicy",
+	{Group: "security.istio.io", Resource: "peerauthentications"}:    "PeerAuthentication",
+	{Group: "security.istio.io", Resource: "requestauthentications"}: "RequestAuthentication",
+	{Group: "telemetry.istio.io", Resource: "telemetries"}:           "Telemetry",
+	{Group: "extensions.istio.io", Resource: "wasmplugins"}:          "WasmPlugin",
+}
+
+// istioVersions are the versions Istio's custom resources are served at across releases
+var istioVersions = []string{"v1", "v1beta1", "v1alpha3", "v1alpha1"}
+
+// Helper function to create an Istio custom resource, as read through the dynamic client. The spec is left out if nil.
+func NewIstioObject(apiVersion, kind, namespace, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	object := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"name": name, "namespace": namespace},
+	}}
+	if spec != nil {
+		object.Object["spec"] = spec
+	}
+	return object
+}
+
+// Helper function to create a fake dynamic client which can list Istio's custom resources at every version
+func NewIstioDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	listKinds := make(map[schema.GroupVersionResource]string)
+	for groupResource, kind := range istioKinds {
+		for _, version := range istioVersions {
+			listKinds[groupResource.WithVersion(version)] = kind + "List"
+		}
+	}
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...)
+}
+
+// ProxyResponse is a response of a pod proxy subresource, returned by the proxy reactors of fake clientsets
+type ProxyResponse struct {
+	Data []byte
+	Err  error
+}
+
+// DoRaw returns the response's data or error
+func (r *ProxyResponse) DoRaw(context.Context) ([]byte, error) {
+	return r.Data, r.Err
+}
+
+// Stream returns a reader of the response's data or its error
+func (r *ProxyResponse) Stream(context.Context) (io.ReadCloser, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return io.NopCloser(bytes.NewReader(r.Data)), nil
+}